@@ -0,0 +1,254 @@
+package geoindex
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dsoprea/go-logging"
+	"github.com/dsoprea/go-time-index"
+)
+
+// AggregationPeriod is the size of the windows that records are aggregated
+// into.
+type AggregationPeriod int
+
+const (
+	AggregateByMinute AggregationPeriod = iota
+	AggregateByHour
+	AggregateByDay
+
+	// AggregateByIsoWeek windows start on Monday.
+	AggregateByIsoWeek
+
+	AggregateByMonth
+	AggregateByYear
+)
+
+func (ap AggregationPeriod) String() string {
+	switch ap {
+	case AggregateByMinute:
+		return "minute"
+	case AggregateByHour:
+		return "hour"
+	case AggregateByDay:
+		return "day"
+	case AggregateByIsoWeek:
+		return "iso-week"
+	case AggregateByMonth:
+		return "month"
+	case AggregateByYear:
+		return "year"
+	}
+
+	return fmt.Sprintf("unknown<%d>", int(ap))
+}
+
+// isValid returns true if this is one of the periods above.
+func (ap AggregationPeriod) isValid() bool {
+	return ap >= AggregateByMinute && ap <= AggregateByYear
+}
+
+// start returns the start of the window that has the given time. Windows are
+// aligned to the wall-clock in the given location.
+func (ap AggregationPeriod) start(t time.Time, location *time.Location) time.Time {
+	t = t.In(location)
+
+	switch ap {
+	case AggregateByMinute:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, location)
+	case AggregateByHour:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, location)
+	case AggregateByDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, location)
+	case AggregateByIsoWeek:
+		// Sunday is zero but is the last day of an ISO week.
+		sinceMonday := (int(t.Weekday()) + 6) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-sinceMonday, 0, 0, 0, 0, location)
+	case AggregateByMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, location)
+	case AggregateByYear:
+		return time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, location)
+	}
+
+	log.Panicf("aggregation period not valid: (%d)", ap)
+	return time.Time{}
+}
+
+// next returns the start of the window after the one starting at `start`.
+func (ap AggregationPeriod) next(start time.Time) time.Time {
+	location := start.Location()
+
+	switch ap {
+	case AggregateByMinute:
+		return start.Add(time.Minute)
+	case AggregateByHour:
+		return start.Add(time.Hour)
+	case AggregateByDay:
+		return time.Date(start.Year(), start.Month(), start.Day()+1, 0, 0, 0, 0, location)
+	case AggregateByIsoWeek:
+		return time.Date(start.Year(), start.Month(), start.Day()+7, 0, 0, 0, 0, location)
+	case AggregateByMonth:
+		return time.Date(start.Year(), start.Month()+1, 1, 0, 0, 0, 0, location)
+	case AggregateByYear:
+		return time.Date(start.Year()+1, time.January, 1, 0, 0, 0, 0, location)
+	}
+
+	log.Panicf("aggregation period not valid: (%d)", ap)
+	return time.Time{}
+}
+
+// BoundingBox is a rectangle of coordinates. Boxes that cross the
+// antimeridian are not supported.
+type BoundingBox struct {
+	MinLatitude  float64
+	MinLongitude float64
+	MaxLatitude  float64
+	MaxLongitude float64
+}
+
+// Contains returns true if the coordinate is inside the box or on its edge.
+func (bb BoundingBox) Contains(latitude, longitude float64) bool {
+	return latitude >= bb.MinLatitude && latitude <= bb.MaxLatitude && longitude >= bb.MinLongitude && longitude <= bb.MaxLongitude
+}
+
+// extend grows the box to include the given coordinate.
+func (bb *BoundingBox) extend(latitude, longitude float64) {
+	if latitude < bb.MinLatitude {
+		bb.MinLatitude = latitude
+	} else if latitude > bb.MaxLatitude {
+		bb.MaxLatitude = latitude
+	}
+
+	if longitude < bb.MinLongitude {
+		bb.MinLongitude = longitude
+	} else if longitude > bb.MaxLongitude {
+		bb.MaxLongitude = longitude
+	}
+}
+
+func (bb BoundingBox) String() string {
+	return fmt.Sprintf("BoundingBox<MIN=(%.6f, %.6f) MAX=(%.6f, %.6f)>", bb.MinLatitude, bb.MinLongitude, bb.MaxLatitude, bb.MaxLongitude)
+}
+
+// AggregationBucket summarizes the records in one window.
+type AggregationBucket struct {
+	// Start is the start of the window (inclusive) and End is the start of the
+	// next one (exclusive).
+	Start time.Time
+	End   time.Time
+
+	RecordCount  int
+	SourceCounts map[string]int
+
+	First *GeographicRecord
+	Last  *GeographicRecord
+
+	// BoundingBox covers every record with geographic data. It is only valid
+	// if HasBoundingBox is true.
+	HasBoundingBox bool
+	BoundingBox    BoundingBox
+
+	// Distance is the total great-circle distance in meters between
+	// consecutive data-log points in the window.
+	Distance float64
+}
+
+func (ab AggregationBucket) String() string {
+	return fmt.Sprintf("AggregationBucket<START=[%s] RECORDS=(%d) DISTANCE=(%.1f)>", ab.Start, ab.RecordCount, ab.Distance)
+}
+
+// aggregateSeries summarizes the records in the given series. Only windows
+// that have records are returned, in time order.
+func aggregateSeries(ts timeindex.TimeSlice, period AggregationPeriod, location *time.Location) (buckets []AggregationBucket, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	if period.isValid() == false {
+		log.Panicf("aggregation period not valid: (%d)", period)
+	}
+
+	if location == nil {
+		location = time.UTC
+	}
+
+	buckets = make([]AggregationBucket, 0)
+
+	var current *AggregationBucket
+	var currentRecords []*GeographicRecord
+
+	flush := func() {
+		if current == nil {
+			return
+		}
+
+		current.Distance = trackDistanceMeters(currentRecords)
+		buckets = append(buckets, *current)
+	}
+
+	for _, timeItem := range ts {
+		if current == nil || timeItem.Time.Before(current.End) == false {
+			flush()
+
+			start := period.start(timeItem.Time, location)
+
+			current = &AggregationBucket{
+				Start:        start,
+				End:          period.next(start),
+				SourceCounts: make(map[string]int),
+			}
+
+			currentRecords = make([]*GeographicRecord, 0)
+		}
+
+		for _, item := range timeItem.Items {
+			gr := item.(*GeographicRecord)
+
+			current.RecordCount++
+			current.SourceCounts[gr.SourceName]++
+
+			if current.First == nil {
+				current.First = gr
+			}
+
+			current.Last = gr
+
+			if gr.HasGeographic == true {
+				if current.HasBoundingBox == false {
+					current.BoundingBox = BoundingBox{
+						MinLatitude:  gr.Latitude,
+						MinLongitude: gr.Longitude,
+						MaxLatitude:  gr.Latitude,
+						MaxLongitude: gr.Longitude,
+					}
+
+					current.HasBoundingBox = true
+				} else {
+					current.BoundingBox.extend(gr.Latitude, gr.Longitude)
+				}
+			}
+
+			currentRecords = append(currentRecords, gr)
+		}
+	}
+
+	flush()
+
+	return buckets, nil
+}
+
+// Aggregate summarizes the records in the index by window. Windows are
+// aligned to the wall-clock in the given location (UTC if `nil`), so, for
+// example, days start at midnight local time. Only windows that have records
+// are returned, in time order. Returns an error if the period is not valid.
+func (index *TimeIndex) Aggregate(period AggregationPeriod, location *time.Location) (buckets []AggregationBucket, err error) {
+	return aggregateSeries(index.ts, period, location)
+}
+
+// AggregateRange is `Aggregate` for the records from `from` (inclusive) to
+// `to` (exclusive).
+func (index *TimeIndex) AggregateRange(from, to time.Time, period AggregationPeriod, location *time.Location) (buckets []AggregationBucket, err error) {
+	return aggregateSeries(index.Range(from, to), period, location)
+}
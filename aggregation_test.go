@@ -0,0 +1,122 @@
+package geoindex
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dsoprea/go-logging"
+)
+
+func TestAggregationPeriod_start(t *testing.T) {
+	location := time.FixedZone("test", -5*3600)
+
+	// This is a Sunday in the test location but a Monday in UTC.
+	timestamp := time.Date(2020, 3, 9, 3, 30, 15, 0, time.UTC)
+
+	expected := map[AggregationPeriod]time.Time{
+		AggregateByMinute:  time.Date(2020, 3, 8, 22, 30, 0, 0, location),
+		AggregateByHour:    time.Date(2020, 3, 8, 22, 0, 0, 0, location),
+		AggregateByDay:     time.Date(2020, 3, 8, 0, 0, 0, 0, location),
+		AggregateByIsoWeek: time.Date(2020, 3, 2, 0, 0, 0, 0, location),
+		AggregateByMonth:   time.Date(2020, 3, 1, 0, 0, 0, 0, location),
+		AggregateByYear:    time.Date(2020, 1, 1, 0, 0, 0, 0, location),
+	}
+
+	for period, expectedStart := range expected {
+		start := period.start(timestamp, location)
+		if start.Equal(expectedStart) != true {
+			t.Fatalf("Start for [%s] not correct: [%s] != [%s]", period, start, expectedStart)
+		}
+	}
+
+	if next := AggregateByMonth.next(expected[AggregateByMonth]); next.Equal(time.Date(2020, 4, 1, 0, 0, 0, 0, location)) != true {
+		t.Fatalf("Next month not correct: [%s]", next)
+	}
+}
+
+func TestTimeIndex_Aggregate(t *testing.T) {
+	ti := NewTimeIndex()
+
+	location := time.FixedZone("test", 2*3600)
+	day1 := time.Date(2020, 6, 1, 8, 0, 0, 0, location)
+	day2 := day1.AddDate(0, 0, 1)
+
+	records := []*GeographicRecord{
+		NewGeographicRecord(SourceGeographicGpx, "data.gpx", day1, true, 10.0, 20.0, nil),
+		NewGeographicRecord(SourceGeographicGpx, "data.gpx", day1.Add(time.Minute), true, 10.01, 20.0, nil),
+		NewGeographicRecord(SourceImageJpeg, "image.jpg", day1.Add(time.Minute*2), true, 10.5, 19.5, nil),
+		NewGeographicRecord(SourceGeographicGpx, "data.gpx", day1.Add(time.Minute*3), true, 10.02, 20.0, nil),
+
+		// This is still the first day in the test location but not in UTC.
+		NewGeographicRecord(SourceImageJpeg, "image2.jpg", time.Date(2020, 6, 1, 23, 30, 0, 0, location), false, 0, 0, nil),
+
+		NewGeographicRecord(SourceGeographicGpx, "data2.gpx", day2, true, 30.0, 40.0, nil),
+	}
+
+	err := ti.AddRecords(records)
+	log.PanicIf(err)
+
+	buckets, err := ti.Aggregate(AggregateByDay, location)
+	log.PanicIf(err)
+
+	if len(buckets) != 2 {
+		t.Fatalf("Bucket count not correct: (%d)", len(buckets))
+	}
+
+	ab := buckets[0]
+	if ab.Start.Equal(time.Date(2020, 6, 1, 0, 0, 0, 0, location)) != true || ab.End.Equal(time.Date(2020, 6, 2, 0, 0, 0, 0, location)) != true {
+		t.Fatalf("Window not correct: [%s] [%s]", ab.Start, ab.End)
+	} else if ab.RecordCount != 5 {
+		t.Fatalf("Record count not correct: (%d)", ab.RecordCount)
+	} else if ab.SourceCounts[SourceGeographicGpx] != 3 || ab.SourceCounts[SourceImageJpeg] != 2 {
+		t.Fatalf("Source counts not correct: %v", ab.SourceCounts)
+	} else if ab.First != records[0] || ab.Last != records[4] {
+		t.Fatalf("First or last record not correct: %s %s", ab.First, ab.Last)
+	}
+
+	expectedBb := BoundingBox{
+		MinLatitude:  10.0,
+		MinLongitude: 19.5,
+		MaxLatitude:  10.5,
+		MaxLongitude: 20.0,
+	}
+
+	if ab.HasBoundingBox != true || ab.BoundingBox != expectedBb {
+		t.Fatalf("Bounding box not correct: %s", ab.BoundingBox)
+	}
+
+	// The image is not part of the track so we've traveled about 2.2km.
+	if ab.Distance < 2200 || ab.Distance > 2250 {
+		t.Fatalf("Distance not correct: (%.1f)", ab.Distance)
+	}
+
+	ab = buckets[1]
+	if ab.RecordCount != 1 || ab.Distance != 0 || ab.First != records[5] {
+		t.Fatalf("Second bucket not correct: %s", ab)
+	}
+
+	buckets, err = ti.AggregateRange(day1, day1.Add(time.Minute*2), AggregateByMinute, location)
+	log.PanicIf(err)
+
+	if len(buckets) != 2 || buckets[0].RecordCount != 1 || buckets[1].RecordCount != 1 {
+		t.Fatalf("Range buckets not correct: %v", buckets)
+	}
+}
+
+func TestTimeIndex_Aggregate_InvalidPeriod(t *testing.T) {
+	ti := NewTimeIndex()
+
+	err := ti.Add(SourceGeographicGpx, "data.gpx", time.Now(), true, 10.0, 20.0, nil)
+	log.PanicIf(err)
+
+	_, err = ti.Aggregate(AggregationPeriod(99), nil)
+	if err == nil {
+		t.Fatalf("Expected error for invalid period.")
+	}
+
+	// The period is checked even if there is nothing to aggregate.
+	_, err = NewTimeIndex().Aggregate(AggregationPeriod(-1), nil)
+	if err == nil {
+		t.Fatalf("Expected error for invalid period with no records.")
+	}
+}
@@ -0,0 +1,209 @@
+package geoindex
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"encoding/csv"
+
+	"github.com/dsoprea/go-logging"
+	"github.com/golang/geo/s2"
+)
+
+const (
+	// maximumS2Level is the level of S2 leaf cells.
+	maximumS2Level = 30
+)
+
+// CsvColumn is a column that can be written by `TimeIndex.ExportCsv`.
+type CsvColumn int
+
+const (
+	CsvTimestamp CsvColumn = iota
+	CsvLatitude
+	CsvLongitude
+	CsvS2Token
+	CsvSource
+	CsvFilepath
+	CsvCameraModel
+	CsvComments
+)
+
+// DefaultCsvColumns are the columns that are written if none are given.
+var DefaultCsvColumns = []CsvColumn{
+	CsvTimestamp,
+	CsvLatitude,
+	CsvLongitude,
+	CsvS2Token,
+	CsvSource,
+	CsvFilepath,
+	CsvCameraModel,
+	CsvComments,
+}
+
+// String returns the name of the column, which is also its header.
+func (cc CsvColumn) String() string {
+	switch cc {
+	case CsvTimestamp:
+		return "timestamp"
+	case CsvLatitude:
+		return "latitude"
+	case CsvLongitude:
+		return "longitude"
+	case CsvS2Token:
+		return "s2_token"
+	case CsvSource:
+		return "source_name"
+	case CsvFilepath:
+		return "filepath"
+	case CsvCameraModel:
+		return "camera_model"
+	case CsvComments:
+		return "comments"
+	}
+
+	return fmt.Sprintf("CsvColumn<%d>", int(cc))
+}
+
+// CsvExportOptions determines what is written by `TimeIndex.ExportCsv`.
+type CsvExportOptions struct {
+	// Columns are the columns to write, in order. Defaults to
+	// `DefaultCsvColumns`.
+	Columns []CsvColumn
+
+	// Delimiter separates the fields. Defaults to a comma. Use a tab for TSV.
+	Delimiter rune
+
+	// OmitHeader skips the header row.
+	OmitHeader bool
+
+	// Location is the time zone that timestamps are written in. Defaults to
+	// UTC.
+	Location *time.Location
+
+	// TimestampLayout is the format of timestamps (see `time.Time.Format`).
+	// Defaults to RFC 3339.
+	TimestampLayout string
+
+	// HasS2Level writes the token for the S2 cell at S2Level, from 0 (a cube
+	// face) to 30, rather than for the cell of the coordinate itself.
+	HasS2Level bool
+	S2Level    int
+
+	// CommentSeparator separates multiple comments in one field. Defaults to
+	// "; ".
+	CommentSeparator string
+
+	// Filter selects the records to export.
+	Filter RecordFilter
+}
+
+// csvField returns the value of the given column for the record. The
+// geographic columns are empty for records without geographic data.
+func csvField(gr *GeographicRecord, column CsvColumn, options CsvExportOptions) string {
+	switch column {
+	case CsvTimestamp:
+		return gr.Timestamp.In(options.Location).Format(options.TimestampLayout)
+	case CsvLatitude:
+		if gr.HasGeographic == false {
+			return ""
+		}
+
+		return strconv.FormatFloat(gr.Latitude, 'f', -1, 64)
+	case CsvLongitude:
+		if gr.HasGeographic == false {
+			return ""
+		}
+
+		return strconv.FormatFloat(gr.Longitude, 'f', -1, 64)
+	case CsvS2Token:
+		if gr.HasGeographic == false {
+			return ""
+		}
+
+		cellId := s2.CellID(gr.S2CellId)
+		if options.HasS2Level == true && options.S2Level < cellId.Level() {
+			cellId = cellId.Parent(options.S2Level)
+		}
+
+		return cellId.ToToken()
+	case CsvSource:
+		return gr.SourceName
+	case CsvFilepath:
+		return gr.Filepath
+	case CsvCameraModel:
+		return recordCameraModel(gr)
+	case CsvComments:
+		return strings.Join(gr.Comments(), options.CommentSeparator)
+	}
+
+	log.Panicf("column not valid: (%d)", int(column))
+	return ""
+}
+
+// ExportCsv writes one row for every record that satisfies the filter, in
+// time order, with the selected columns.
+func (index *TimeIndex) ExportCsv(w io.Writer, options CsvExportOptions) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	if options.Columns == nil {
+		options.Columns = DefaultCsvColumns
+	}
+
+	if options.Delimiter == 0 {
+		options.Delimiter = ','
+	}
+
+	if options.Location == nil {
+		options.Location = time.UTC
+	}
+
+	if options.TimestampLayout == "" {
+		options.TimestampLayout = time.RFC3339
+	}
+
+	if options.HasS2Level == true && (options.S2Level < 0 || options.S2Level > maximumS2Level) {
+		log.Panicf("S2 level not valid: (%d)", options.S2Level)
+	}
+
+	if options.CommentSeparator == "" {
+		options.CommentSeparator = "; "
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Comma = options.Delimiter
+
+	if options.OmitHeader == false {
+		header := make([]string, len(options.Columns))
+		for i, column := range options.Columns {
+			header[i] = column.String()
+		}
+
+		err := cw.Write(header)
+		log.PanicIf(err)
+	}
+
+	for _, gr := range index.filteredRecords(options.Filter) {
+		row := make([]string, len(options.Columns))
+		for i, column := range options.Columns {
+			row[i] = csvField(gr, column, options)
+		}
+
+		err := cw.Write(row)
+		log.PanicIf(err)
+	}
+
+	cw.Flush()
+
+	err = cw.Error()
+	log.PanicIf(err)
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package geoindex
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/dsoprea/go-logging"
+	"github.com/golang/geo/s2"
+)
+
+func csvTestRecords() (records []*GeographicRecord) {
+	epoch := time.Date(2020, 6, 1, 23, 30, 0, 0, time.UTC)
+
+	records = []*GeographicRecord{
+		NewGeographicRecord(SourceGeographicGpx, "ride.gpx", epoch, true, 10.5, 20.25, nil),
+		NewGeographicRecord(SourceImageJpeg, "some, image.jpg", epoch.Add(time.Minute), true, 10.5, 20.25, ImageMetadata{CameraModel: "some camera"}),
+		NewGeographicRecord(SourceImageJpeg, "image2.jpg", epoch.Add(time.Minute*2), false, 0, 0, nil),
+	}
+
+	records[1].AddComment("first")
+	records[1].AddComment("second")
+
+	return records
+}
+
+func TestTimeIndex_ExportCsv(t *testing.T) {
+	records := csvTestRecords()
+	ti := getTestIndex(records)
+
+	buffer := new(bytes.Buffer)
+
+	err := ti.ExportCsv(buffer, CsvExportOptions{})
+	log.PanicIf(err)
+
+	token := s2.CellID(records[0].S2CellId).ToToken()
+
+	expected := `timestamp,latitude,longitude,s2_token,source_name,filepath,camera_model,comments
+2020-06-01T23:30:00Z,10.5,20.25,` + token + `,data-geographic-gpx,ride.gpx,,
+2020-06-01T23:31:00Z,10.5,20.25,` + token + `,image-jpeg,"some, image.jpg",some camera,first; second
+2020-06-01T23:32:00Z,,,,image-jpeg,image2.jpg,,
+`
+
+	actual := buffer.String()
+
+	if actual != expected {
+		t.Fatalf("CSV not correct:\n%s", actual)
+	}
+}
+
+func TestTimeIndex_ExportCsv_Options(t *testing.T) {
+	records := csvTestRecords()
+	ti := getTestIndex(records)
+
+	options := CsvExportOptions{
+		Columns:          []CsvColumn{CsvTimestamp, CsvS2Token, CsvComments},
+		Delimiter:        '\t',
+		OmitHeader:       true,
+		Location:         time.FixedZone("UTC+2", 2*60*60),
+		TimestampLayout:  "2006-01-02 15:04",
+		HasS2Level:       true,
+		S2Level:          10,
+		CommentSeparator: "|",
+		Filter: RecordFilter{
+			SourceNames: []string{SourceImageJpeg},
+		},
+	}
+
+	buffer := new(bytes.Buffer)
+
+	err := ti.ExportCsv(buffer, options)
+	log.PanicIf(err)
+
+	token := s2.CellID(records[1].S2CellId).Parent(10).ToToken()
+
+	expected := "2020-06-02 01:31\t" + token + "\tfirst|second\n" +
+		"2020-06-02 01:32\t\t\n"
+
+	actual := buffer.String()
+
+	if actual != expected {
+		t.Fatalf("TSV not correct:\n%s", actual)
+	}
+}
+
+func TestTimeIndex_ExportCsv_InvalidS2Level(t *testing.T) {
+	ti := getTestIndex(csvTestRecords())
+
+	err := ti.ExportCsv(new(bytes.Buffer), CsvExportOptions{HasS2Level: true, S2Level: 31})
+	if err == nil {
+		t.Fatalf("Expected error for invalid S2 level.")
+	}
+}
+
+func TestTimeIndex_ExportCsv_FaceS2Level(t *testing.T) {
+	records := csvTestRecords()
+	ti := getTestIndex(records)
+
+	options := CsvExportOptions{
+		Columns:    []CsvColumn{CsvS2Token},
+		OmitHeader: true,
+		HasS2Level: true,
+		S2Level:    0,
+		Filter: RecordFilter{
+			SourceNames: []string{SourceImageJpeg},
+		},
+	}
+
+	buffer := new(bytes.Buffer)
+
+	err := ti.ExportCsv(buffer, options)
+	log.PanicIf(err)
+
+	token := s2.CellID(records[1].S2CellId).Parent(0).ToToken()
+
+	// The second image has no geographic data.
+	expected := token + "\n\n"
+
+	actual := buffer.String()
+
+	if actual != expected {
+		t.Fatalf("CSV not correct:\n%s", actual)
+	}
+}
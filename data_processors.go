@@ -1,6 +1,7 @@
 package geoindex
 
 import (
+	"io"
 	"os"
 
 	"github.com/dsoprea/go-gpx"
@@ -13,6 +14,7 @@ var (
 )
 
 type GpxDataFileProcessor struct {
+	skippedRecordCb SkippedRecordFunc
 }
 
 func NewGpxDataFileProcessor() *GpxDataFileProcessor {
@@ -23,6 +25,12 @@ func (gdfp *GpxDataFileProcessor) Name() string {
 	return "GpxDataFileProcessor"
 }
 
+// SetSkippedRecordCallback sets a callback to be called for every point that
+// is skipped because its coordinates are not valid.
+func (gdfp *GpxDataFileProcessor) SetSkippedRecordCallback(cb SkippedRecordFunc) {
+	gdfp.skippedRecordCb = cb
+}
+
 func (gdfp *GpxDataFileProcessor) Process(ti *TimeIndex, gi *GeographicIndex, filepath string) (err error) {
 	defer func() {
 		if state := recover(); state != nil {
@@ -35,15 +43,38 @@ func (gdfp *GpxDataFileProcessor) Process(ti *TimeIndex, gi *GeographicIndex, fi
 
 	defer f.Close()
 
-	counter := 0
+	err = gdfp.ProcessReader(ti, gi, filepath, f)
+	log.PanicIf(err)
+
+	return nil
+}
+
+// ProcessReader reads GPX data from the given stream. This allows us to
+// process compressed files.
+func (gdfp *GpxDataFileProcessor) ProcessReader(ti *TimeIndex, gi *GeographicIndex, filepath string, r io.Reader) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	// We add to the time-index in bulk once we've read the whole file. This
+	// is much faster for large tracks.
+	records := make([]*GeographicRecord, 0)
+
+	// The position of every point in the file, including those that we skip,
+	// so that record IDs don't depend on what we skip.
+	position := -1
 
 	tpc := func(tp *gpxcommon.TrackPoint) (err error) {
+		position++
+
 		if tp.Time.IsZero() == true {
 			dataLogger.Warningf(nil, "Skipping zero-time record: [%s] %s", filepath, tp)
 			return nil
 		}
 
-		gr := NewGeographicRecord(
+		gr, err := NewValidatedGeographicRecord(
 			SourceGeographicGpx,
 			filepath,
 			tp.Time,
@@ -52,9 +83,28 @@ func (gdfp *GpxDataFileProcessor) Process(ti *TimeIndex, gi *GeographicIndex, fi
 			tp.LongitudeDecimal,
 			nil)
 
-		if ti != nil {
-			err := ti.AddWithRecord(gr)
-			log.PanicIf(err)
+		if err == ErrInvalidCoordinates {
+			dataLogger.Warningf(nil, "Skipping record with invalid coordinates: [%s] %s", filepath, tp)
+
+			if gdfp.skippedRecordCb != nil {
+				err := gdfp.skippedRecordCb(filepath, SkipReasonInvalidCoordinates)
+				log.PanicIf(err)
+			}
+
+			return nil
+		}
+
+		log.PanicIf(err)
+
+		gr.Position = position
+
+		// The reader doesn't distinguish a missing elevation from zero, so we
+		// treat zero as missing. A point that is exactly at sea level loses
+		// its altitude, which is preferable to giving every point from a file
+		// without elevations an altitude of zero.
+		if tp.Elevation != 0 {
+			gr.HasAltitude = true
+			gr.Altitude = tp.Elevation
 		}
 
 		if gi != nil {
@@ -62,15 +112,20 @@ func (gdfp *GpxDataFileProcessor) Process(ti *TimeIndex, gi *GeographicIndex, fi
 			log.PanicIf(err)
 		}
 
-		counter++
+		records = append(records, gr)
 
 		return nil
 	}
 
-	err = gpxreader.EnumerateTrackPoints(f, tpc)
+	err = gpxreader.EnumerateTrackPoints(r, tpc)
 	log.PanicIf(err)
 
-	dataLogger.Infof(nil, "Read (%d) records from [%s].", counter, filepath)
+	if ti != nil {
+		err := ti.AddRecords(records)
+		log.PanicIf(err)
+	}
+
+	dataLogger.Infof(nil, "Read (%d) records from [%s].", len(records), filepath)
 
 	return nil
 }
@@ -3,6 +3,7 @@ package geoindex
 import (
 	"path"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/dsoprea/go-logging"
@@ -48,4 +49,62 @@ func TestGpxDataFileProcessor_WithTimeRecords(t *testing.T) {
 	if reflect.DeepEqual(actual, expected) != true {
 		t.Fatalf("Records incorrect: %v", actual)
 	}
+
+	altitudes := make([]float64, 0)
+	for _, gr := range index.records() {
+		if gr.HasAltitude != true {
+			t.Fatalf("Record has no altitude: %s", gr)
+		}
+
+		altitudes = append(altitudes, gr.Altitude)
+	}
+
+	if reflect.DeepEqual(altitudes, []float64{4.46, 4.94, 6.87}) != true {
+		t.Fatalf("Altitudes incorrect: %v", altitudes)
+	}
+
+	stats := index.TrackStatistics(0)
+	if stats.HasElevation != true || stats.ElevationGain < 2.40 || stats.ElevationGain > 2.42 {
+		t.Fatalf("Elevation gain not correct: (%.2f)", stats.ElevationGain)
+	}
+}
+
+func TestGpxDataFileProcessor_SkipInvalidCoordinates(t *testing.T) {
+	index := NewTimeIndex()
+
+	data := `<?xml version="1.0" encoding="UTF-8"?>
+<gpx xmlns="http://www.topografix.com/GPX/1/1">
+  <trk>
+    <trkseg>
+      <trkpt lat="123.456" lon="-122.326897">
+        <time>2009-10-17T18:37:26Z</time>
+      </trkpt>
+      <trkpt lat="47.644548" lon="-122.326897">
+        <time>2009-10-17T18:37:31Z</time>
+      </trkpt>
+    </trkseg>
+  </trk>
+</gpx>`
+
+	gdfp := NewGpxDataFileProcessor()
+
+	skipped := make([]string, 0)
+	gdfp.SetSkippedRecordCallback(func(filepath string, reason string) (err error) {
+		skipped = append(skipped, reason)
+		return nil
+	})
+
+	err := gdfp.ProcessReader(index, nil, "data.gpx", strings.NewReader(data))
+	log.PanicIf(err)
+
+	records := index.records()
+	if len(records) != 1 {
+		t.Fatalf("Expected the invalid record to be skipped: %v", records)
+	} else if records[0].Latitude != 47.644548 || records[0].Position != 1 {
+		t.Fatalf("Record not correct: %s", records[0])
+	}
+
+	if reflect.DeepEqual(skipped, []string{SkipReasonInvalidCoordinates}) != true {
+		t.Fatalf("Skipped records not reported: %v", skipped)
+	}
 }
@@ -0,0 +1,138 @@
+package geoindex
+
+import (
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"compress/bzip2"
+	"compress/gzip"
+	"io/ioutil"
+
+	"github.com/dsoprea/go-logging"
+	"github.com/klauspost/compress/zstd"
+)
+
+type decompressorFunc func(r io.Reader) (rc io.ReadCloser, err error)
+
+var (
+	// decompressors maps the (lowercase) extensions of the compression
+	// formats that we can transparently read through to a function that will
+	// wrap the compressed stream.
+	decompressors = map[string]decompressorFunc{
+		".gz":   newGzipDecompressor,
+		".bz2":  newBzip2Decompressor,
+		".zst":  newZstdDecompressor,
+		".zstd": newZstdDecompressor,
+	}
+)
+
+func newGzipDecompressor(r io.Reader) (rc io.ReadCloser, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	gr, err := gzip.NewReader(r)
+	log.PanicIf(err)
+
+	return gr, nil
+}
+
+func newBzip2Decompressor(r io.Reader) (rc io.ReadCloser, err error) {
+	return ioutil.NopCloser(bzip2.NewReader(r)), nil
+}
+
+func newZstdDecompressor(r io.Reader) (rc io.ReadCloser, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	d, err := zstd.NewReader(r)
+	log.PanicIf(err)
+
+	return d.IOReadCloser(), nil
+}
+
+// splitCompressedExtension returns the lowercase extension of the given file
+// and, if the file has a compression extension that we recognize, that
+// compression extension. In the latter case, the returned extension will be
+// the one immediately preceding the compression extension (e.g. ".gpx" for
+// "track.gpx.gz").
+func splitCompressedExtension(filepath string) (extension string, compressionExtension string) {
+	extension = strings.ToLower(path.Ext(filepath))
+
+	if _, found := decompressors[extension]; found == false {
+		return extension, ""
+	}
+
+	compressionExtension = extension
+
+	uncompressedFilepath := filepath[:len(filepath)-len(compressionExtension)]
+	extension = strings.ToLower(path.Ext(uncompressedFilepath))
+
+	return extension, compressionExtension
+}
+
+// decompressedFile is a stream of decompressed data that also closes the
+// underlying file when closed.
+type decompressedFile struct {
+	io.ReadCloser
+
+	f *os.File
+}
+
+func (df *decompressedFile) Close() (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	err = df.ReadCloser.Close()
+	if err != nil {
+		df.f.Close()
+		log.Panic(err)
+	}
+
+	err = df.f.Close()
+	log.PanicIf(err)
+
+	return nil
+}
+
+// openDecompressed opens the given file and returns a stream of its
+// decompressed data. `compressionExtension` must be one of the extensions
+// returned by `splitCompressedExtension`.
+func openDecompressed(filepath string, compressionExtension string) (rc io.ReadCloser, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	df, found := decompressors[compressionExtension]
+	if found == false {
+		log.Panicf("compression extension [%s] not supported", compressionExtension)
+	}
+
+	f, err := os.Open(filepath)
+	log.PanicIf(err)
+
+	dc, err := df(f)
+	if err != nil {
+		f.Close()
+		log.Panic(err)
+	}
+
+	rc = &decompressedFile{
+		ReadCloser: dc,
+		f:          f,
+	}
+
+	return rc, nil
+}
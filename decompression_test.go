@@ -0,0 +1,31 @@
+package geoindex
+
+import (
+	"testing"
+)
+
+func TestSplitCompressedExtension(t *testing.T) {
+	cases := []struct {
+		filepath             string
+		extension            string
+		compressionExtension string
+	}{
+		{"track.gpx", ".gpx", ""},
+		{"track.GPX.GZ", ".gpx", ".gz"},
+		{"/some/path/track.gpx.bz2", ".gpx", ".bz2"},
+		{"track.gpx.zst", ".gpx", ".zst"},
+		{"track.gpx.zstd", ".gpx", ".zstd"},
+		{"archive.gz", "", ".gz"},
+		{"notes.txt", ".txt", ""},
+	}
+
+	for _, c := range cases {
+		extension, compressionExtension := splitCompressedExtension(c.filepath)
+
+		if extension != c.extension {
+			t.Fatalf("Extension for [%s] not correct: [%s] != [%s]", c.filepath, extension, c.extension)
+		} else if compressionExtension != c.compressionExtension {
+			t.Fatalf("Compression extension for [%s] not correct: [%s] != [%s]", c.filepath, compressionExtension, c.compressionExtension)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package geoindex
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/geo/s2"
+)
+
+const (
+	// exactDistanceTolerance is the furthest apart (in meters) that two
+	// coordinates can be and still be the same to six decimal places.
+	exactDistanceTolerance = 0.2
+)
+
+// DuplicateAction determines what happens when a record being added is a
+// duplicate of one that is already indexed.
+type DuplicateAction int
+
+const (
+	// DuplicateKeepAll indexes every record. This is the default.
+	DuplicateKeepAll DuplicateAction = iota
+
+	// DuplicateKeepFirst ignores the record being added.
+	DuplicateKeepFirst
+
+	// DuplicateKeepMostPrecise keeps whichever record has the more precise
+	// coordinates (see `coordinatePrecision`). The indexed record wins ties.
+	DuplicateKeepMostPrecise
+
+	// DuplicateMerge ignores the record being added but first copies its
+	// comments and relationships to the indexed record and, if the indexed
+	// record has no metadata, its metadata.
+	DuplicateMerge
+)
+
+func (da DuplicateAction) String() string {
+	switch da {
+	case DuplicateKeepAll:
+		return "keep-all"
+	case DuplicateKeepFirst:
+		return "keep-first"
+	case DuplicateKeepMostPrecise:
+		return "keep-most-precise"
+	case DuplicateMerge:
+		return "merge"
+	}
+
+	return fmt.Sprintf("unknown<%d>", int(da))
+}
+
+// DeduplicationPolicy determines which records are duplicates and what to do
+// with them when they are added to an index.
+//
+// Two records are duplicates if their timestamps are within `TimeTolerance`
+// and their coordinates are within `DistanceTolerance` meters (or are the same
+// to six decimal places if it is zero). Records without geographic data are
+// only duplicates of other records without geographic data for the same file.
+// Image records are only ever duplicates of records for the same file since
+// separate pictures commonly share a time and place.
+type DeduplicationPolicy struct {
+	Action            DuplicateAction
+	TimeTolerance     time.Duration
+	DistanceTolerance float64
+
+	// RequireSameSource requires duplicates to have the same source (e.g.
+	// `SourceGeographicGpx`).
+	RequireSameSource bool
+}
+
+// IsActive returns true if the policy will ever drop or replace records.
+func (dp DeduplicationPolicy) IsActive() bool {
+	return dp.Action != DuplicateKeepAll
+}
+
+// IsDuplicate returns true if the two records are duplicates under this
+// policy.
+func (dp DeduplicationPolicy) IsDuplicate(gr1, gr2 *GeographicRecord) bool {
+	if dp.RequireSameSource == true && gr1.SourceName != gr2.SourceName {
+		return false
+	}
+
+	delta := gr1.Timestamp.Sub(gr2.Timestamp)
+	if delta < 0 {
+		delta = -delta
+	}
+
+	if delta > dp.TimeTolerance {
+		return false
+	}
+
+	if gr1.SourceName == SourceImageJpeg || gr2.SourceName == SourceImageJpeg {
+		if gr1.Filepath != gr2.Filepath {
+			return false
+		}
+	}
+
+	if gr1.HasGeographic != gr2.HasGeographic {
+		return false
+	} else if gr1.HasGeographic == false {
+		return gr1.Filepath == gr2.Filepath
+	}
+
+	if dp.DistanceTolerance == 0 {
+		return fmt.Sprintf("%.6f,%.6f", gr1.Latitude, gr1.Longitude) == fmt.Sprintf("%.6f,%.6f", gr2.Latitude, gr2.Longitude)
+	}
+
+	return recordDistanceMeters(gr1, gr2) <= dp.DistanceTolerance
+}
+
+// searchLevel returns the S2 level whose cells, together with their
+// neighbors, are guaranteed to contain every coordinate within the distance
+// tolerance of a coordinate in the middle cell.
+func (dp DeduplicationPolicy) searchLevel() int {
+	distanceTolerance := dp.DistanceTolerance
+	if distanceTolerance < exactDistanceTolerance {
+		distanceTolerance = exactDistanceTolerance
+	}
+
+	level := s2.MinWidthMetric.MaxLevel(distanceTolerance / earthRadiusMeters)
+	if level < MinimumS2LevelForIndexing {
+		level = MinimumS2LevelForIndexing
+	}
+
+	return level
+}
+
+// resolve decides what to do with an incoming record that duplicates an
+// indexed one.
+func (dp DeduplicationPolicy) resolve(existing, incoming *GeographicRecord) (addIncoming bool, removeExisting bool) {
+	switch dp.Action {
+	case DuplicateKeepAll:
+		return true, false
+
+	case DuplicateKeepMostPrecise:
+		if coordinatePrecision(incoming) > coordinatePrecision(existing) {
+			return true, true
+		}
+
+	case DuplicateMerge:
+		mergeRecordInto(existing, incoming)
+	}
+
+	return false, false
+}
+
+// decimalPlaces returns the number of decimal places in the shortest
+// representation of the value.
+func decimalPlaces(value float64) int {
+	phrase := strconv.FormatFloat(value, 'f', -1, 64)
+
+	i := strings.IndexByte(phrase, '.')
+	if i == -1 {
+		return 0
+	}
+
+	return len(phrase) - i - 1
+}
+
+// coordinatePrecision returns the number of decimal places of the least
+// precise of the two coordinates, or -1 if there is no geographic data.
+func coordinatePrecision(gr *GeographicRecord) int {
+	if gr.HasGeographic == false {
+		return -1
+	}
+
+	latitudePlaces := decimalPlaces(gr.Latitude)
+	longitudePlaces := decimalPlaces(gr.Longitude)
+
+	if latitudePlaces < longitudePlaces {
+		return latitudePlaces
+	}
+
+	return longitudePlaces
+}
+
+// mergeRecordInto copies the comments and relationships from `from` to `to`,
+// as well as the metadata if `to` doesn't have any. This is idempotent since
+// the same record is usually added to both indices.
+func mergeRecordInto(to, from *GeographicRecord) {
+	if to == from {
+		return
+	}
+
+	for _, comment := range from.comments {
+		found := false
+		for _, existing := range to.comments {
+			if existing == comment {
+				found = true
+				break
+			}
+		}
+
+		if found == false {
+			to.AddComment(comment)
+		}
+	}
+
+	for _, grrt := range from.relatedTo {
+		found := false
+		for _, existing := range to.relatedTo {
+			if existing.GeographicRecord == grrt.GeographicRecord && existing.Relationship == grrt.Relationship {
+				found = true
+				break
+			}
+		}
+
+		if found == false {
+			to.AddRelated(grrt.GeographicRecord, grrt.Relationship)
+		}
+	}
+
+	if metadata, ok := to.Metadata.(map[string]interface{}); ok == true && len(metadata) == 0 {
+		to.Metadata = from.Metadata
+	}
+}
@@ -0,0 +1,240 @@
+package geoindex
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dsoprea/go-logging"
+)
+
+func TestDeduplicationPolicy_IsDuplicate(t *testing.T) {
+	now := time.Now()
+
+	dp := DeduplicationPolicy{
+		Action:            DuplicateKeepFirst,
+		TimeTolerance:     time.Second * 5,
+		DistanceTolerance: 10,
+	}
+
+	gr := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.34, 34.56, nil)
+
+	// About three meters north and three seconds later.
+	near := NewGeographicRecord(SourceGeographicGpx, "other.gpx", now.Add(time.Second*3), true, 12.34003, 34.56, nil)
+	if dp.IsDuplicate(gr, near) != true {
+		t.Fatalf("Expected near record to be a duplicate.")
+	}
+
+	later := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now.Add(time.Second*6), true, 12.34, 34.56, nil)
+	if dp.IsDuplicate(gr, later) != false {
+		t.Fatalf("Expected later record to not be a duplicate.")
+	}
+
+	// About 110 meters north.
+	far := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.341, 34.56, nil)
+	if dp.IsDuplicate(gr, far) != false {
+		t.Fatalf("Expected far record to not be a duplicate.")
+	}
+
+	image := NewGeographicRecord(SourceImageJpeg, "image.jpg", now, true, 12.34, 34.56, nil)
+	if dp.IsDuplicate(gr, image) != false {
+		t.Fatalf("Expected image record to not be a duplicate of a data record.")
+	}
+
+	otherSource := NewGeographicRecord("other", "data.gpx", now, true, 12.34, 34.56, nil)
+	if dp.IsDuplicate(gr, otherSource) != true {
+		t.Fatalf("Expected record from other source to be a duplicate.")
+	}
+
+	dp.RequireSameSource = true
+	if dp.IsDuplicate(gr, otherSource) != false {
+		t.Fatalf("Expected record from other source to not be a duplicate.")
+	}
+}
+
+func TestDeduplicationPolicy_IsDuplicate_ExactCoordinates(t *testing.T) {
+	now := time.Now()
+
+	dp := DeduplicationPolicy{
+		Action: DuplicateKeepFirst,
+	}
+
+	gr := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.34, 34.56, nil)
+
+	same := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.3400001, 34.56, nil)
+	if dp.IsDuplicate(gr, same) != true {
+		t.Fatalf("Expected same coordinates to be a duplicate.")
+	}
+
+	different := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.34001, 34.56, nil)
+	if dp.IsDuplicate(gr, different) != false {
+		t.Fatalf("Expected different coordinates to not be a duplicate.")
+	}
+}
+
+func TestCoordinatePrecision(t *testing.T) {
+	gr := NewGeographicRecord(SourceGeographicGpx, "data.gpx", time.Now(), true, 12.34567, 34.5, nil)
+	if precision := coordinatePrecision(gr); precision != 1 {
+		t.Fatalf("Precision not correct: (%d)", precision)
+	}
+
+	gr = NewGeographicRecord(SourceImageJpeg, "image.jpg", time.Now(), false, 0, 0, nil)
+	if precision := coordinatePrecision(gr); precision != -1 {
+		t.Fatalf("Precision not correct for record without geographic data: (%d)", precision)
+	}
+}
+
+func TestTimeIndex_SetDeduplicationPolicy_KeepFirst(t *testing.T) {
+	now := time.Now()
+
+	ti := NewTimeIndex()
+
+	ti.SetDeduplicationPolicy(DeduplicationPolicy{
+		Action:        DuplicateKeepFirst,
+		TimeTolerance: time.Second,
+	})
+
+	first := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.34, 34.56, nil)
+	duplicate := NewGeographicRecord(SourceGeographicGpx, "other.gpx", now.Add(time.Millisecond*500), true, 12.34, 34.56, nil)
+	distinct := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now.Add(time.Second*2), true, 12.34, 34.56, nil)
+
+	err := ti.AddRecords([]*GeographicRecord{first, duplicate, distinct})
+	log.PanicIf(err)
+
+	records := ti.records()
+	if len(records) != 2 || records[0] != first || records[1] != distinct {
+		t.Fatalf("Records not correct: %v", records)
+	} else if ti.RecordCount() != 2 {
+		t.Fatalf("Record count not correct: (%d)", ti.RecordCount())
+	}
+}
+
+func TestTimeIndex_SetDeduplicationPolicy_KeepMostPrecise(t *testing.T) {
+	now := time.Now()
+
+	ti := NewTimeIndex()
+
+	ti.SetDeduplicationPolicy(DeduplicationPolicy{
+		Action:            DuplicateKeepMostPrecise,
+		DistanceTolerance: 100,
+	})
+
+	imprecise := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.34, 34.56, nil)
+	precise := NewGeographicRecord(SourceGeographicGpx, "other.gpx", now, true, 12.34012, 34.56034, nil)
+
+	err := ti.AddWithRecord(imprecise)
+	log.PanicIf(err)
+
+	err = ti.AddWithRecord(precise)
+	log.PanicIf(err)
+
+	records := ti.records()
+	if len(records) != 1 || records[0] != precise {
+		t.Fatalf("Records not correct: %v", records)
+	}
+
+	// The indexed record wins ties.
+
+	err = ti.AddWithRecord(NewGeographicRecord(SourceGeographicGpx, "third.gpx", now, true, 12.34021, 34.56043, nil))
+	log.PanicIf(err)
+
+	records = ti.records()
+	if len(records) != 1 || records[0] != precise {
+		t.Fatalf("Records not correct after tie: %v", records)
+	}
+}
+
+func TestGeographicIndex_SetDeduplicationPolicy_Merge(t *testing.T) {
+	now := time.Now()
+
+	gi := NewGeographicIndex()
+
+	gi.SetDeduplicationPolicy(DeduplicationPolicy{
+		Action:            DuplicateMerge,
+		TimeTolerance:     time.Second * 10,
+		DistanceTolerance: 25,
+	})
+
+	first := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.34, 34.56, nil)
+
+	// About 15 meters east and five seconds later.
+	duplicate := NewGeographicRecord(SourceGeographicGpx, "other.gpx", now.Add(time.Second*5), true, 12.34, 34.56014, map[string]interface{}{"key": "value"})
+	duplicate.AddComment("some comment")
+
+	err := gi.AddWithRecord(first)
+	log.PanicIf(err)
+
+	err = gi.AddWithRecord(duplicate)
+	log.PanicIf(err)
+
+	// Merging again doesn't copy anything twice.
+	err = gi.AddWithRecord(duplicate)
+	log.PanicIf(err)
+
+	if gi.RecordCount() != 1 {
+		t.Fatalf("Record count not correct: (%d)", gi.RecordCount())
+	}
+
+	records := gi.records()
+	if len(records) != 1 || records[0] != first {
+		t.Fatalf("Records not correct: %v", records)
+	}
+
+	comments := first.Comments()
+	if len(comments) != 1 || comments[0] != "some comment" {
+		t.Fatalf("Comments not merged: %v", comments)
+	}
+
+	metadata := first.Metadata.(map[string]interface{})
+	if metadata["key"] != "value" {
+		t.Fatalf("Metadata not merged: %v", metadata)
+	}
+
+	// About 110 meters north.
+	distinct := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.341, 34.56, nil)
+
+	err = gi.AddWithRecord(distinct)
+	log.PanicIf(err)
+
+	if gi.RecordCount() != 2 {
+		t.Fatalf("Record count not correct after distinct record: (%d)", gi.RecordCount())
+	}
+}
+
+func TestIndex_SetDeduplicationPolicy_KeepMostPrecise(t *testing.T) {
+	now := time.Now()
+
+	index := NewIndex()
+
+	index.SetDeduplicationPolicy(DeduplicationPolicy{
+		Action:        DuplicateKeepMostPrecise,
+		TimeTolerance: time.Second,
+	})
+
+	// These are too far apart in time to be duplicates of each other, but the
+	// incoming record is a duplicate of both. The time-index would find the
+	// earlier one first and the geographic-index the one it indexed first.
+	earlier := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.34, 34.56, nil)
+	later := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now.Add(time.Second*2), true, 12.34, 34.56, nil)
+	precise := NewGeographicRecord(SourceGeographicGpx, "other.gpx", now.Add(time.Second), true, 12.3400001, 34.5600001, nil)
+
+	for _, gr := range []*GeographicRecord{later, earlier, precise} {
+		err := index.AddWithRecord(gr)
+		log.PanicIf(err)
+	}
+
+	records := index.TimeIndex().records()
+	if len(records) != 2 || records[0] != precise || records[1] != later {
+		t.Fatalf("Time-index records not correct: %v", records)
+	}
+
+	geographicRecords := index.GeographicIndex().records()
+	if len(geographicRecords) != 2 {
+		t.Fatalf("Geographic-index records not correct: %v", geographicRecords)
+	}
+
+	for _, gr := range geographicRecords {
+		if gr != precise && gr != later {
+			t.Fatalf("Geographic-index has a record that the time-index doesn't: %v", gr)
+		}
+	}
+}
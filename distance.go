@@ -0,0 +1,55 @@
+package geoindex
+
+import (
+	"strings"
+
+	"github.com/golang/geo/s2"
+)
+
+const (
+	// earthRadiusMeters is the mean radius of the Earth.
+	earthRadiusMeters = 6371008.8
+)
+
+// distanceMeters returns the great-circle distance between two coordinates.
+func distanceMeters(latitude1, longitude1, latitude2, longitude2 float64) float64 {
+	ll1 := s2.LatLngFromDegrees(latitude1, longitude1)
+	ll2 := s2.LatLngFromDegrees(latitude2, longitude2)
+
+	return float64(ll1.Distance(ll2)) * earthRadiusMeters
+}
+
+// recordDistanceMeters returns the great-circle distance between two records.
+// Both must have geographic data.
+func recordDistanceMeters(gr1, gr2 *GeographicRecord) float64 {
+	return distanceMeters(gr1.Latitude, gr1.Longitude, gr2.Latitude, gr2.Longitude)
+}
+
+// isTrackRecord returns true if the record is a point from a data-log, which
+// together with the others describes where we went. Images may have
+// coordinates but are taken at arbitrary points.
+func isTrackRecord(gr *GeographicRecord) bool {
+	return gr.HasGeographic == true && strings.HasPrefix(gr.SourceName, sourceImagePrefix) == false
+}
+
+// trackDistanceMeters returns the total distance between consecutive track
+// records (see `isTrackRecord`) from the same file. Files that overlap in
+// time are separate tracks. The records must be in time order.
+func trackDistanceMeters(records []*GeographicRecord) float64 {
+	distance := float64(0)
+
+	previousByFilepath := make(map[string]*GeographicRecord)
+	for _, gr := range records {
+		if isTrackRecord(gr) == false {
+			continue
+		}
+
+		if previous := previousByFilepath[gr.Filepath]; previous != nil {
+			distance += recordDistanceMeters(previous, gr)
+		}
+
+		previousByFilepath[gr.Filepath] = gr
+	}
+
+	return distance
+}
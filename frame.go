@@ -0,0 +1,114 @@
+package geoindex
+
+import (
+	"io"
+	"os"
+
+	"encoding/binary"
+	"hash/crc32"
+
+	"github.com/dsoprea/go-logging"
+)
+
+const (
+	// frameHeaderSize is the size of the length and checksum that precede
+	// every frame.
+	frameHeaderSize = 8
+)
+
+// encodeFrame returns the payload preceded by its length and checksum.
+func encodeFrame(payload []byte) []byte {
+	frame := make([]byte, frameHeaderSize, frameHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:], crc32.ChecksumIEEE(payload))
+
+	return append(frame, payload...)
+}
+
+// readFrame reads the next frame. `ok` will be false if there are no more
+// complete and valid frames. `remaining` is the number of bytes left in the
+// stream and protects us from a torn length.
+func readFrame(r io.Reader, remaining int64) (payload []byte, ok bool, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	header := make([]byte, frameHeaderSize)
+
+	_, err = io.ReadFull(r, header)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, false, nil
+	}
+
+	log.PanicIf(err)
+
+	length := binary.BigEndian.Uint32(header[:4])
+	checksum := binary.BigEndian.Uint32(header[4:])
+
+	if int64(length) > remaining-frameHeaderSize {
+		return nil, false, nil
+	}
+
+	payload = make([]byte, length)
+
+	_, err = io.ReadFull(r, payload)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, false, nil
+	}
+
+	log.PanicIf(err)
+
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return nil, false, nil
+	}
+
+	return payload, true, nil
+}
+
+// appendFrame writes a frame at `offset`, which must be the end of the file,
+// and returns the new end of the file. If the write or the sync fails, the
+// file is truncated back to `offset` so that a torn frame is never followed
+// by frames that were written successfully. `rolledBack` is false if that
+// truncation failed too, in which case the file should no longer be appended
+// to.
+func appendFrame(f *os.File, offset int64, payload []byte, sync bool) (newOffset int64, rolledBack bool, err error) {
+	frame := encodeFrame(payload)
+
+	_, err = f.WriteAt(frame, offset)
+	if err == nil && sync == true {
+		err = f.Sync()
+	}
+
+	if err != nil {
+		truncateErr := f.Truncate(offset)
+		if truncateErr == nil {
+			truncateErr = f.Sync()
+		}
+
+		return offset, truncateErr == nil, log.Wrap(err)
+	}
+
+	return offset + int64(len(frame)), true, nil
+}
+
+// syncDirectory flushes the directory to disk so that a rename within it is
+// durable.
+func syncDirectory(dirPath string) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	d, err := os.Open(dirPath)
+	log.PanicIf(err)
+
+	defer d.Close()
+
+	err = d.Sync()
+	log.PanicIf(err)
+
+	return nil
+}
@@ -1,15 +1,17 @@
 package geoindex
 
 import (
-	"path"
+	"io"
 	"strings"
+	"time"
 
 	"github.com/dsoprea/go-logging"
 	"github.com/randomingenuity/go-utility/filesystem"
 )
 
 var (
-	imagesLogger = log.NewLogger("geoindex.images")
+	imagesLogger    = log.NewLogger("geoindex.images")
+	collectorLogger = log.NewLogger("geoindex.collector")
 )
 
 // TODO(dustin): !! Rename this file and tests to "collector.go". There's nothing geographic-specific about this file.
@@ -20,10 +22,33 @@ type GeographicCollector struct {
 	processors        map[string]FileProcessor
 	ti                *TimeIndex
 	gi                *GeographicIndex
+	index             *Index
 	filepathCollector []string
 	visitedCount      int
 
 	fileProcessedCb FileProcessedFunc
+
+	// skippedRecords counts the records skipped from the file being processed
+	// by reason.
+	skippedRecords map[string]int
+
+	eventCb        CollectorEventFunc
+	totals         CollectorTotals
+	totalsInterval time.Duration
+	startedAt      time.Time
+	lastTotalsAt   time.Time
+
+	processed          map[string]struct{}
+	checkpointFilepath string
+	checkpointInterval time.Duration
+	lastCheckpointAt   time.Time
+
+	// These describe what has already been written to the checkpoint file.
+	// `checkpointPositions` is `nil` until the file has been started.
+	checkpointPositions map[*GeographicRecord]int
+	checkpointIndexed   map[*GeographicRecord]struct{}
+	checkpointFilepaths map[string]struct{}
+	checkpointSize      int64
 }
 
 type FileProcessor interface {
@@ -31,9 +56,37 @@ type FileProcessor interface {
 	Process(ti *TimeIndex, gi *GeographicIndex, filepath string) (err error)
 }
 
+// SkippedRecordFunc is called by a processor for every record that it skips.
+type SkippedRecordFunc func(filepath string, reason string) (err error)
+
+// SkippingFileProcessor is a `FileProcessor` that reports the records that it
+// skips, such as those with invalid coordinates, rather than only logging
+// them. The collector reports them with `CollectorEventSkipped` events.
+type SkippingFileProcessor interface {
+	FileProcessor
+
+	// SetSkippedRecordCallback sets the callback to be called for every
+	// record that is skipped.
+	SetSkippedRecordCallback(cb SkippedRecordFunc)
+}
+
+// StreamFileProcessor is a `FileProcessor` that can also read its data from an
+// arbitrary stream rather than only directly from the file. Only processors
+// that implement this can be used to process compressed files.
+type StreamFileProcessor interface {
+	FileProcessor
+
+	// ProcessReader processes the data from `r`. `filepath` is the path of
+	// the file that the data originally came from and is only used to
+	// populate the records.
+	ProcessReader(ti *TimeIndex, gi *GeographicIndex, filepath string, r io.Reader) (err error)
+}
+
 // NewGeographicCollector takes both indices and populates them as files are
 // processed. Either of them can be `nil` and, if that is the case, that index
-// will not be utilized.
+// will not be utilized. If both are given, neither may have a deduplication
+// policy since each would resolve duplicates differently. Use an `Index` and
+// `NewGeographicCollectorWithIndex` instead.
 func NewGeographicCollector(ti *TimeIndex, gi *GeographicIndex) (gc *GeographicCollector) {
 	filepathCollector := make([]string, 0)
 
@@ -42,9 +95,23 @@ func NewGeographicCollector(ti *TimeIndex, gi *GeographicIndex) (gc *GeographicC
 		ti:                ti,
 		gi:                gi,
 		filepathCollector: filepathCollector,
+		totalsInterval:    DefaultTotalsInterval,
+		processed:         make(map[string]struct{}),
+		skippedRecords:    make(map[string]int),
 	}
 }
 
+// NewGeographicCollectorWithIndex returns a collector that populates both
+// indices of the given `Index`. If the index has a deduplication policy, the
+// records are added through the index so that duplicates are resolved once
+// for both indices.
+func NewGeographicCollectorWithIndex(index *Index) (gc *GeographicCollector) {
+	gc = NewGeographicCollector(index.ti, index.gi)
+	gc.index = index
+
+	return gc
+}
+
 // VisitedCount returns the number of files that we've processed. This is
 // incremented before the file is processed.
 func (gc *GeographicCollector) VisitedCount() int {
@@ -52,7 +119,7 @@ func (gc *GeographicCollector) VisitedCount() int {
 }
 
 // SetFileProcessedCallback sets a callback to be called for every file we
-// process.
+// process. See `SetEventCallback` for more detailed progress.
 func (gc *GeographicCollector) SetFileProcessedCallback(cb FileProcessedFunc) {
 	gc.fileProcessedCb = cb
 }
@@ -65,7 +132,11 @@ func (gc *GeographicCollector) VisitedFilepaths() []string {
 // AddFileProcessor registers a given processor for a given extension. The
 // extension is case-insensitive and must include the initial period. This can
 // be called more than once with one processor but not more than once for an
-// extension.
+// extension. If the processor is a `StreamFileProcessor`, files having this
+// extension followed by a compression extension (".gz", ".bz2", ".zst", or
+// ".zstd") will also be decompressed and passed to it. If the processor is a
+// `SkippingFileProcessor`, it will report its skipped records to this
+// collector.
 func (gc *GeographicCollector) AddFileProcessor(extension string, processor FileProcessor) (err error) {
 	defer func() {
 		if state := recover(); state != nil {
@@ -82,6 +153,106 @@ func (gc *GeographicCollector) AddFileProcessor(extension string, processor File
 
 	gc.processors[extension] = processor
 
+	if sfp, ok := processor.(SkippingFileProcessor); ok == true {
+		sfp.SetSkippedRecordCallback(gc.skipRecord)
+	}
+
+	return nil
+}
+
+// findProcessor returns the processor for the given file and, if the file is
+// compressed, its compression extension. If there is no processor that can
+// handle the file, `fp` will be `nil` and `skipReason` will describe why.
+func (gc *GeographicCollector) findProcessor(filepath string) (fp FileProcessor, compressionExtension string, skipReason string) {
+	extension, compressionExtension := splitCompressedExtension(filepath)
+
+	fp, found := gc.processors[extension]
+
+	// We don't have a processor for this type of file.
+	if found == false {
+		return nil, "", SkipReasonNoProcessor
+	}
+
+	if compressionExtension != "" {
+		if _, ok := fp.(StreamFileProcessor); ok == false {
+			collectorLogger.Warningf(nil, "Processor [%s] can not read compressed files. Skipping: [%s]", fp.Name(), filepath)
+			return nil, "", SkipReasonCompressionNotSupported
+		}
+	}
+
+	return fp, compressionExtension, ""
+}
+
+// recordCount returns the number of records currently in whichever index we
+// are populating.
+func (gc *GeographicCollector) recordCount() int {
+	if gc.ti != nil {
+		return gc.ti.RecordCount()
+	} else if gc.gi != nil {
+		return gc.gi.RecordCount()
+	}
+
+	return 0
+}
+
+// process passes the file to the processor, decompressing it first if
+// necessary. If we populate an `Index` that deduplicates, the processor
+// populates separate indices and we then add its records through the `Index`
+// so that both of its indices make the same decisions about duplicates.
+func (gc *GeographicCollector) process(fp FileProcessor, compressionExtension string, filepath string) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	if gc.index == nil || gc.index.dedup.IsActive() == false {
+		err := gc.processInto(gc.ti, gc.gi, fp, compressionExtension, filepath)
+		log.PanicIf(err)
+
+		return nil
+	}
+
+	// The geographic-index is only populated so that the processor validates
+	// the records the same way that it otherwise would.
+	stagingTi := NewTimeIndex()
+	stagingGi := NewGeographicIndex()
+
+	err = gc.processInto(stagingTi, stagingGi, fp, compressionExtension, filepath)
+	log.PanicIf(err)
+
+	err = gc.index.AddRecords(stagingTi.records())
+	log.PanicIf(err)
+
+	return nil
+}
+
+// processInto passes the file to the processor to populate the given indices,
+// decompressing it first if necessary.
+func (gc *GeographicCollector) processInto(ti *TimeIndex, gi *GeographicIndex, fp FileProcessor, compressionExtension string, filepath string) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	if compressionExtension == "" {
+		err = fp.Process(ti, gi, filepath)
+		log.PanicIf(err)
+
+		return nil
+	}
+
+	rc, err := openDecompressed(filepath, compressionExtension)
+	log.PanicIf(err)
+
+	defer rc.Close()
+
+	sfp := fp.(StreamFileProcessor)
+
+	err = sfp.ProcessReader(ti, gi, filepath, rc)
+	log.PanicIf(err)
+
 	return nil
 }
 
@@ -93,13 +264,24 @@ func (gc *GeographicCollector) ReadFromFilepath(filepath string) (err error) {
 		}
 	}()
 
-	extension := path.Ext(filepath)
-	extension = strings.ToLower(extension)
+	if _, found := gc.processed[filepath]; found == true {
+		err := gc.skip(filepath, SkipReasonAlreadyProcessed)
+		log.PanicIf(err)
 
-	fp, found := gc.processors[extension]
+		return nil
+	}
+
+	// Processors add to the two indices separately, so they would keep
+	// different records if they deduplicated separately.
+	if gc.index == nil && gc.ti != nil && gc.gi != nil && (gc.ti.dedup.IsActive() == true || gc.gi.dedup.IsActive() == true) {
+		log.Panicf("deduplication policies must be set on an Index rather than on its indices")
+	}
+
+	fp, compressionExtension, skipReason := gc.findProcessor(filepath)
+	if fp == nil {
+		err := gc.skip(filepath, skipReason)
+		log.PanicIf(err)
 
-	// We don't have a processor for this type of file.
-	if found == false {
 		return nil
 	}
 
@@ -107,7 +289,56 @@ func (gc *GeographicCollector) ReadFromFilepath(filepath string) (err error) {
 
 	gc.visitedCount++
 
-	err = fp.Process(gc.ti, gc.gi, filepath)
+	startedEvent := CollectorEvent{
+		Type:     CollectorEventStarted,
+		Filepath: filepath,
+	}
+
+	err = gc.emit(startedEvent)
+	log.PanicIf(err)
+
+	startedAt := time.Now()
+	initialRecordCount := gc.recordCount()
+
+	gc.skippedRecords = make(map[string]int)
+
+	processErr := gc.process(fp, compressionExtension, filepath)
+	duration := time.Since(startedAt)
+
+	if processErr != nil {
+		gc.totals.Failed++
+
+		failedEvent := CollectorEvent{
+			Type:     CollectorEventFailed,
+			Filepath: filepath,
+			Duration: duration,
+			Error:    processErr,
+		}
+
+		err := gc.emit(failedEvent)
+		log.PanicIf(err)
+
+		log.Panic(processErr)
+	}
+
+	recordCount := gc.recordCount() - initialRecordCount
+
+	gc.processed[filepath] = struct{}{}
+
+	err = gc.emitSkippedRecords(filepath)
+	log.PanicIf(err)
+
+	gc.totals.Finished++
+	gc.totals.Records += recordCount
+
+	finishedEvent := CollectorEvent{
+		Type:        CollectorEventFinished,
+		Filepath:    filepath,
+		RecordCount: recordCount,
+		Duration:    duration,
+	}
+
+	err = gc.emit(finishedEvent)
 	log.PanicIf(err)
 
 	if gc.fileProcessedCb != nil {
@@ -115,10 +346,18 @@ func (gc *GeographicCollector) ReadFromFilepath(filepath string) (err error) {
 		log.PanicIf(err)
 	}
 
+	err = gc.emitTotals(false)
+	log.PanicIf(err)
+
+	err = gc.checkpointIfDue()
+	log.PanicIf(err)
+
 	return nil
 }
 
-// ReadFromPath is called for each path we visit.
+// ReadFromPath is called for each path we visit. All files are discovered
+// before any are processed so that progress can be reported against a known
+// total.
 func (gc *GeographicCollector) ReadFromPath(rootPath string) (err error) {
 	defer func() {
 		if state := recover(); state != nil {
@@ -128,6 +367,8 @@ func (gc *GeographicCollector) ReadFromPath(rootPath string) (err error) {
 
 	filesC, _, errC := rifs.ListFiles(rootPath, nil)
 
+	discovered := make([]string, 0)
+
 FilesRead:
 
 	for {
@@ -148,12 +389,50 @@ FilesRead:
 				break FilesRead
 			}
 
-			if vf.Info.IsDir() == false {
-				err := gc.ReadFromFilepath(vf.Filepath)
+			if vf.Info.IsDir() == true {
+				continue
+			}
+
+			if _, found := gc.processed[vf.Filepath]; found == true {
+				err := gc.skip(vf.Filepath, SkipReasonAlreadyProcessed)
 				log.PanicIf(err)
+
+				continue
 			}
+
+			fp, _, skipReason := gc.findProcessor(vf.Filepath)
+			if fp == nil {
+				err := gc.skip(vf.Filepath, skipReason)
+				log.PanicIf(err)
+
+				continue
+			}
+
+			discovered = append(discovered, vf.Filepath)
+			gc.totals.Discovered++
+
+			discoveredEvent := CollectorEvent{
+				Type:     CollectorEventDiscovered,
+				Filepath: vf.Filepath,
+			}
+
+			err := gc.emit(discoveredEvent)
+			log.PanicIf(err)
 		}
 	}
 
+	for _, filepath := range discovered {
+		err := gc.ReadFromFilepath(filepath)
+		log.PanicIf(err)
+	}
+
+	if gc.checkpointFilepath != "" {
+		err := gc.Checkpoint()
+		log.PanicIf(err)
+	}
+
+	err = gc.emitTotals(true)
+	log.PanicIf(err)
+
 	return nil
 }
@@ -0,0 +1,511 @@
+package geoindex
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"encoding/gob"
+	"io/ioutil"
+
+	"github.com/dsoprea/go-logging"
+)
+
+const (
+	// DefaultCheckpointInterval is the default minimum amount of time between
+	// checkpoints.
+	DefaultCheckpointInterval = time.Minute
+
+	// checkpointVersion is the version of the checkpoint format. It is
+	// incremented whenever the format changes incompatibly.
+	checkpointVersion = 2
+)
+
+const (
+	persistedKindCollectorCheckpoint = "collector-checkpoint"
+)
+
+const (
+	// SkipReasonAlreadyProcessed indicates that the file was already
+	// processed before the checkpoint that we resumed from.
+	SkipReasonAlreadyProcessed = "already processed"
+)
+
+// collectorCheckpointHeader is the first frame of a checkpoint file.
+type collectorCheckpointHeader struct {
+	Version int
+}
+
+// collectorCheckpointDelta is every subsequent frame of a checkpoint file. It
+// only has what changed since the previous one. Records are referred to by
+// their position across all of the deltas in the file.
+type collectorCheckpointDelta struct {
+	ProcessedFilepaths []string
+
+	// Records are the records that have not been written before, including
+	// those that are only referred to by relationships.
+	Records []persistedRecord
+
+	// Indexed are the records that were added to the index and Unindexed are
+	// the records that were removed from it.
+	Indexed   []int
+	Unindexed []int
+}
+
+// SetCheckpoint configures the collector to periodically persist its indices
+// and the list of files that it has already processed to `filepath`. A
+// checkpoint is written at most once per `interval` (between files) and once
+// more when `ReadFromPath` completes. If `interval` is zero,
+// `DefaultCheckpointInterval` is used.
+func (gc *GeographicCollector) SetCheckpoint(filepath string, interval time.Duration) {
+	if interval == 0 {
+		interval = DefaultCheckpointInterval
+	}
+
+	gc.checkpointFilepath = filepath
+	gc.checkpointInterval = interval
+	gc.checkpointPositions = nil
+}
+
+// records returns every record that we have collected so far.
+func (gc *GeographicCollector) records() []*GeographicRecord {
+	if gc.ti != nil {
+		return gc.ti.records()
+	} else if gc.gi != nil {
+		return gc.gi.records()
+	}
+
+	return nil
+}
+
+// checkpointDelta returns what changed since the last checkpoint. `positions`
+// is updated with the positions of the new records. This compares every
+// collected record with the last checkpoint, since records can also have
+// been removed (e.g. as duplicates) since then.
+func (gc *GeographicCollector) checkpointDelta(positions map[*GeographicRecord]int) (ccd collectorCheckpointDelta, indexed map[*GeographicRecord]struct{}) {
+	ccd.ProcessedFilepaths = make([]string, 0)
+	for _, filepath := range gc.filepathCollector {
+		if _, found := gc.processed[filepath]; found == false {
+			continue
+		} else if _, found := gc.checkpointFilepaths[filepath]; found == true {
+			continue
+		}
+
+		ccd.ProcessedFilepaths = append(ccd.ProcessedFilepaths, filepath)
+	}
+
+	records := gc.records()
+
+	indexed = make(map[*GeographicRecord]struct{}, len(records))
+	newlyIndexed := make([]*GeographicRecord, 0)
+
+	for _, gr := range records {
+		indexed[gr] = struct{}{}
+
+		if _, found := gc.checkpointIndexed[gr]; found == false {
+			newlyIndexed = append(newlyIndexed, gr)
+		}
+	}
+
+	ccd.Records, ccd.Indexed = encodeNewRecords(newlyIndexed, positions)
+
+	ccd.Unindexed = make([]int, 0)
+	for gr := range gc.checkpointIndexed {
+		if _, found := indexed[gr]; found == false {
+			ccd.Unindexed = append(ccd.Unindexed, positions[gr])
+		}
+	}
+
+	sort.Ints(ccd.Unindexed)
+
+	return ccd, indexed
+}
+
+// encodeCheckpointFrame returns the gob-encoding of a header or a delta.
+func encodeCheckpointFrame(value interface{}, withPersistenceHeader bool) (payload []byte, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	b := new(bytes.Buffer)
+
+	e := gob.NewEncoder(b)
+
+	if withPersistenceHeader == true {
+		err := writePersistenceHeader(e, persistedKindCollectorCheckpoint)
+		log.PanicIf(err)
+	}
+
+	err = e.Encode(value)
+	log.PanicIf(err)
+
+	return b.Bytes(), nil
+}
+
+// startCheckpoint writes a new checkpoint file with everything that we have
+// collected so far. The file is replaced atomically so an interrupted write
+// will never corrupt an existing checkpoint.
+func (gc *GeographicCollector) startCheckpoint() (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	gc.checkpointIndexed = make(map[*GeographicRecord]struct{})
+	gc.checkpointFilepaths = make(map[string]struct{})
+
+	positions := make(map[*GeographicRecord]int)
+	ccd, indexed := gc.checkpointDelta(positions)
+
+	headerPayload, err := encodeCheckpointFrame(collectorCheckpointHeader{Version: checkpointVersion}, true)
+	log.PanicIf(err)
+
+	deltaPayload, err := encodeCheckpointFrame(ccd, false)
+	log.PanicIf(err)
+
+	f, err := ioutil.TempFile(path.Dir(gc.checkpointFilepath), path.Base(gc.checkpointFilepath)+".")
+	log.PanicIf(err)
+
+	tempFilepath := f.Name()
+
+	defer os.Remove(tempFilepath)
+
+	size, _, err := appendFrame(f, 0, headerPayload, false)
+	if err != nil {
+		f.Close()
+		log.Panic(err)
+	}
+
+	size, _, err = appendFrame(f, size, deltaPayload, true)
+	if err != nil {
+		f.Close()
+		log.Panic(err)
+	}
+
+	err = f.Close()
+	log.PanicIf(err)
+
+	err = os.Rename(tempFilepath, gc.checkpointFilepath)
+	log.PanicIf(err)
+
+	err = syncDirectory(path.Dir(gc.checkpointFilepath))
+	log.PanicIf(err)
+
+	gc.checkpointPositions = positions
+	gc.checkpointIndexed = indexed
+	gc.checkpointSize = size
+
+	for _, filepath := range ccd.ProcessedFilepaths {
+		gc.checkpointFilepaths[filepath] = struct{}{}
+	}
+
+	collectorLogger.Debugf(nil, "Started checkpoint with (%d) records and (%d) processed files: [%s]", len(ccd.Records), len(ccd.ProcessedFilepaths), gc.checkpointFilepath)
+
+	return nil
+}
+
+// Checkpoint immediately writes a checkpoint. Only what changed since the
+// previous checkpoint is appended to the file, so the amount written does not
+// grow with the number of records collected. Finding what changed is still a
+// pass over every collected record, though, so the interval should be much
+// longer than that takes for large collections. Relationships and
+// comments that are added to a record after it was first checkpointed are
+// not captured. An interrupted write is detected and discarded when the
+// checkpoint is loaded.
+func (gc *GeographicCollector) Checkpoint() (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	if gc.checkpointFilepath == "" {
+		log.Panicf("no checkpoint file-path configured")
+	}
+
+	if gc.checkpointPositions == nil {
+		err := gc.startCheckpoint()
+		log.PanicIf(err)
+
+		gc.lastCheckpointAt = time.Now()
+
+		return nil
+	}
+
+	// `positions` is updated as the delta is built. If anything fails from
+	// here, what we know about what was written is no longer reliable, so a
+	// new file will be started next time.
+	positions := gc.checkpointPositions
+	gc.checkpointPositions = nil
+
+	ccd, indexed := gc.checkpointDelta(positions)
+
+	if len(ccd.ProcessedFilepaths) == 0 && len(ccd.Records) == 0 && len(ccd.Indexed) == 0 && len(ccd.Unindexed) == 0 {
+		gc.checkpointPositions = positions
+		gc.lastCheckpointAt = time.Now()
+
+		return nil
+	}
+
+	payload, err := encodeCheckpointFrame(ccd, false)
+	log.PanicIf(err)
+
+	f, err := os.OpenFile(gc.checkpointFilepath, os.O_RDWR, 0)
+	log.PanicIf(err)
+
+	size, _, err := appendFrame(f, gc.checkpointSize, payload, true)
+	if err != nil {
+		f.Close()
+		log.Panic(err)
+	}
+
+	err = f.Close()
+	log.PanicIf(err)
+
+	gc.checkpointPositions = positions
+	gc.checkpointIndexed = indexed
+	gc.checkpointSize = size
+
+	for _, filepath := range ccd.ProcessedFilepaths {
+		gc.checkpointFilepaths[filepath] = struct{}{}
+	}
+
+	gc.lastCheckpointAt = time.Now()
+
+	collectorLogger.Debugf(nil, "Appended checkpoint with (%d) new records and (%d) newly-processed files: [%s]", len(ccd.Records), len(ccd.ProcessedFilepaths), gc.checkpointFilepath)
+
+	return nil
+}
+
+// checkpointIfDue writes a checkpoint if one is configured and it has been at
+// least the checkpoint-interval since the last one.
+func (gc *GeographicCollector) checkpointIfDue() (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	if gc.checkpointFilepath == "" {
+		return nil
+	} else if gc.lastCheckpointAt.IsZero() == true {
+		// Start the clock with the first file.
+		gc.lastCheckpointAt = time.Now()
+		return nil
+	} else if time.Since(gc.lastCheckpointAt) < gc.checkpointInterval {
+		return nil
+	}
+
+	err = gc.Checkpoint()
+	log.PanicIf(err)
+
+	return nil
+}
+
+// readCheckpoint reads every valid delta from a checkpoint file. Also returns
+// the size of the valid data, which may be followed by an interrupted write.
+func readCheckpoint(filepath string) (deltas []collectorCheckpointDelta, validSize int64, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	f, err := os.Open(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	fi, err := f.Stat()
+	log.PanicIf(err)
+
+	payload, ok, err := readFrame(f, fi.Size())
+	log.PanicIf(err)
+
+	if ok == false {
+		log.Panicf("checkpoint not valid: [%s]", filepath)
+	}
+
+	d := gob.NewDecoder(bytes.NewReader(payload))
+
+	err = readPersistenceHeader(d, persistedKindCollectorCheckpoint)
+	log.PanicIf(err)
+
+	cch := collectorCheckpointHeader{}
+
+	err = d.Decode(&cch)
+	log.PanicIf(err)
+
+	if cch.Version != checkpointVersion {
+		log.Panicf("checkpoint version (%d) not supported", cch.Version)
+	}
+
+	validSize = int64(frameHeaderSize + len(payload))
+	deltas = make([]collectorCheckpointDelta, 0)
+
+	for {
+		payload, ok, err := readFrame(f, fi.Size()-validSize)
+		log.PanicIf(err)
+
+		if ok == false {
+			break
+		}
+
+		ccd := collectorCheckpointDelta{}
+
+		d := gob.NewDecoder(bytes.NewReader(payload))
+
+		err = d.Decode(&ccd)
+		if err != nil {
+			break
+		}
+
+		deltas = append(deltas, ccd)
+		validSize += int64(frameHeaderSize + len(payload))
+	}
+
+	if fi.Size() > validSize {
+		collectorLogger.Warningf(nil, "Ignoring (%d) bytes of incomplete or corrupt data at the end of the checkpoint: [%s]", fi.Size()-validSize, filepath)
+	}
+
+	return deltas, validSize, nil
+}
+
+// LoadCheckpoint restores the indices and the list of processed files from
+// the given checkpoint. Only the records that were indexed when the
+// checkpoint was written are added to the indices, and those that are equal
+// to records that the indices already have are skipped. Files that were
+// already processed will be skipped by subsequent reads. If this is the
+// configured checkpoint file, subsequent checkpoints will continue it.
+func (gc *GeographicCollector) LoadCheckpoint(filepath string) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	deltas, validSize, err := readCheckpoint(filepath)
+	log.PanicIf(err)
+
+	records := make([]*GeographicRecord, 0)
+	indexedPositions := make(map[int]struct{})
+	processedFilepaths := make([]string, 0)
+
+	for _, ccd := range deltas {
+		records, err = appendDecodedRecords(records, ccd.Records)
+		log.PanicIf(err)
+
+		for _, i := range ccd.Indexed {
+			if i < 0 || i >= len(records) {
+				log.Panicf("indexed record is not valid: (%d)", i)
+			}
+
+			indexedPositions[i] = struct{}{}
+		}
+
+		for _, i := range ccd.Unindexed {
+			delete(indexedPositions, i)
+		}
+
+		processedFilepaths = append(processedFilepaths, ccd.ProcessedFilepaths...)
+	}
+
+	// Index in the order that the records were written.
+	positions := make([]int, 0, len(indexedPositions))
+	for i := range indexedPositions {
+		positions = append(positions, i)
+	}
+
+	sort.Ints(positions)
+
+	// This is what the file says is indexed, even if we skip some of them
+	// below. The next checkpoint will reconcile it with the indices.
+	indexed := make(map[*GeographicRecord]struct{}, len(positions))
+	addedCount := 0
+
+	for _, i := range positions {
+		gr := records[i]
+		indexed[gr] = struct{}{}
+
+		if gc.ti != nil && gc.ti.findEqual(gr) != nil {
+			continue
+		} else if gc.ti == nil && gc.gi != nil && gr.HasGeographic == true && gc.gi.findEqual(gr) != nil {
+			continue
+		}
+
+		if gc.ti != nil {
+			err := gc.ti.AddWithRecord(gr)
+			log.PanicIf(err)
+		}
+
+		if gc.gi != nil && gr.HasGeographic == true {
+			err := gc.gi.AddWithRecord(gr)
+			log.PanicIf(err)
+		}
+
+		addedCount++
+	}
+
+	checkpointFilepaths := make(map[string]struct{}, len(processedFilepaths))
+
+	for _, filepath := range processedFilepaths {
+		checkpointFilepaths[filepath] = struct{}{}
+
+		if _, found := gc.processed[filepath]; found == true {
+			continue
+		}
+
+		gc.processed[filepath] = struct{}{}
+		gc.filepathCollector = append(gc.filepathCollector, filepath)
+		gc.visitedCount++
+	}
+
+	if filepath == gc.checkpointFilepath {
+		checkpointPositions := make(map[*GeographicRecord]int, len(records))
+		for i, gr := range records {
+			checkpointPositions[gr] = i
+		}
+
+		gc.checkpointPositions = checkpointPositions
+		gc.checkpointIndexed = indexed
+		gc.checkpointFilepaths = checkpointFilepaths
+		gc.checkpointSize = validSize
+	}
+
+	collectorLogger.Infof(nil, "Loaded checkpoint with (%d) records and (%d) processed files: [%s]", addedCount, len(processedFilepaths), filepath)
+
+	return nil
+}
+
+// ResumeFromPath is `ReadFromPath` but will first load the configured
+// checkpoint if it exists. Files that were already processed will not be
+// processed again.
+func (gc *GeographicCollector) ResumeFromPath(rootPath string) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	if gc.checkpointFilepath == "" {
+		log.Panicf("no checkpoint file-path configured")
+	}
+
+	_, err = os.Stat(gc.checkpointFilepath)
+	if err == nil {
+		err := gc.LoadCheckpoint(gc.checkpointFilepath)
+		log.PanicIf(err)
+	} else if os.IsNotExist(err) == false {
+		log.Panic(err)
+	}
+
+	err = gc.ReadFromPath(rootPath)
+	log.PanicIf(err)
+
+	return nil
+}
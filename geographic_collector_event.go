@@ -0,0 +1,282 @@
+package geoindex
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dsoprea/go-logging"
+)
+
+const (
+	// DefaultTotalsInterval is the default minimum amount of time between
+	// `CollectorEventTotals` events.
+	DefaultTotalsInterval = time.Second
+)
+
+const (
+	// SkipReasonNoProcessor indicates that no processor is registered for the
+	// file's extension.
+	SkipReasonNoProcessor = "no processor for file type"
+
+	// SkipReasonCompressionNotSupported indicates that the file is
+	// compressed but the processor for its type can not read streams.
+	SkipReasonCompressionNotSupported = "processor can not read compressed files"
+
+	// SkipReasonInvalidCoordinates indicates that records were skipped because
+	// their coordinates were not valid (see `NormalizeCoordinates`).
+	SkipReasonInvalidCoordinates = "invalid coordinates"
+)
+
+// CollectorEventType describes what happened in a `CollectorEvent`.
+type CollectorEventType int
+
+const (
+	// CollectorEventDiscovered is emitted when `ReadFromPath` finds a file
+	// that it will process. All discovery happens before any processing so
+	// that the total is known up front.
+	CollectorEventDiscovered CollectorEventType = iota
+
+	// CollectorEventStarted is emitted immediately before a file is
+	// processed.
+	CollectorEventStarted
+
+	// CollectorEventFinished is emitted after a file was successfully
+	// processed. `RecordCount` and `Duration` will be populated.
+	CollectorEventFinished
+
+	// CollectorEventSkipped is emitted for a file that will not be
+	// processed. `Reason` will be populated. It is also emitted, before
+	// `CollectorEventFinished`, for a processed file that had records that
+	// the processor skipped, in which case `RecordCount` will be populated.
+	CollectorEventSkipped
+
+	// CollectorEventFailed is emitted when a file could not be processed.
+	// `Error` will be populated.
+	CollectorEventFailed
+
+	// CollectorEventTotals is emitted periodically (see `SetTotalsInterval`)
+	// and once when `ReadFromPath` completes. `Filepath` will be empty.
+	CollectorEventTotals
+)
+
+func (cet CollectorEventType) String() string {
+	switch cet {
+	case CollectorEventDiscovered:
+		return "discovered"
+	case CollectorEventStarted:
+		return "started"
+	case CollectorEventFinished:
+		return "finished"
+	case CollectorEventSkipped:
+		return "skipped"
+	case CollectorEventFailed:
+		return "failed"
+	case CollectorEventTotals:
+		return "totals"
+	}
+
+	return fmt.Sprintf("unknown<%d>", int(cet))
+}
+
+// CollectorTotals are the running counts for a collector.
+type CollectorTotals struct {
+	Discovered int
+	Finished   int
+	Skipped    int
+	Failed     int
+
+	// Records is the number of records that were added to the indices.
+	Records int
+
+	// SkippedRecords is the number of records that processors skipped.
+	SkippedRecords int
+
+	// Elapsed is the time since the first event was emitted.
+	Elapsed time.Duration
+}
+
+// Remaining returns the number of discovered files that have not yet been
+// finished or failed.
+func (ct CollectorTotals) Remaining() int {
+	remaining := ct.Discovered - ct.Finished - ct.Failed
+	if remaining < 0 {
+		return 0
+	}
+
+	return remaining
+}
+
+// CollectorEvent describes a single bit of progress in a collector.
+type CollectorEvent struct {
+	Type     CollectorEventType
+	Filepath string
+
+	// RecordCount is the number of records that the file produced. Only set
+	// for `CollectorEventFinished` and, for records that were skipped from a
+	// processed file, `CollectorEventSkipped`.
+	RecordCount int
+
+	// Duration is how long it took to process the file. Only set for
+	// `CollectorEventFinished` and `CollectorEventFailed`.
+	Duration time.Duration
+
+	// Reason is why the file was skipped. Only set for
+	// `CollectorEventSkipped`.
+	Reason string
+
+	// Error is why the file failed. Only set for `CollectorEventFailed`.
+	Error error
+
+	// Totals are the running totals as of this event.
+	Totals CollectorTotals
+}
+
+func (ce CollectorEvent) String() string {
+	return fmt.Sprintf("CollectorEvent<TYPE=[%s] F=[%s] RECORDS=(%d) DURATION=[%s] REASON=[%s] ERROR=[%v]>", ce.Type, ce.Filepath, ce.RecordCount, ce.Duration, ce.Reason, ce.Error)
+}
+
+// CollectorEventFunc receives collector events. Returning an error will stop
+// the collector.
+type CollectorEventFunc func(event CollectorEvent) (err error)
+
+// SetEventCallback sets a callback to receive progress events.
+func (gc *GeographicCollector) SetEventCallback(cb CollectorEventFunc) {
+	gc.eventCb = cb
+}
+
+// SetTotalsInterval sets the minimum time between `CollectorEventTotals`
+// events. Totals are only ever emitted between files.
+func (gc *GeographicCollector) SetTotalsInterval(interval time.Duration) {
+	gc.totalsInterval = interval
+}
+
+// Totals returns the current running totals.
+func (gc *GeographicCollector) Totals() CollectorTotals {
+	totals := gc.totals
+
+	if gc.startedAt.IsZero() == false {
+		totals.Elapsed = time.Since(gc.startedAt)
+	}
+
+	return totals
+}
+
+// emit sends the given event to the callback, if one is set.
+func (gc *GeographicCollector) emit(event CollectorEvent) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	if gc.startedAt.IsZero() == true {
+		gc.startedAt = time.Now()
+	}
+
+	if gc.eventCb == nil {
+		return nil
+	}
+
+	event.Totals = gc.Totals()
+
+	err = gc.eventCb(event)
+	log.PanicIf(err)
+
+	return nil
+}
+
+// emitTotals emits a totals event if it has been at least the totals-interval
+// since the last one or `force` is true.
+func (gc *GeographicCollector) emitTotals(force bool) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	now := time.Now()
+
+	if force == false && now.Sub(gc.lastTotalsAt) < gc.totalsInterval {
+		return nil
+	}
+
+	gc.lastTotalsAt = now
+
+	event := CollectorEvent{
+		Type: CollectorEventTotals,
+	}
+
+	err = gc.emit(event)
+	log.PanicIf(err)
+
+	return nil
+}
+
+// skip records and emits a skipped file.
+func (gc *GeographicCollector) skip(filepath string, reason string) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	gc.totals.Skipped++
+
+	event := CollectorEvent{
+		Type:     CollectorEventSkipped,
+		Filepath: filepath,
+		Reason:   reason,
+	}
+
+	err = gc.emit(event)
+	log.PanicIf(err)
+
+	err = gc.emitTotals(false)
+	log.PanicIf(err)
+
+	return nil
+}
+
+// skipRecord counts a record that a processor skipped from the file that is
+// being processed. This is the callback given to `SkippingFileProcessor`
+// processors.
+func (gc *GeographicCollector) skipRecord(filepath string, reason string) (err error) {
+	gc.skippedRecords[reason]++
+	return nil
+}
+
+// emitSkippedRecords records and emits the records that were skipped from the
+// given file, one event per reason.
+func (gc *GeographicCollector) emitSkippedRecords(filepath string) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	reasons := make([]string, 0, len(gc.skippedRecords))
+	for reason := range gc.skippedRecords {
+		reasons = append(reasons, reason)
+	}
+
+	sort.Strings(reasons)
+
+	for _, reason := range reasons {
+		recordCount := gc.skippedRecords[reason]
+
+		gc.totals.SkippedRecords += recordCount
+
+		event := CollectorEvent{
+			Type:        CollectorEventSkipped,
+			Filepath:    filepath,
+			RecordCount: recordCount,
+			Reason:      reason,
+		}
+
+		err := gc.emit(event)
+		log.PanicIf(err)
+	}
+
+	return nil
+}
@@ -1,14 +1,20 @@
 package geoindex
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"os"
 	"path"
 	"reflect"
 	"testing"
 	"time"
 
+	"compress/gzip"
+	"io/ioutil"
+
 	"github.com/dsoprea/go-logging"
+	"github.com/klauspost/compress/zstd"
 )
 
 func TestGeographicCollector_ReadFromPath_Images(t *testing.T) {
@@ -251,3 +257,546 @@ func TestGeographicCollector_SetFileProcessedCallback(t *testing.T) {
 		t.Fatalf("Callback count does not match the visit count: (%d) != (%d)", count, i)
 	}
 }
+
+func TestGeographicCollector_ReadFromFilepath_Compressed(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "geoindex")
+	log.PanicIf(err)
+
+	defer os.RemoveAll(tempPath)
+
+	data, err := ioutil.ReadFile(path.Join(testAssetsPath, "data.gpx"))
+	log.PanicIf(err)
+
+	gzipFilepath := path.Join(tempPath, "data.GPX.gz")
+
+	b := new(bytes.Buffer)
+	gw := gzip.NewWriter(b)
+
+	_, err = gw.Write(data)
+	log.PanicIf(err)
+
+	err = gw.Close()
+	log.PanicIf(err)
+
+	err = ioutil.WriteFile(gzipFilepath, b.Bytes(), 0644)
+	log.PanicIf(err)
+
+	zstdFilepath := path.Join(tempPath, "data.gpx.zst")
+
+	b = new(bytes.Buffer)
+
+	zw, err := zstd.NewWriter(b)
+	log.PanicIf(err)
+
+	_, err = zw.Write(data)
+	log.PanicIf(err)
+
+	err = zw.Close()
+	log.PanicIf(err)
+
+	err = ioutil.WriteFile(zstdFilepath, b.Bytes(), 0644)
+	log.PanicIf(err)
+
+	for _, filepath := range []string{gzipFilepath, zstdFilepath} {
+		ti := NewTimeIndex()
+		gc := NewGeographicCollector(ti, nil)
+
+		err = RegisterDataFileProcessors(gc)
+		log.PanicIf(err)
+
+		err = gc.ReadFromFilepath(filepath)
+		log.PanicIf(err)
+
+		if gc.VisitedCount() != 1 {
+			t.Fatalf("File was not visited: [%s]", filepath)
+		}
+
+		ts := ti.Series()
+		if len(ts) != 3 {
+			t.Fatalf("Expected three records from [%s]: (%d)", filepath, len(ts))
+		}
+
+		gr := ts[0].Items[0].(*GeographicRecord)
+		if gr.Filepath != filepath {
+			t.Fatalf("Record file-path not correct: [%s]", gr.Filepath)
+		} else if gr.Timestamp != time.Date(2009, 10, 17, 18, 37, 26, 0, time.UTC) {
+			t.Fatalf("Record timestamp not correct: [%v]", gr.Timestamp)
+		}
+	}
+}
+
+func TestGeographicCollector_ReadFromFilepath_Deduplicated(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "geoindex")
+	log.PanicIf(err)
+
+	defer os.RemoveAll(tempPath)
+
+	data, err := ioutil.ReadFile(path.Join(testAssetsPath, "data.gpx"))
+	log.PanicIf(err)
+
+	index := NewIndex()
+
+	index.SetDeduplicationPolicy(DeduplicationPolicy{
+		Action: DuplicateKeepFirst,
+	})
+
+	gc := NewGeographicCollectorWithIndex(index)
+
+	err = RegisterDataFileProcessors(gc)
+	log.PanicIf(err)
+
+	// The same track twice.
+	for _, filename := range []string{"first.gpx", "second.gpx"} {
+		filepath := path.Join(tempPath, filename)
+
+		err := ioutil.WriteFile(filepath, data, 0644)
+		log.PanicIf(err)
+
+		err = gc.ReadFromFilepath(filepath)
+		log.PanicIf(err)
+	}
+
+	if index.TimeIndex().RecordCount() != 3 {
+		t.Fatalf("Time-index record count not correct: (%d)", index.TimeIndex().RecordCount())
+	} else if index.GeographicIndex().RecordCount() != 3 {
+		t.Fatalf("Geographic-index record count not correct: (%d)", index.GeographicIndex().RecordCount())
+	}
+
+	for _, gr := range index.GeographicIndex().records() {
+		if gr.Filepath != path.Join(tempPath, "first.gpx") {
+			t.Fatalf("Duplicate record was indexed: [%s]", gr.Filepath)
+		}
+	}
+
+	totals := gc.Totals()
+	if totals.Records != 3 {
+		t.Fatalf("Record total not correct: (%d)", totals.Records)
+	}
+}
+
+func TestGeographicCollector_ReadFromFilepath_SeparatePolicies(t *testing.T) {
+	ti := NewTimeIndex()
+	gi := NewGeographicIndex()
+
+	gi.SetDeduplicationPolicy(DeduplicationPolicy{
+		Action: DuplicateKeepFirst,
+	})
+
+	gc := NewGeographicCollector(ti, gi)
+
+	err := RegisterDataFileProcessors(gc)
+	log.PanicIf(err)
+
+	err = gc.ReadFromFilepath(path.Join(testAssetsPath, "data.gpx"))
+	if err == nil {
+		t.Fatalf("Expected failure for separate deduplication policies.")
+	} else if ti.RecordCount() != 0 || gi.RecordCount() != 0 {
+		t.Fatalf("Records were indexed: (%d) (%d)", ti.RecordCount(), gi.RecordCount())
+	}
+}
+
+func TestGeographicCollector_ReadFromFilepath_CompressedNotSupported(t *testing.T) {
+	ti := NewTimeIndex()
+	gc := NewGeographicCollector(ti, nil)
+
+	err := RegisterImageFileProcessors(gc, 0, nil)
+	log.PanicIf(err)
+
+	// The image processor can not read streams, so this should be ignored
+	// before the file is ever opened.
+	err = gc.ReadFromFilepath("/does/not/exist.jpg.gz")
+	log.PanicIf(err)
+
+	if gc.VisitedCount() != 0 {
+		t.Fatalf("Compressed image should not have been visited.")
+	}
+}
+
+func TestGeographicCollector_SetEventCallback(t *testing.T) {
+	index := NewTimeIndex()
+	gc := NewGeographicCollector(index, nil)
+
+	err := RegisterDataFileProcessors(gc)
+	log.PanicIf(err)
+
+	counts := make(map[CollectorEventType]int)
+	records := make(map[string]int)
+	var lastEvent CollectorEvent
+
+	cb := func(event CollectorEvent) (err error) {
+		if event.Type == CollectorEventStarted && event.Totals.Discovered != 2 {
+			t.Fatalf("Processing started before discovery finished: %s", event)
+		} else if event.Type == CollectorEventSkipped && event.Reason != SkipReasonNoProcessor {
+			t.Fatalf("Skip reason not correct: [%s]", event.Reason)
+		} else if event.Type == CollectorEventFinished {
+			records[path.Base(event.Filepath)] = event.RecordCount
+		}
+
+		counts[event.Type]++
+		lastEvent = event
+
+		return nil
+	}
+
+	gc.SetEventCallback(cb)
+
+	err = gc.ReadFromPath(testAssetsPath)
+	log.PanicIf(err)
+
+	expectedCounts := map[CollectorEventType]int{
+		CollectorEventDiscovered: 2,
+		CollectorEventSkipped:    2,
+		CollectorEventStarted:    2,
+		CollectorEventFinished:   2,
+		CollectorEventTotals:     counts[CollectorEventTotals],
+	}
+
+	if reflect.DeepEqual(counts, expectedCounts) != true {
+		t.Fatalf("Event counts not correct: %v", counts)
+	}
+
+	expectedRecords := map[string]int{
+		"data.gpx":     3,
+		"no_times.gpx": 0,
+	}
+
+	if reflect.DeepEqual(records, expectedRecords) != true {
+		t.Fatalf("Record counts not correct: %v", records)
+	}
+
+	if lastEvent.Type != CollectorEventTotals {
+		t.Fatalf("Last event was not a totals event: %s", lastEvent)
+	}
+
+	totals := lastEvent.Totals
+	if totals.Discovered != 2 || totals.Finished != 2 || totals.Skipped != 2 || totals.Failed != 0 || totals.Records != 3 {
+		t.Fatalf("Totals not correct: %v", totals)
+	} else if totals.Remaining() != 0 {
+		t.Fatalf("Remaining count not correct: (%d)", totals.Remaining())
+	}
+}
+
+func TestGeographicCollector_SetEventCallback_SkippedRecords(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "geoindex")
+	log.PanicIf(err)
+
+	defer os.RemoveAll(tempPath)
+
+	data := `<?xml version="1.0" encoding="UTF-8"?>
+<gpx xmlns="http://www.topografix.com/GPX/1/1">
+  <trk>
+    <trkseg>
+      <trkpt lat="123.456" lon="-122.326897">
+        <time>2009-10-17T18:37:26Z</time>
+      </trkpt>
+      <trkpt lat="47.644548" lon="-122.326897">
+        <time>2009-10-17T18:37:31Z</time>
+      </trkpt>
+    </trkseg>
+  </trk>
+</gpx>`
+
+	filepath := path.Join(tempPath, "data.gpx")
+
+	err = ioutil.WriteFile(filepath, []byte(data), 0644)
+	log.PanicIf(err)
+
+	index := NewTimeIndex()
+	gc := NewGeographicCollector(index, nil)
+
+	err = RegisterDataFileProcessors(gc)
+	log.PanicIf(err)
+
+	events := make([]CollectorEvent, 0)
+
+	cb := func(event CollectorEvent) (err error) {
+		if event.Type != CollectorEventTotals {
+			events = append(events, event)
+		}
+
+		return nil
+	}
+
+	gc.SetEventCallback(cb)
+
+	err = gc.ReadFromFilepath(filepath)
+	log.PanicIf(err)
+
+	if len(events) != 3 {
+		t.Fatalf("Event count not correct: %v", events)
+	}
+
+	skippedEvent := events[1]
+	if skippedEvent.Type != CollectorEventSkipped || skippedEvent.Reason != SkipReasonInvalidCoordinates || skippedEvent.RecordCount != 1 {
+		t.Fatalf("Skipped event not correct: %s", skippedEvent)
+	}
+
+	finishedEvent := events[2]
+	if finishedEvent.Type != CollectorEventFinished || finishedEvent.RecordCount != 1 {
+		t.Fatalf("Finished event not correct: %s", finishedEvent)
+	}
+
+	totals := gc.Totals()
+	if totals.Finished != 1 || totals.Skipped != 0 || totals.Records != 1 || totals.SkippedRecords != 1 {
+		t.Fatalf("Totals not correct: %v", totals)
+	}
+}
+
+func TestGeographicCollector_SetEventCallback_Failed(t *testing.T) {
+	index := NewTimeIndex()
+	gc := NewGeographicCollector(index, nil)
+
+	err := RegisterDataFileProcessors(gc)
+	log.PanicIf(err)
+
+	var failedEvent CollectorEvent
+
+	cb := func(event CollectorEvent) (err error) {
+		if event.Type == CollectorEventFailed {
+			failedEvent = event
+		}
+
+		return nil
+	}
+
+	gc.SetEventCallback(cb)
+
+	filepath := "/does/not/exist.gpx"
+
+	err = gc.ReadFromFilepath(filepath)
+	if err == nil {
+		t.Fatalf("Expected failure for missing file.")
+	}
+
+	if failedEvent.Filepath != filepath {
+		t.Fatalf("Failed event not emitted: %s", failedEvent)
+	} else if failedEvent.Error == nil {
+		t.Fatalf("Failed event does not have an error.")
+	} else if failedEvent.Totals.Failed != 1 {
+		t.Fatalf("Failed total not correct: (%d)", failedEvent.Totals.Failed)
+	}
+}
+
+func TestGeographicCollector_ResumeFromPath(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "geoindex")
+	log.PanicIf(err)
+
+	defer os.RemoveAll(tempPath)
+
+	checkpointFilepath := path.Join(tempPath, "checkpoint")
+
+	ti := NewTimeIndex()
+	gc := NewGeographicCollector(ti, nil)
+
+	err = RegisterDataFileProcessors(gc)
+	log.PanicIf(err)
+
+	gc.SetCheckpoint(checkpointFilepath, 0)
+
+	err = gc.ReadFromPath(testAssetsPath)
+	log.PanicIf(err)
+
+	// Resume into a fresh collector. Nothing should be processed again.
+
+	resumedTi := NewTimeIndex()
+	resumedGi := NewGeographicIndex()
+	resumedGc := NewGeographicCollector(resumedTi, resumedGi)
+
+	err = RegisterDataFileProcessors(resumedGc)
+	log.PanicIf(err)
+
+	resumedGc.SetCheckpoint(checkpointFilepath, 0)
+
+	alreadyProcessed := 0
+	cb := func(event CollectorEvent) (err error) {
+		if event.Type == CollectorEventStarted {
+			t.Fatalf("File was processed again: [%s]", event.Filepath)
+		} else if event.Type == CollectorEventSkipped && event.Reason == SkipReasonAlreadyProcessed {
+			alreadyProcessed++
+		}
+
+		return nil
+	}
+
+	resumedGc.SetEventCallback(cb)
+
+	err = resumedGc.ResumeFromPath(testAssetsPath)
+	log.PanicIf(err)
+
+	if alreadyProcessed != 2 {
+		t.Fatalf("Expected two files to be skipped: (%d)", alreadyProcessed)
+	} else if resumedGc.VisitedCount() != gc.VisitedCount() {
+		t.Fatalf("Visited count not restored: (%d) != (%d)", resumedGc.VisitedCount(), gc.VisitedCount())
+	} else if resumedTi.RecordCount() != 3 {
+		t.Fatalf("Time index not restored: (%d)", resumedTi.RecordCount())
+	}
+
+	original := ti.records()
+	resumed := resumedTi.records()
+
+	for i, gr := range resumed {
+		if gr.Equal(original[i]) != true {
+			t.Fatalf("Restored record (%d) not correct: %s != %s", i, gr, original[i])
+		}
+	}
+
+	results, err := resumedGi.GetWithCoordinatesMetroLimited(47.6445480000, -122.3268970000)
+	log.PanicIf(err)
+
+	if len(results) != 3 {
+		t.Fatalf("Geographic index not restored: (%d)", len(results))
+	}
+}
+
+func TestGeographicCollector_Checkpoint_Deltas(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "geoindex")
+	log.PanicIf(err)
+
+	defer os.RemoveAll(tempPath)
+
+	checkpointFilepath := path.Join(tempPath, "checkpoint")
+
+	ti := NewTimeIndex()
+	gc := NewGeographicCollector(ti, nil)
+	gc.SetCheckpoint(checkpointFilepath, 0)
+
+	now := time.Date(2020, 6, 1, 8, 0, 0, 0, time.UTC)
+
+	gr1 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.34, 34.56, nil)
+	gr2 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now.Add(time.Second), true, 12.35, 34.57, nil)
+
+	// Only referred to by a relationship. It must not be indexed on load.
+	unindexedGr := NewGeographicRecord(SourceGeographicGpx, "other.gpx", now, true, 1.0, 2.0, nil)
+	gr2.AddRelated(unindexedGr, "nearest")
+
+	err = ti.AddRecords([]*GeographicRecord{gr1, gr2})
+	log.PanicIf(err)
+
+	err = gc.Checkpoint()
+	log.PanicIf(err)
+
+	gr3 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now.Add(time.Minute), true, 12.36, 34.58, nil)
+
+	err = ti.AddWithRecord(gr3)
+	log.PanicIf(err)
+
+	err = ti.Remove(gr1)
+	log.PanicIf(err)
+
+	err = gc.Checkpoint()
+	log.PanicIf(err)
+
+	// Nothing changed, so nothing should be written.
+	err = gc.Checkpoint()
+	log.PanicIf(err)
+
+	deltas, _, err := readCheckpoint(checkpointFilepath)
+	log.PanicIf(err)
+
+	if len(deltas) != 2 {
+		t.Fatalf("Delta count not correct: (%d)", len(deltas))
+	} else if len(deltas[0].Records) != 3 {
+		t.Fatalf("First delta record count not correct: (%d)", len(deltas[0].Records))
+	} else if len(deltas[1].Records) != 1 || len(deltas[1].Indexed) != 1 || len(deltas[1].Unindexed) != 1 {
+		t.Fatalf("Second delta not correct: %v", deltas[1])
+	}
+
+	// Simulate an interrupted write.
+
+	f, err := os.OpenFile(checkpointFilepath, os.O_WRONLY|os.O_APPEND, 0)
+	log.PanicIf(err)
+
+	_, err = f.Write([]byte{0, 0, 1, 0, 1, 2, 3})
+	log.PanicIf(err)
+
+	err = f.Close()
+	log.PanicIf(err)
+
+	restoredTi := NewTimeIndex()
+	restoredGc := NewGeographicCollector(restoredTi, nil)
+
+	err = restoredGc.LoadCheckpoint(checkpointFilepath)
+	log.PanicIf(err)
+
+	records := restoredTi.records()
+	if len(records) != 2 {
+		t.Fatalf("Restored record count not correct: (%d)", len(records))
+	} else if records[0].Equal(gr2) != true {
+		t.Fatalf("First restored record not correct: %s", records[0])
+	} else if records[1].Equal(gr3) != true {
+		t.Fatalf("Second restored record not correct: %s", records[1])
+	}
+
+	related := records[0].Relationships()["nearest"]
+	if len(related) != 1 || related[0].Equal(unindexedGr) != true {
+		t.Fatalf("Relationship not restored: %v", related)
+	}
+
+	// Loading again must not add the records twice.
+
+	err = restoredGc.LoadCheckpoint(checkpointFilepath)
+	log.PanicIf(err)
+
+	if restoredTi.RecordCount() != 2 {
+		t.Fatalf("Records added twice: (%d)", restoredTi.RecordCount())
+	}
+}
+
+func TestGeographicCollector_LoadCheckpoint_Continue(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "geoindex")
+	log.PanicIf(err)
+
+	defer os.RemoveAll(tempPath)
+
+	checkpointFilepath := path.Join(tempPath, "checkpoint")
+
+	ti := NewTimeIndex()
+	gc := NewGeographicCollector(ti, nil)
+	gc.SetCheckpoint(checkpointFilepath, 0)
+
+	now := time.Date(2020, 6, 1, 8, 0, 0, 0, time.UTC)
+
+	gr1 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.34, 34.56, nil)
+
+	err = ti.AddWithRecord(gr1)
+	log.PanicIf(err)
+
+	err = gc.Checkpoint()
+	log.PanicIf(err)
+
+	// Resume and continue the same file.
+
+	resumedTi := NewTimeIndex()
+	resumedGc := NewGeographicCollector(resumedTi, nil)
+	resumedGc.SetCheckpoint(checkpointFilepath, 0)
+
+	err = resumedGc.LoadCheckpoint(checkpointFilepath)
+	log.PanicIf(err)
+
+	gr2 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now.Add(time.Second), true, 12.35, 34.57, nil)
+
+	err = resumedTi.AddWithRecord(gr2)
+	log.PanicIf(err)
+
+	err = resumedGc.Checkpoint()
+	log.PanicIf(err)
+
+	deltas, _, err := readCheckpoint(checkpointFilepath)
+	log.PanicIf(err)
+
+	if len(deltas) != 2 {
+		t.Fatalf("Checkpoint not continued: (%d)", len(deltas))
+	} else if len(deltas[1].Records) != 1 {
+		t.Fatalf("Continued delta not correct: (%d)", len(deltas[1].Records))
+	}
+
+	restoredTi := NewTimeIndex()
+	restoredGc := NewGeographicCollector(restoredTi, nil)
+
+	err = restoredGc.LoadCheckpoint(checkpointFilepath)
+	log.PanicIf(err)
+
+	if restoredTi.RecordCount() != 2 {
+		t.Fatalf("Restored record count not correct: (%d)", restoredTi.RecordCount())
+	}
+}
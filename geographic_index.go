@@ -2,6 +2,10 @@ package geoindex
 
 import (
 	"errors"
+	"io"
+	"sort"
+
+	"encoding/gob"
 
 	"github.com/dsoprea/go-logging"
 	"github.com/golang/geo/s2"
@@ -18,10 +22,14 @@ const (
 var (
 	ErrNoGeographicInformation = errors.New("no geographic information")
 	ErrNoNearMatch             = errors.New("no near match")
+	ErrRecordNotFound          = errors.New("record not found")
 )
 
 type GeographicIndex struct {
-	s2Index map[uint64][]*GeographicRecord
+	s2Index     map[uint64][]*GeographicRecord
+	recordCount int
+	dedup       DeduplicationPolicy
+	secondary   *secondaryIndices
 }
 
 func NewGeographicIndex() (gi *GeographicIndex) {
@@ -30,7 +38,35 @@ func NewGeographicIndex() (gi *GeographicIndex) {
 	}
 }
 
-func (gi *GeographicIndex) AddWithRecord(gr *GeographicRecord) (err error) {
+// SetDeduplicationPolicy determines what happens when a record is added that
+// is a duplicate of one that is already indexed. Records that are already
+// indexed are not affected.
+func (gi *GeographicIndex) SetDeduplicationPolicy(dp DeduplicationPolicy) {
+	gi.dedup = dp
+}
+
+// findDuplicate returns the first indexed record that is a duplicate of the
+// given record under the given deduplication policy, or `nil`. Only the cell
+// that the record is in, and its neighbors, at a level that is at least as
+// wide as the distance tolerance, need to be searched.
+func (gi *GeographicIndex) findDuplicate(dp DeduplicationPolicy, gr *GeographicRecord) *GeographicRecord {
+	level := dp.searchLevel()
+	cellId := s2.CellID(gr.S2CellId).Parent(level)
+
+	cellIds := append([]s2.CellID{cellId}, cellId.AllNeighbors(level)...)
+	for _, cellId := range cellIds {
+		for _, indexedGr := range gi.s2Index[uint64(cellId)] {
+			if dp.IsDuplicate(indexedGr, gr) == true {
+				return indexedGr
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateRecord returns an error if the record can not be indexed.
+func (gi *GeographicIndex) validateRecord(gr *GeographicRecord) (err error) {
 	defer func() {
 		if state := recover(); state != nil {
 			err = log.Wrap(state.(error))
@@ -46,6 +82,36 @@ func (gi *GeographicIndex) AddWithRecord(gr *GeographicRecord) (err error) {
 		log.Panicf("only leaf S2 cells are supported")
 	}
 
+	return nil
+}
+
+func (gi *GeographicIndex) AddWithRecord(gr *GeographicRecord) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	err = gi.validateRecord(gr)
+	log.PanicIf(err)
+
+	cellId := s2.CellID(gr.S2CellId)
+
+	if gi.dedup.IsActive() == true {
+		if existingGr := gi.findDuplicate(gi.dedup, gr); existingGr != nil {
+			addIncoming, removeExisting := gi.dedup.resolve(existingGr, gr)
+
+			if removeExisting == true {
+				err := gi.Remove(existingGr)
+				log.PanicIf(err)
+			}
+
+			if addIncoming == false {
+				return nil
+			}
+		}
+	}
+
 	for level := cellId.Level(); level >= MinimumS2LevelForIndexing; level-- {
 		parentCellId := cellId.Parent(level)
 		parentCellIdRaw := uint64(parentCellId)
@@ -57,9 +123,197 @@ func (gi *GeographicIndex) AddWithRecord(gr *GeographicRecord) (err error) {
 		}
 	}
 
+	gi.secondary.add(gr)
+	gi.recordCount++
+
+	return nil
+}
+
+// Remove removes the given record from every cell that it was indexed in. The
+// record is matched by identity. Returns `ErrRecordNotFound` if the record is
+// not in the index.
+func (gi *GeographicIndex) Remove(gr *GeographicRecord) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	cellId := s2.CellID(gr.S2CellId)
+	if gr.S2CellId == 0 || cellId.IsLeaf() == false {
+		return ErrRecordNotFound
+	}
+
+	found := false
+	for level := cellId.Level(); level >= MinimumS2LevelForIndexing; level-- {
+		parentCellIdRaw := uint64(cellId.Parent(level))
+
+		indexed := gi.s2Index[parentCellIdRaw]
+		for i, current := range indexed {
+			if current != gr {
+				continue
+			}
+
+			indexed = append(indexed[:i], indexed[i+1:]...)
+			found = true
+
+			break
+		}
+
+		if len(indexed) == 0 {
+			delete(gi.s2Index, parentCellIdRaw)
+		} else {
+			gi.s2Index[parentCellIdRaw] = indexed
+		}
+	}
+
+	if found == false {
+		return ErrRecordNotFound
+	}
+
+	gi.secondary.remove(gr)
+	gi.recordCount--
+
+	return nil
+}
+
+// findEqual returns the indexed record that is the given record or is equal to
+// it, or `nil`.
+func (gi *GeographicIndex) findEqual(gr *GeographicRecord) *GeographicRecord {
+	// The leaf cell of a record is also the first cell that it's indexed in.
+	for _, indexedGr := range gi.s2Index[gr.S2CellId] {
+		if indexedGr == gr || indexedGr.Equal(gr) == true {
+			return indexedGr
+		}
+	}
+
+	return nil
+}
+
+// Merge adds the records from the other index to this one. The records are
+// shared rather than copied. `policy` determines what happens to records that
+// are already indexed.
+func (gi *GeographicIndex) Merge(other *GeographicIndex, policy MergePolicy) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	toAdd := make([]*GeographicRecord, 0, other.recordCount)
+	for _, gr := range other.records() {
+		if policy != MergeKeepAll && gi.findEqual(gr) != nil {
+			if policy == MergeFailOnDuplicate {
+				return ErrDuplicateRecord
+			}
+
+			continue
+		}
+
+		toAdd = append(toAdd, gr)
+	}
+
+	for _, gr := range toAdd {
+		err := gi.AddWithRecord(gr)
+		log.PanicIf(err)
+	}
+
 	return nil
 }
 
+// Diff returns the records that were added, removed, and changed in the
+// other index relative to this one.
+func (gi *GeographicIndex) Diff(other *GeographicIndex) IndexDiff {
+	return diffRecords(gi.records(), other.records())
+}
+
+// Subset returns a new index with the records that satisfy the predicate. The
+// records are shared rather than copied.
+func (gi *GeographicIndex) Subset(predicate RecordPredicate) (subset *GeographicIndex, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	subset = NewGeographicIndex()
+
+	for _, gr := range gi.records() {
+		if predicate(gr) == false {
+			continue
+		}
+
+		err := subset.AddWithRecord(gr)
+		log.PanicIf(err)
+	}
+
+	return subset, nil
+}
+
+// GetById returns the record with the given ID (see `GeographicRecord.Id`).
+// Returns `ErrRecordNotFound` if there is no such record.
+func (gi *GeographicIndex) GetById(id string) (gr *GeographicRecord, err error) {
+	return gi.lookups().ids.first(id)
+}
+
+// GetByFilepath returns every record from the given file, ordered by time.
+func (gi *GeographicIndex) GetByFilepath(filepath string) []*GeographicRecord {
+	return gi.lookups().filepaths.get(filepath)
+}
+
+// ListFilepaths returns the path of every file that has records in the index,
+// sorted.
+func (gi *GeographicIndex) ListFilepaths() []string {
+	return gi.lookups().filepaths.keys()
+}
+
+// GetBySource returns every record from the given source (e.g.
+// `SourceGeographicGpx`), ordered by time.
+func (gi *GeographicIndex) GetBySource(sourceName string) []*GeographicRecord {
+	return gi.lookups().sources.get(sourceName)
+}
+
+// ListSources returns the name of every source that has records in the index,
+// sorted.
+func (gi *GeographicIndex) ListSources() []string {
+	return gi.lookups().sources.keys()
+}
+
+// RecordCount returns the number of records in the index.
+func (gi *GeographicIndex) RecordCount() int {
+	return gi.recordCount
+}
+
+// lookups returns the secondary indices, building them if this is the first
+// time that they are needed.
+func (gi *GeographicIndex) lookups() *secondaryIndices {
+	if gi.secondary == nil {
+		gi.secondary = newSecondaryIndices(gi.records())
+	}
+
+	return gi.secondary
+}
+
+// records returns every record in the index, ordered by time. Every record is
+// stored in exactly one leaf cell, so we just collect those.
+func (gi *GeographicIndex) records() []*GeographicRecord {
+	records := make([]*GeographicRecord, 0, gi.recordCount)
+
+	for cellIdRaw, indexed := range gi.s2Index {
+		if s2.CellID(cellIdRaw).IsLeaf() == false {
+			continue
+		}
+
+		records = append(records, indexed...)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Timestamp.Before(records[j].Timestamp)
+	})
+
+	return records
+}
+
 // GetWithCoordinatesMetroLimited will return anything between an exact match
 // and the resolution of a general metropolitan area.
 func (gi *GeographicIndex) GetWithCoordinatesMetroLimited(latitude, longitude float64) (results []*GeographicRecord, err error) {
@@ -111,3 +365,124 @@ func (gi *GeographicIndex) GetWithCoordinates(latitude, longitude float64, lowes
 
 	return nil, ErrNoNearMatch
 }
+
+// encodeBuckets converts the cells of the index to the positions of their
+// records as given by `positions`.
+func (gi *GeographicIndex) encodeBuckets(positions map[*GeographicRecord]int) (buckets map[uint64][]int) {
+	buckets = make(map[uint64][]int, len(gi.s2Index))
+
+	for cellIdRaw, indexed := range gi.s2Index {
+		bucket := make([]int, len(indexed))
+		for i, gr := range indexed {
+			bucket[i] = positions[gr]
+		}
+
+		buckets[cellIdRaw] = bucket
+	}
+
+	return buckets
+}
+
+// restoreBuckets replaces the cells of the index with the given ones. The
+// buckets are restored directly rather than recalculating the parent cells of
+// every record.
+func (gi *GeographicIndex) restoreBuckets(records []*GeographicRecord, buckets map[uint64][]int, recordCount int) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	s2Index := make(map[uint64][]*GeographicRecord, len(buckets))
+
+	for cellIdRaw, bucket := range buckets {
+		indexed := make([]*GeographicRecord, len(bucket))
+		for j, i := range bucket {
+			if i < 0 || i >= len(records) {
+				log.Panicf("indexed record is not valid: (%d)", i)
+			}
+
+			indexed[j] = records[i]
+		}
+
+		s2Index[cellIdRaw] = indexed
+	}
+
+	gi.s2Index = s2Index
+	gi.recordCount = recordCount
+	gi.secondary = nil
+
+	return nil
+}
+
+// persistedGeographicIndex is the serialized form of a `GeographicIndex`.
+// `Buckets` has the positions of the indexed records in `Records` for every
+// cell.
+type persistedGeographicIndex struct {
+	Records     []persistedRecord
+	Buckets     map[uint64][]int
+	RecordCount int
+}
+
+// Save writes the index to the given stream. The records are only stored once
+// and the cells are restored directly by `Load`. Use `SaveIndices` to save a
+// `TimeIndex` with the same records.
+func (gi *GeographicIndex) Save(w io.Writer) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	e := gob.NewEncoder(w)
+
+	err = writePersistenceHeader(e, persistedKindGeographicIndex)
+	log.PanicIf(err)
+
+	records := gi.records()
+	encoded, recordPositions := encodeRecordsWithPositions(records)
+
+	positions := make(map[*GeographicRecord]int, len(records))
+	for i, gr := range records {
+		positions[gr] = recordPositions[i]
+	}
+
+	pgi := persistedGeographicIndex{
+		Records:     encoded,
+		Buckets:     gi.encodeBuckets(positions),
+		RecordCount: gi.recordCount,
+	}
+
+	err = e.Encode(pgi)
+	log.PanicIf(err)
+
+	return nil
+}
+
+// Load replaces the content of the index with the index previously written by
+// `Save`.
+func (gi *GeographicIndex) Load(r io.Reader) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	d := gob.NewDecoder(r)
+
+	err = readPersistenceHeader(d, persistedKindGeographicIndex)
+	log.PanicIf(err)
+
+	pgi := persistedGeographicIndex{}
+
+	err = d.Decode(&pgi)
+	log.PanicIf(err)
+
+	records, err := decodeRecords(pgi.Records)
+	log.PanicIf(err)
+
+	err = gi.restoreBuckets(records, pgi.Buckets, pgi.RecordCount)
+	log.PanicIf(err)
+
+	return nil
+}
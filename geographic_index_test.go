@@ -1,6 +1,7 @@
 package geoindex
 
 import (
+	"bytes"
 	"testing"
 	"time"
 
@@ -106,3 +107,211 @@ func TestGeographicIndex_GetWithCoordinates(t *testing.T) {
 		t.Fatalf("Did not fail as expected when looking for a non-match: %v", err)
 	}
 }
+
+func TestGeographicIndex_Save_Load(t *testing.T) {
+	gi := NewGeographicIndex()
+
+	now := time.Now().UTC()
+
+	gr1 := NewGeographicRecord("test source", "test file", now, true, 12.345678, 23.456789, nil)
+
+	err := gi.AddWithRecord(gr1)
+	log.PanicIf(err)
+
+	gr2 := NewGeographicRecord("test source", "test file", now.Add(time.Second), true, 12.345679, 23.456790, nil)
+
+	err = gi.AddWithRecord(gr2)
+	log.PanicIf(err)
+
+	b := new(bytes.Buffer)
+
+	err = gi.Save(b)
+	log.PanicIf(err)
+
+	recovered := NewGeographicIndex()
+
+	err = recovered.Load(b)
+	log.PanicIf(err)
+
+	if recovered.RecordCount() != 2 {
+		t.Fatalf("Record count not correct: (%d)", recovered.RecordCount())
+	} else if len(recovered.s2Index) != len(gi.s2Index) {
+		t.Fatalf("Cell count not correct: (%d) != (%d)", len(recovered.s2Index), len(gi.s2Index))
+	}
+
+	for cellIdRaw, indexed := range gi.s2Index {
+		recoveredIndexed := recovered.s2Index[cellIdRaw]
+		if len(recoveredIndexed) != len(indexed) {
+			t.Fatalf("Cell [%s] not restored correctly.", s2.CellID(cellIdRaw).ToToken())
+		}
+
+		for i, gr := range indexed {
+			if recoveredIndexed[i].Equal(gr) != true {
+				t.Fatalf("Cell [%s] record (%d) not correct: %s", s2.CellID(cellIdRaw).ToToken(), i, recoveredIndexed[i])
+			}
+		}
+	}
+
+	results, err := recovered.GetWithCoordinates(12.345678, 23.456789, 30)
+	log.PanicIf(err)
+
+	if len(results) != 1 || results[0].Equal(gr1) != true {
+		t.Fatalf("Did not find the correct record: %v", results)
+	}
+}
+
+func TestGeographicIndex_Remove(t *testing.T) {
+	gi := NewGeographicIndex()
+
+	now := time.Now()
+
+	gr1 := NewGeographicRecord("test source", "test file", now, true, 12.345678, 23.456789, nil)
+	gr2 := NewGeographicRecord("test source", "test file", now, true, 12.345678, 23.456789, nil)
+
+	for _, gr := range []*GeographicRecord{gr1, gr2} {
+		err := gi.AddWithRecord(gr)
+		log.PanicIf(err)
+	}
+
+	err := gi.Remove(gr1)
+	log.PanicIf(err)
+
+	if gi.RecordCount() != 1 {
+		t.Fatalf("Record count not correct: (%d)", gi.RecordCount())
+	}
+
+	for _, indexed := range gi.s2Index {
+		if len(indexed) != 1 || indexed[0] != gr2 {
+			t.Fatalf("Wrong record removed: %v", indexed)
+		}
+	}
+
+	err = gi.Remove(gr1)
+	if err != ErrRecordNotFound {
+		t.Fatalf("Expected not-found error: %v", err)
+	}
+
+	err = gi.Remove(gr2)
+	log.PanicIf(err)
+
+	if len(gi.s2Index) != 0 {
+		t.Fatalf("Empty cells were not removed: (%d)", len(gi.s2Index))
+	}
+}
+
+func TestGeographicIndex_Merge(t *testing.T) {
+	now := time.Now()
+
+	gr1 := NewGeographicRecord("test source", "test file", now, true, 12.345678, 23.456789, nil)
+	gr1Copy := NewGeographicRecord("test source", "test file", now, true, 12.345678, 23.456789, nil)
+	gr2 := NewGeographicRecord("test source", "test file", now, true, 13.345678, 24.456789, nil)
+
+	gi := NewGeographicIndex()
+
+	err := gi.AddWithRecord(gr1)
+	log.PanicIf(err)
+
+	other := NewGeographicIndex()
+
+	for _, gr := range []*GeographicRecord{gr1Copy, gr2} {
+		err := other.AddWithRecord(gr)
+		log.PanicIf(err)
+	}
+
+	err = gi.Merge(other, MergeFailOnDuplicate)
+	if err != ErrDuplicateRecord {
+		t.Fatalf("Expected duplicate error: %v", err)
+	}
+
+	err = gi.Merge(other, MergeSkipDuplicates)
+	log.PanicIf(err)
+
+	if gi.RecordCount() != 2 {
+		t.Fatalf("Record count not correct: (%d)", gi.RecordCount())
+	}
+
+	results, err := gi.GetWithCoordinates(13.345678, 24.456789, 30)
+	log.PanicIf(err)
+
+	if len(results) != 1 || results[0] != gr2 {
+		t.Fatalf("Merged record not found: %v", results)
+	}
+
+	id := other.Diff(gi)
+	if id.IsEmpty() != true {
+		t.Fatalf("Indices should be equivalent: %s", id)
+	}
+}
+
+func TestGeographicIndex_Subset(t *testing.T) {
+	now := time.Now()
+
+	gr1 := NewGeographicRecord("source1", "test file", now, true, 12.345678, 23.456789, nil)
+	gr2 := NewGeographicRecord("source2", "test file", now, true, 13.345678, 24.456789, nil)
+
+	gi := NewGeographicIndex()
+
+	for _, gr := range []*GeographicRecord{gr1, gr2} {
+		err := gi.AddWithRecord(gr)
+		log.PanicIf(err)
+	}
+
+	subset, err := gi.Subset(func(gr *GeographicRecord) bool {
+		return gr.SourceName == "source2"
+	})
+
+	log.PanicIf(err)
+
+	if subset.RecordCount() != 1 {
+		t.Fatalf("Subset record count not correct: (%d)", subset.RecordCount())
+	}
+
+	_, err = subset.GetWithCoordinates(12.345678, 23.456789, 30)
+	if err != ErrNoNearMatch {
+		t.Fatalf("Excluded record was found: %v", err)
+	}
+}
+
+func TestGeographicIndex_GetByFilepath(t *testing.T) {
+	gi := NewGeographicIndex()
+
+	now := time.Now()
+
+	gr1 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.34, 34.56, nil)
+	gr2 := NewGeographicRecord(SourceGeographicGpx, "other.gpx", now, true, 12.34, 34.56, nil)
+
+	for _, gr := range []*GeographicRecord{gr1, gr2} {
+		err := gi.AddWithRecord(gr)
+		log.PanicIf(err)
+	}
+
+	records := gi.GetByFilepath("other.gpx")
+	if len(records) != 1 || records[0] != gr2 {
+		t.Fatalf("Records not correct: %v", records)
+	}
+
+	err := gi.Remove(gr1)
+	log.PanicIf(err)
+
+	filepaths := gi.ListFilepaths()
+	if len(filepaths) != 1 || filepaths[0] != "other.gpx" {
+		t.Fatalf("File-paths not correct: %v", filepaths)
+	}
+
+	// Lookups also work after the index is restored.
+
+	b := new(bytes.Buffer)
+
+	err = gi.Save(b)
+	log.PanicIf(err)
+
+	recoveredGi := NewGeographicIndex()
+
+	err = recoveredGi.Load(b)
+	log.PanicIf(err)
+
+	records = recoveredGi.GetByFilepath("other.gpx")
+	if len(records) != 1 || records[0].Equal(gr2) != true {
+		t.Fatalf("Records not correct after load: %v", records)
+	}
+}
@@ -1,7 +1,9 @@
 package geoindex
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"path"
 	"reflect"
 	"time"
@@ -13,6 +15,10 @@ import (
 	"github.com/randomingenuity/go-utility/geographic"
 )
 
+var (
+	ErrInvalidCoordinates = errors.New("invalid coordinates")
+)
+
 type GeographicRecordRelatedTo struct {
 	GeographicRecord *GeographicRecord
 	Relationship     string
@@ -31,8 +37,18 @@ type GeographicRecord struct {
 	S2CellId      uint64
 	SourceName    string
 	Metadata      interface{}
-	comments      []string
-	relatedTo     []GeographicRecordRelatedTo
+
+	// Position is the position of the record within its file. Records from
+	// the same file with the same timestamp are distinguished by this.
+	Position int
+
+	// Altitude is the elevation in meters. It is only valid if HasAltitude is
+	// true.
+	HasAltitude bool
+	Altitude    float64
+
+	comments  []string
+	relatedTo []GeographicRecordRelatedTo
 }
 
 func (gr GeographicRecord) String() string {
@@ -52,6 +68,10 @@ func (gr *GeographicRecord) Equal(other *GeographicRecord) bool {
 		return false
 	} else if other.S2CellId != gr.S2CellId {
 		return false
+	} else if other.HasAltitude != gr.HasAltitude {
+		return false
+	} else if fmt.Sprintf("%.2f", other.Altitude) != fmt.Sprintf("%.2f", gr.Altitude) {
+		return false
 	} else if other.SourceName != gr.SourceName {
 		return false
 	} else if reflect.DeepEqual(other.Metadata, gr.Metadata) != true {
@@ -74,6 +94,9 @@ func (gr *GeographicRecord) Dump() {
 	fmt.Printf("Longitude: (%.6f)\n", gr.Longitude)
 	fmt.Printf("S2CellId: (%d)\n", gr.S2CellId)
 	fmt.Printf("SourceName: [%s]\n", gr.SourceName)
+	fmt.Printf("Position: (%d)\n", gr.Position)
+	fmt.Printf("HasAltitude: [%v]\n", gr.HasAltitude)
+	fmt.Printf("Altitude: (%.2f)\n", gr.Altitude)
 	fmt.Printf("Metadata: %v\n", gr.Metadata)
 
 	fmt.Printf("\n")
@@ -92,6 +115,7 @@ func (gr *GeographicRecord) Encode() map[string]interface{} {
 	}
 
 	encoded := map[string]interface{}{
+		"id":            gr.Id(),
 		"timestamp":     gr.Timestamp,
 		"filepath":      gr.Filepath,
 		"source_name":   gr.SourceName,
@@ -106,6 +130,10 @@ func (gr *GeographicRecord) Encode() map[string]interface{} {
 		encoded["s2_token"] = s2.CellID(gr.S2CellId).ToToken()
 	}
 
+	if gr.HasAltitude == true {
+		encoded["altitude"] = gr.Altitude
+	}
+
 	return encoded
 }
 
@@ -141,6 +169,50 @@ func (gr *GeographicRecord) AddRelated(relatedGr *GeographicRecord, relationship
 	gr.relatedTo = append(gr.relatedTo, grrt)
 }
 
+// NormalizeCoordinates checks the given coordinates and returns them in
+// canonical form. Longitudes outside of [-180, 180] are wrapped around.
+// Returns `ErrInvalidCoordinates` if either is not a finite number or if the
+// latitude is outside of [-90, 90].
+func NormalizeCoordinates(latitude, longitude float64) (normalizedLatitude float64, normalizedLongitude float64, err error) {
+	if math.IsNaN(latitude) == true || math.IsInf(latitude, 0) == true {
+		return 0, 0, ErrInvalidCoordinates
+	} else if math.IsNaN(longitude) == true || math.IsInf(longitude, 0) == true {
+		return 0, 0, ErrInvalidCoordinates
+	} else if latitude < -90 || latitude > 90 {
+		return 0, 0, ErrInvalidCoordinates
+	}
+
+	if longitude < -180 || longitude > 180 {
+		longitude = math.Mod(longitude+180, 360)
+		if longitude < 0 {
+			longitude += 360
+		}
+
+		longitude -= 180
+	}
+
+	return latitude, longitude, nil
+}
+
+// NewValidatedGeographicRecord is `NewGeographicRecord` but first normalizes
+// the coordinates with `NormalizeCoordinates` if there is geographic data.
+// Returns `ErrInvalidCoordinates` if they are not valid.
+func NewValidatedGeographicRecord(sourceName string, filepath string, timestamp time.Time, hasGeographic bool, latitude float64, longitude float64, metadata interface{}) (gr *GeographicRecord, err error) {
+	if hasGeographic == true {
+		latitude, longitude, err = NormalizeCoordinates(latitude, longitude)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	gr = NewGeographicRecord(sourceName, filepath, timestamp, hasGeographic, latitude, longitude, metadata)
+
+	return gr, nil
+}
+
+// NewGeographicRecord returns a new record. The coordinates are not checked.
+// Use `NewValidatedGeographicRecord` for coordinates that come from an
+// untrusted source.
 func NewGeographicRecord(sourceName string, filepath string, timestamp time.Time, hasGeographic bool, latitude float64, longitude float64, metadata interface{}) (gr *GeographicRecord) {
 	if metadata == nil {
 		metadata = make(map[string]interface{})
@@ -2,6 +2,7 @@ package geoindex
 
 import (
     "fmt"
+    "math"
     "reflect"
     "testing"
     "time"
@@ -13,6 +14,7 @@ func TestGeographicRecord_Encode(t *testing.T) {
     gr1 := NewGeographicRecord("source-name", "file.name1", now, true, 12.34, 34.56, nil)
 
     expected := map[string]interface{}{
+        "id":            gr1.Id(),
         "timestamp":     now,
         "filepath":      "file.name1",
         "latitude":      12.34,
@@ -90,3 +92,70 @@ func TestGeographicRecord_AddRelated(t *testing.T) {
         t.Fatalf("Relationships not correct.")
     }
 }
+
+func TestGeographicRecord_Id(t *testing.T) {
+    now := time.Now()
+
+    gr1 := NewGeographicRecord("source-name", "file.name1", now, true, 12.34, 34.56, nil)
+    gr2 := NewGeographicRecord("source-name", "file.name1", now.In(time.UTC), true, 12.34, 34.56, nil)
+
+    if gr1.Id() != gr2.Id() {
+        t.Fatalf("Same record does not have the same ID: [%s] != [%s]", gr1.Id(), gr2.Id())
+    } else if len(gr1.Id()) != 32 {
+        t.Fatalf("ID not correct: [%s]", gr1.Id())
+    }
+
+    gr2.Position = 1
+    if gr1.Id() == gr2.Id() {
+        t.Fatalf("Records at different positions have the same ID.")
+    }
+
+    gr3 := NewGeographicRecord("source-name", "file.name2", now, true, 12.34, 34.56, nil)
+    if gr1.Id() == gr3.Id() {
+        t.Fatalf("Records from different files have the same ID.")
+    }
+}
+
+func TestNormalizeCoordinates(t *testing.T) {
+    latitude, longitude, err := NormalizeCoordinates(12.34, 190.0)
+    if err != nil {
+        t.Fatalf("Expected coordinates to be valid: %v", err)
+    } else if latitude != 12.34 || math.Abs(longitude-(-170.0)) > 0.000001 {
+        t.Fatalf("Coordinates not normalized: (%.6f) (%.6f)", latitude, longitude)
+    }
+
+    latitude, longitude, err = NormalizeCoordinates(-90.0, -180.0)
+    if err != nil {
+        t.Fatalf("Expected coordinates at the limits to be valid: %v", err)
+    } else if latitude != -90.0 || longitude != -180.0 {
+        t.Fatalf("Coordinates at the limits should not change: (%.6f) (%.6f)", latitude, longitude)
+    }
+
+    invalid := [][2]float64{
+        {123.456, 34.56},
+        {math.NaN(), 34.56},
+        {12.34, math.Inf(1)},
+    }
+
+    for _, coordinates := range invalid {
+        _, _, err := NormalizeCoordinates(coordinates[0], coordinates[1])
+        if err != ErrInvalidCoordinates {
+            t.Fatalf("Expected coordinates to be invalid: %v", coordinates)
+        }
+    }
+}
+
+func TestNewValidatedGeographicRecord(t *testing.T) {
+    _, err := NewValidatedGeographicRecord("source-name", "file.name1", time.Now(), true, 123.456, 789.012, nil)
+    if err != ErrInvalidCoordinates {
+        t.Fatalf("Expected invalid coordinates: %v", err)
+    }
+
+    // Coordinates are ignored if there is no geographic data.
+    gr, err := NewValidatedGeographicRecord("source-name", "file.name1", time.Now(), false, 123.456, 789.012, nil)
+    if err != nil {
+        t.Fatalf("Expected record without geographic data to be valid: %v", err)
+    } else if gr.S2CellId != 0 {
+        t.Fatalf("Expected no cell.")
+    }
+}
@@ -0,0 +1,189 @@
+package geoindex
+
+import (
+	"io"
+	"time"
+
+	"encoding/json"
+
+	"github.com/dsoprea/go-logging"
+	"github.com/golang/geo/s2"
+)
+
+// GeoJsonExportOptions determines what is exported by
+// `TimeIndex.ExportGeoJson` and how data-log points are split into lines.
+type GeoJsonExportOptions struct {
+	// SegmentGap starts a new line when consecutive points are further apart
+	// in time than this.
+	SegmentGap time.Duration
+
+	// SegmentJump starts a new line when consecutive points are further apart
+	// than this many meters.
+	SegmentJump float64
+
+	// Filter selects the records to export.
+	Filter RecordFilter
+}
+
+type geoJsonGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type geoJsonFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   geoJsonGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJsonFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJsonFeature `json:"features"`
+}
+
+// geoJsonPosition returns the GeoJSON position of the record, which is in
+// longitude-latitude order.
+func geoJsonPosition(gr *GeographicRecord, withAltitude bool) []float64 {
+	if withAltitude == true {
+		return []float64{gr.Longitude, gr.Latitude, gr.Altitude}
+	}
+
+	return []float64{gr.Longitude, gr.Latitude}
+}
+
+// geoJsonProperties returns the same fields as `GeographicRecord.Encode`
+// except for the coordinates, which are in the geometry. Related records are
+// referred to by ID rather than being encoded.
+func geoJsonProperties(gr *GeographicRecord) map[string]interface{} {
+	relationships := make(map[string][]string)
+	for type_, grList := range gr.Relationships() {
+		ids := make([]string, len(grList))
+		for i, relatedGr := range grList {
+			ids[i] = relatedGr.Id()
+		}
+
+		relationships[type_] = ids
+	}
+
+	properties := map[string]interface{}{
+		"id":            gr.Id(),
+		"timestamp":     gr.Timestamp,
+		"filepath":      gr.Filepath,
+		"source_name":   gr.SourceName,
+		"metadata":      gr.Metadata,
+		"comments":      gr.Comments(),
+		"relationships": relationships,
+	}
+
+	if gr.HasGeographic == true {
+		properties["s2_token"] = s2.CellID(gr.S2CellId).ToToken()
+	}
+
+	return properties
+}
+
+func geoJsonPointFeature(gr *GeographicRecord) geoJsonFeature {
+	return geoJsonFeature{
+		Type: "Feature",
+		Geometry: geoJsonGeometry{
+			Type:        "Point",
+			Coordinates: geoJsonPosition(gr, gr.HasAltitude),
+		},
+		Properties: geoJsonProperties(gr),
+	}
+}
+
+// geoJsonLineFeature returns a line for a segment of at least two points. The
+// properties of the individual points are in the "points" property.
+func geoJsonLineFeature(segment []*GeographicRecord) geoJsonFeature {
+	withAltitude := true
+	for _, gr := range segment {
+		if gr.HasAltitude == false {
+			withAltitude = false
+			break
+		}
+	}
+
+	coordinates := make([][]float64, len(segment))
+	points := make([]map[string]interface{}, len(segment))
+
+	for i, gr := range segment {
+		coordinates[i] = geoJsonPosition(gr, withAltitude)
+		points[i] = geoJsonProperties(gr)
+	}
+
+	first := segment[0]
+	last := segment[len(segment)-1]
+
+	return geoJsonFeature{
+		Type: "Feature",
+		Geometry: geoJsonGeometry{
+			Type:        "LineString",
+			Coordinates: coordinates,
+		},
+		Properties: map[string]interface{}{
+			"source_name":     first.SourceName,
+			"filepath":        first.Filepath,
+			"start_timestamp": first.Timestamp,
+			"end_timestamp":   last.Timestamp,
+			"points":          points,
+		},
+	}
+}
+
+// ExportGeoJson writes the records with geographic data that satisfy the
+// filter as a GeoJSON FeatureCollection. The points from each data-log file
+// are written as LineString features, one per segment, and images as Point
+// features. A segment with only one point is written as a Point feature.
+func (index *TimeIndex) ExportGeoJson(w io.Writer, options GeoJsonExportOptions) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	trackRecords := make([]*GeographicRecord, 0)
+	imageRecords := make([]*GeographicRecord, 0)
+
+	for _, gr := range index.filteredRecords(options.Filter) {
+		if gr.HasGeographic == false {
+			continue
+		} else if isTrackRecord(gr) == true {
+			trackRecords = append(trackRecords, gr)
+		} else {
+			imageRecords = append(imageRecords, gr)
+		}
+	}
+
+	gpxOptions := GpxExportOptions{
+		SegmentGap:   options.SegmentGap,
+		SegmentJump:  options.SegmentJump,
+		TrackPerFile: true,
+	}
+
+	fc := geoJsonFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: make([]geoJsonFeature, 0),
+	}
+
+	for _, track := range gpxTracks(trackRecords, gpxOptions) {
+		for _, segment := range track.segments {
+			if len(segment) == 0 {
+				continue
+			} else if len(segment) == 1 {
+				fc.Features = append(fc.Features, geoJsonPointFeature(segment[0]))
+			} else {
+				fc.Features = append(fc.Features, geoJsonLineFeature(segment))
+			}
+		}
+	}
+
+	for _, gr := range imageRecords {
+		fc.Features = append(fc.Features, geoJsonPointFeature(gr))
+	}
+
+	err = json.NewEncoder(w).Encode(fc)
+	log.PanicIf(err)
+
+	return nil
+}
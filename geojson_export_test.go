@@ -0,0 +1,128 @@
+package geoindex
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"encoding/json"
+
+	"github.com/dsoprea/go-logging"
+	"github.com/golang/geo/s2"
+)
+
+func TestTimeIndex_ExportGeoJson(t *testing.T) {
+	ti := NewTimeIndex()
+
+	epoch := time.Date(2020, 6, 1, 8, 0, 0, 0, time.UTC)
+
+	gr1 := NewGeographicRecord(SourceGeographicGpx, "ride.gpx", epoch, true, 10.0, 20.0, nil)
+	gr2 := NewGeographicRecord(SourceGeographicGpx, "ride.gpx", epoch.Add(time.Minute), true, 10.001, 20.0, nil)
+
+	// A gap in time leaves a segment with one point.
+	gr3 := NewGeographicRecord(SourceGeographicGpx, "ride.gpx", epoch.Add(time.Hour*8), true, 10.002, 20.0, nil)
+
+	gr4 := NewGeographicRecord(SourceImageJpeg, "image.jpg", epoch.Add(time.Minute*2), true, 10.001, 20.0, ImageMetadata{CameraModel: "some camera"})
+	gr4.AddComment("some comment")
+	gr4.AddRelated(gr2, "nearest")
+
+	// Related records are only referred to by ID, so cycles are fine.
+	gr2.AddRelated(gr4, "nearest")
+
+	// Not exported.
+	gr5 := NewGeographicRecord(SourceImageJpeg, "image2.jpg", epoch.Add(time.Minute*3), false, 0, 0, nil)
+
+	err := ti.AddRecords([]*GeographicRecord{gr1, gr2, gr3, gr4, gr5})
+	log.PanicIf(err)
+
+	options := GeoJsonExportOptions{
+		SegmentGap: time.Hour,
+	}
+
+	buffer := new(bytes.Buffer)
+
+	err = ti.ExportGeoJson(buffer, options)
+	log.PanicIf(err)
+
+	fc := struct {
+		Type     string
+		Features []struct {
+			Type     string
+			Geometry struct {
+				Type        string
+				Coordinates json.RawMessage
+			}
+			Properties map[string]interface{}
+		}
+	}{}
+
+	err = json.Unmarshal(buffer.Bytes(), &fc)
+	log.PanicIf(err)
+
+	if fc.Type != "FeatureCollection" {
+		t.Fatalf("Type not correct: [%s]", fc.Type)
+	} else if len(fc.Features) != 3 {
+		t.Fatalf("Feature count not correct: (%d)\n%s", len(fc.Features), buffer.String())
+	}
+
+	line := fc.Features[0]
+	if line.Geometry.Type != "LineString" {
+		t.Fatalf("First feature not a line: [%s]", line.Geometry.Type)
+	} else if string(line.Geometry.Coordinates) != "[[20,10],[20,10.001]]" {
+		t.Fatalf("Line coordinates not correct: %s", string(line.Geometry.Coordinates))
+	} else if line.Properties["filepath"] != "ride.gpx" {
+		t.Fatalf("Line file-path not correct: [%v]", line.Properties["filepath"])
+	} else if line.Properties["start_timestamp"] != "2020-06-01T08:00:00Z" || line.Properties["end_timestamp"] != "2020-06-01T08:01:00Z" {
+		t.Fatalf("Line timestamps not correct: %v", line.Properties)
+	}
+
+	points := line.Properties["points"].([]interface{})
+	if len(points) != 2 {
+		t.Fatalf("Line point count not correct: (%d)", len(points))
+	} else if points[1].(map[string]interface{})["id"] != gr2.Id() {
+		t.Fatalf("Line point ID not correct.")
+	}
+
+	expectedRelationships := map[string]interface{}{
+		"nearest": []interface{}{gr4.Id()},
+	}
+
+	if reflect.DeepEqual(points[1].(map[string]interface{})["relationships"], expectedRelationships) != true {
+		t.Fatalf("Line point relationships not correct: %v", points[1])
+	}
+
+	point := fc.Features[1]
+	if point.Geometry.Type != "Point" {
+		t.Fatalf("Second feature not a point: [%s]", point.Geometry.Type)
+	} else if string(point.Geometry.Coordinates) != "[20,10.002]" {
+		t.Fatalf("Point coordinates not correct: %s", string(point.Geometry.Coordinates))
+	} else if point.Properties["id"] != gr3.Id() {
+		t.Fatalf("Point ID not correct.")
+	}
+
+	image := fc.Features[2]
+
+	expectedProperties := map[string]interface{}{
+		"id":          gr4.Id(),
+		"timestamp":   "2020-06-01T08:02:00Z",
+		"filepath":    "image.jpg",
+		"source_name": SourceImageJpeg,
+		"s2_token":    s2.CellID(gr4.S2CellId).ToToken(),
+		"metadata": map[string]interface{}{
+			"CameraModel": "some camera",
+		},
+		"comments": []interface{}{"some comment"},
+		"relationships": map[string]interface{}{
+			"nearest": []interface{}{gr2.Id()},
+		},
+	}
+
+	if image.Geometry.Type != "Point" {
+		t.Fatalf("Third feature not a point: [%s]", image.Geometry.Type)
+	} else if string(image.Geometry.Coordinates) != "[20,10.001]" {
+		t.Fatalf("Image coordinates not correct: %s", string(image.Geometry.Coordinates))
+	} else if reflect.DeepEqual(image.Properties, expectedProperties) != true {
+		t.Fatalf("Image properties not correct: %v", image.Properties)
+	}
+}
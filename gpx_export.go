@@ -0,0 +1,271 @@
+package geoindex
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"encoding/xml"
+
+	"github.com/dsoprea/go-logging"
+)
+
+const (
+	xmlHeader = `<?xml version="1.0" encoding="UTF-8"?>`
+	gpxOpen   = `<gpx xmlns="http://www.topografix.com/GPX/1/1" xmlns:gpxx="http://www.garmin.com/xmlschemas/GpxExtensions/v3" xmlns:gpxtpx="http://www.garmin.com/xmlschemas/TrackPointExtension/v1" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://www.topografix.com/GPX/1/1/gpx.xsd http://www.garmin.com/xmlschemas/GpxExtensions/v3 http://www.garmin.com/xmlschemas/GpxExtensionsv3.xsd http://www.garmin.com/xmlschemas/TrackPointExtension/v1 http://www.garmin.com/xmlschemas/TrackPointExtensionv1.xsd">`
+
+	gpxTimestampLayout = time.RFC3339
+)
+
+// GpxExportOptions determines how records are arranged into tracks and
+// segments by `TimeIndex.ExportGpxWithOptions`. The zero value writes every
+// record with geographic data to a single segment of a single track.
+type GpxExportOptions struct {
+	// SegmentGap starts a new segment when consecutive points are further
+	// apart in time than this.
+	SegmentGap time.Duration
+
+	// SegmentJump starts a new segment when consecutive points are further
+	// apart than this many meters.
+	SegmentJump float64
+
+	// TrackPerFile writes a separate track, named after the file, for the
+	// points from each data-log file. Any other records (e.g. images) are
+	// written to an additional, unnamed track.
+	TrackPerFile bool
+
+	// ImagesAsWaypoints writes image records as waypoints, with the name,
+	// camera model, and a link to the file, rather than as track points.
+	ImagesAsWaypoints bool
+
+	// Filter selects the records to export.
+	Filter RecordFilter
+}
+
+// escapeXml returns the text escaped for use in XML content or attributes.
+func escapeXml(text string) string {
+	b := new(bytes.Buffer)
+
+	err := xml.EscapeText(b, []byte(text))
+	log.PanicIf(err)
+
+	return b.String()
+}
+
+// xmlWriter writes indented XML a line at a time. Every method panics on
+// error.
+type xmlWriter struct {
+	w         io.Writer
+	lineCount int
+}
+
+// line writes one line at the given depth. Lines are separated, rather than
+// terminated, by newlines.
+func (xw *xmlWriter) line(depth int, format string, args ...interface{}) {
+	if xw.lineCount > 0 {
+		_, err := io.WriteString(xw.w, "\n")
+		log.PanicIf(err)
+	}
+
+	_, err := io.WriteString(xw.w, strings.Repeat("  ", depth)+fmt.Sprintf(format, args...))
+	log.PanicIf(err)
+
+	xw.lineCount++
+}
+
+// gpxWriter writes GPX.
+type gpxWriter struct {
+	*xmlWriter
+}
+
+func newGpxWriter(w io.Writer) *gpxWriter {
+	return &gpxWriter{
+		xmlWriter: &xmlWriter{
+			w: w,
+		},
+	}
+}
+
+func (gw *gpxWriter) begin() {
+	gw.line(0, "%s", xmlHeader)
+	gw.line(0, "%s", gpxOpen)
+}
+
+func (gw *gpxWriter) end() {
+	gw.line(0, "</gpx>")
+}
+
+func (gw *gpxWriter) beginTrack(name string) {
+	gw.line(1, "<trk>")
+
+	if name != "" {
+		gw.line(2, "<name>%s</name>", escapeXml(name))
+	}
+}
+
+func (gw *gpxWriter) endTrack() {
+	gw.line(1, "</trk>")
+}
+
+func (gw *gpxWriter) beginSegment() {
+	gw.line(2, "<trkseg>")
+}
+
+func (gw *gpxWriter) endSegment() {
+	gw.line(2, "</trkseg>")
+}
+
+func (gw *gpxWriter) waypoint(gr *GeographicRecord) {
+	gw.line(1, `<wpt lat="%s" lon="%s">`, strconv.FormatFloat(gr.Latitude, 'f', -1, 64), strconv.FormatFloat(gr.Longitude, 'f', -1, 64))
+	gw.line(2, "<time>%s</time>", gr.Timestamp.Format(gpxTimestampLayout))
+	gw.line(2, "<name>%s</name>", escapeXml(path.Base(gr.Filepath)))
+
+	if cameraModel := recordCameraModel(gr); cameraModel != "" {
+		gw.line(2, "<desc>%s</desc>", escapeXml(cameraModel))
+	}
+
+	gw.line(2, `<link href="%s"/>`, escapeXml(gr.Filepath))
+	gw.line(1, "</wpt>")
+}
+
+func (gw *gpxWriter) trackPoint(gr *GeographicRecord) {
+	gw.line(3, `<trkpt lat="%s" lon="%s">`, strconv.FormatFloat(gr.Latitude, 'f', -1, 64), strconv.FormatFloat(gr.Longitude, 'f', -1, 64))
+
+	// The schema requires the elevation to precede the time.
+	if gr.HasAltitude == true {
+		gw.line(4, "<ele>%s</ele>", strconv.FormatFloat(gr.Altitude, 'f', -1, 64))
+	}
+
+	gw.line(4, "<time>%s</time>", gr.Timestamp.Format(gpxTimestampLayout))
+	gw.line(3, "</trkpt>")
+}
+
+// gpxTrack is the records that will be written as one track.
+type gpxTrack struct {
+	name     string
+	segments [][]*GeographicRecord
+}
+
+// trackName returns the name of a track from the given file: the name of the
+// file without any extensions.
+func trackName(filepath string) string {
+	name := path.Base(filepath)
+
+	extension, compressionExtension := splitCompressedExtension(name)
+	name = name[:len(name)-len(compressionExtension)]
+	name = name[:len(name)-len(extension)]
+
+	return name
+}
+
+// gpxTracks arranges the records with geographic data into tracks and
+// segments. The records must be in time order.
+func gpxTracks(records []*GeographicRecord, options GpxExportOptions) []*gpxTrack {
+	tracks := make([]*gpxTrack, 0)
+	tracksByKey := make(map[string]*gpxTrack)
+	previousByKey := make(map[string]*GeographicRecord)
+
+	for _, gr := range records {
+		if gr.HasGeographic == false {
+			continue
+		}
+
+		key := ""
+		if options.TrackPerFile == true && isTrackRecord(gr) == true {
+			key = gr.Filepath
+		}
+
+		track, found := tracksByKey[key]
+		if found == false {
+			track = &gpxTrack{
+				segments: [][]*GeographicRecord{{}},
+			}
+
+			if key != "" {
+				track.name = trackName(key)
+			}
+
+			tracks = append(tracks, track)
+			tracksByKey[key] = track
+		}
+
+		if previous := previousByKey[key]; previous != nil {
+			if options.SegmentGap > 0 && gr.Timestamp.Sub(previous.Timestamp) > options.SegmentGap {
+				track.segments = append(track.segments, []*GeographicRecord{})
+			} else if options.SegmentJump > 0 && recordDistanceMeters(previous, gr) > options.SegmentJump {
+				track.segments = append(track.segments, []*GeographicRecord{})
+			}
+		}
+
+		last := len(track.segments) - 1
+		track.segments[last] = append(track.segments[last], gr)
+
+		previousByKey[key] = gr
+	}
+
+	// We always write at least one track.
+	if len(tracks) == 0 {
+		track := &gpxTrack{
+			segments: [][]*GeographicRecord{{}},
+		}
+
+		tracks = append(tracks, track)
+	}
+
+	return tracks
+}
+
+// ExportGpxWithOptions writes the records with geographic data that satisfy
+// the filter to GPX, arranged into tracks and segments according to the
+// options.
+func (index *TimeIndex) ExportGpxWithOptions(w io.Writer, options GpxExportOptions) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	records := index.filteredRecords(options.Filter)
+
+	gw := newGpxWriter(w)
+
+	gw.begin()
+
+	// Waypoints have to precede the tracks.
+	if options.ImagesAsWaypoints == true {
+		trackRecords := make([]*GeographicRecord, 0, len(records))
+		for _, gr := range records {
+			if gr.HasGeographic == true && isTrackRecord(gr) == false {
+				gw.waypoint(gr)
+			} else {
+				trackRecords = append(trackRecords, gr)
+			}
+		}
+
+		records = trackRecords
+	}
+
+	for _, track := range gpxTracks(records, options) {
+		gw.beginTrack(track.name)
+
+		for _, segment := range track.segments {
+			gw.beginSegment()
+
+			for _, gr := range segment {
+				gw.trackPoint(gr)
+			}
+
+			gw.endSegment()
+		}
+
+		gw.endTrack()
+	}
+
+	gw.end()
+
+	return nil
+}
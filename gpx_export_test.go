@@ -0,0 +1,161 @@
+package geoindex
+
+import (
+	"bytes"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dsoprea/go-logging"
+)
+
+func TestTimeIndex_ExportGpx_Format(t *testing.T) {
+	ti := NewTimeIndex()
+
+	gdfp := NewGpxDataFileProcessor()
+
+	err := gdfp.Process(ti, nil, path.Join(testAssetsPath, "data.gpx"))
+	log.PanicIf(err)
+
+	timestamp := time.Date(2018, 4, 28, 21, 23, 12, 0, time.UTC)
+
+	err = ti.AddWithRecord(NewGeographicRecord(SourceImageJpeg, "image.jpg", timestamp, true, 26.586666666666666, -80.05361111111111, nil))
+	log.PanicIf(err)
+
+	// Records without geographic data are not exported.
+	err = ti.AddWithRecord(NewGeographicRecord(SourceImageJpeg, "image2.jpg", timestamp, false, 0, 0, nil))
+	log.PanicIf(err)
+
+	buffer := new(bytes.Buffer)
+
+	err = ti.ExportGpx(buffer)
+	log.PanicIf(err)
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<gpx xmlns="http://www.topografix.com/GPX/1/1" xmlns:gpxx="http://www.garmin.com/xmlschemas/GpxExtensions/v3" xmlns:gpxtpx="http://www.garmin.com/xmlschemas/TrackPointExtension/v1" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://www.topografix.com/GPX/1/1/gpx.xsd http://www.garmin.com/xmlschemas/GpxExtensions/v3 http://www.garmin.com/xmlschemas/GpxExtensionsv3.xsd http://www.garmin.com/xmlschemas/TrackPointExtension/v1 http://www.garmin.com/xmlschemas/TrackPointExtensionv1.xsd">
+  <trk>
+    <trkseg>
+      <trkpt lat="47.644548" lon="-122.326897">
+        <ele>4.46</ele>
+        <time>2009-10-17T18:37:26Z</time>
+      </trkpt>
+      <trkpt lat="47.644548" lon="-122.326897">
+        <ele>4.94</ele>
+        <time>2009-10-17T18:37:31Z</time>
+      </trkpt>
+      <trkpt lat="47.644548" lon="-122.326897">
+        <ele>6.87</ele>
+        <time>2009-10-17T18:37:34Z</time>
+      </trkpt>
+      <trkpt lat="26.586666666666666" lon="-80.05361111111111">
+        <time>2018-04-28T21:23:12Z</time>
+      </trkpt>
+    </trkseg>
+  </trk>
+</gpx>`
+
+	actual := buffer.String()
+
+	if actual != expected {
+		t.Fatalf("GPX data not correct:\n%s", actual)
+	}
+}
+
+func TestTimeIndex_ExportGpxWithOptions(t *testing.T) {
+	ti := NewTimeIndex()
+
+	epoch := time.Date(2020, 6, 1, 8, 0, 0, 0, time.UTC)
+
+	records := []*GeographicRecord{
+		NewGeographicRecord(SourceGeographicGpx, "/trips/morning & evening.gpx.gz", epoch, true, 10.0, 20.0, nil),
+		NewGeographicRecord(SourceGeographicGpx, "/trips/morning & evening.gpx.gz", epoch.Add(time.Minute), true, 10.001, 20.0, nil),
+
+		// A gap in time.
+		NewGeographicRecord(SourceGeographicGpx, "/trips/morning & evening.gpx.gz", epoch.Add(time.Hour*8), true, 10.002, 20.0, nil),
+
+		// A jump in distance.
+		NewGeographicRecord(SourceGeographicGpx, "/trips/morning & evening.gpx.gz", epoch.Add(time.Hour*8+time.Minute), true, 11.0, 20.0, nil),
+
+		NewGeographicRecord(SourceGeographicGpx, "/trips/other.gpx", epoch.Add(time.Minute*2), true, 30.0, 40.0, nil),
+		NewGeographicRecord(SourceImageJpeg, "/images/image.jpg", epoch.Add(time.Minute*3), true, 10.001, 20.0, nil),
+	}
+
+	err := ti.AddRecords(records)
+	log.PanicIf(err)
+
+	options := GpxExportOptions{
+		SegmentGap:   time.Hour,
+		SegmentJump:  10000,
+		TrackPerFile: true,
+	}
+
+	buffer := new(bytes.Buffer)
+
+	err = ti.ExportGpxWithOptions(buffer, options)
+	log.PanicIf(err)
+
+	output := buffer.String()
+
+	if count := strings.Count(output, "<trk>"); count != 3 {
+		t.Fatalf("Track count not correct: (%d)\n%s", count, output)
+	} else if count := strings.Count(output, "<trkseg>"); count != 5 {
+		t.Fatalf("Segment count not correct: (%d)\n%s", count, output)
+	} else if count := strings.Count(output, "<trkpt "); count != 6 {
+		t.Fatalf("Point count not correct: (%d)\n%s", count, output)
+	} else if strings.Contains(output, "<name>morning &amp; evening</name>") != true {
+		t.Fatalf("Track name not correct:\n%s", output)
+	} else if strings.Contains(output, "<name>other</name>") != true {
+		t.Fatalf("Second track name not correct:\n%s", output)
+	}
+}
+
+func TestTimeIndex_ExportGpxWithOptions_ImagesAsWaypoints(t *testing.T) {
+	ti := NewTimeIndex()
+
+	epoch := time.Date(2020, 6, 1, 8, 0, 0, 0, time.UTC)
+
+	records := []*GeographicRecord{
+		NewGeographicRecord(SourceGeographicGpx, "/trips/ride.gpx", epoch, true, 10.0, 20.0, nil),
+		NewGeographicRecord(SourceImageJpeg, "/images/a & b.jpg", epoch.Add(time.Minute), true, 10.001, 20.0, ImageMetadata{CameraModel: "some camera"}),
+		NewGeographicRecord(SourceGeographicGpx, "/trips/ride.gpx", epoch.Add(time.Minute*2), true, 10.002, 20.0, nil),
+	}
+
+	err := ti.AddRecords(records)
+	log.PanicIf(err)
+
+	options := GpxExportOptions{
+		ImagesAsWaypoints: true,
+	}
+
+	buffer := new(bytes.Buffer)
+
+	err = ti.ExportGpxWithOptions(buffer, options)
+	log.PanicIf(err)
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+` + gpxOpen + `
+  <wpt lat="10.001" lon="20">
+    <time>2020-06-01T08:01:00Z</time>
+    <name>a &amp; b.jpg</name>
+    <desc>some camera</desc>
+    <link href="/images/a &amp; b.jpg"/>
+  </wpt>
+  <trk>
+    <trkseg>
+      <trkpt lat="10" lon="20">
+        <time>2020-06-01T08:00:00Z</time>
+      </trkpt>
+      <trkpt lat="10.002" lon="20">
+        <time>2020-06-01T08:02:00Z</time>
+      </trkpt>
+    </trkseg>
+  </trk>
+</gpx>`
+
+	actual := buffer.String()
+
+	if actual != expected {
+		t.Fatalf("GPX data not correct:\n%s", actual)
+	}
+}
@@ -26,6 +26,7 @@ var (
 type JpegImageFileProcessor struct {
 	timestampSkew     time.Duration
 	cameraModelFilter map[string]struct{}
+	skippedRecordCb   SkippedRecordFunc
 }
 
 func NewJpegImageFileProcessor() *JpegImageFileProcessor {
@@ -46,6 +47,30 @@ func (jifp *JpegImageFileProcessor) Name() string {
 	return "JpegImageFileProcessor"
 }
 
+// SetSkippedRecordCallback sets a callback to be called for every image that
+// is skipped because its coordinates are not valid.
+func (jifp *JpegImageFileProcessor) SetSkippedRecordCallback(cb SkippedRecordFunc) {
+	jifp.skippedRecordCb = cb
+}
+
+// skipInvalidCoordinates reports an image that has invalid coordinates.
+func (jifp *JpegImageFileProcessor) skipInvalidCoordinates(filepath string, latitude, longitude float64) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	ipLogger.Warningf(nil, "Image has invalid coordinates (%.6f, %.6f). Skipping: [%s]", latitude, longitude, filepath)
+
+	if jifp.skippedRecordCb != nil {
+		err := jifp.skippedRecordCb(filepath, SkipReasonInvalidCoordinates)
+		log.PanicIf(err)
+	}
+
+	return nil
+}
+
 func (jifp *JpegImageFileProcessor) getFirstExifTagStringValue(ifd *exif.Ifd, tagName string) (value string, err error) {
 	defer func() {
 		if state := recover(); state != nil {
@@ -118,6 +143,29 @@ func (jifp *JpegImageFileProcessor) Process(ti *TimeIndex, gi *GeographicIndex,
 		gd := gob.NewDecoder(c)
 		err := gd.Decode(gr)
 		log.PanicIf(err)
+
+		// The cache may have been written before we validated coordinates.
+		if gr.HasGeographic == true {
+			latitude, longitude := gr.Latitude, gr.Longitude
+
+			gr, err = NewValidatedGeographicRecord(
+				gr.SourceName,
+				gr.Filepath,
+				gr.Timestamp,
+				gr.HasGeographic,
+				latitude,
+				longitude,
+				gr.Metadata)
+
+			if err == ErrInvalidCoordinates {
+				err := jifp.skipInvalidCoordinates(filepath, latitude, longitude)
+				log.PanicIf(err)
+
+				return nil
+			}
+
+			log.PanicIf(err)
+		}
 	} else if os.IsNotExist(err) == false {
 		// There's an issue reading. Try to remove.
 		err := os.Remove(cacheFilepath)
@@ -272,7 +320,7 @@ func (jifp *JpegImageFileProcessor) Process(ti *TimeIndex, gi *GeographicIndex,
 			CameraModel: cameraModel,
 		}
 
-		gr = NewGeographicRecord(
+		gr, err = NewValidatedGeographicRecord(
 			SourceImageJpeg,
 			filepath,
 			timestamp,
@@ -281,6 +329,15 @@ func (jifp *JpegImageFileProcessor) Process(ti *TimeIndex, gi *GeographicIndex,
 			longitude,
 			im)
 
+		if err == ErrInvalidCoordinates {
+			err := jifp.skipInvalidCoordinates(filepath, latitude, longitude)
+			log.PanicIf(err)
+
+			return nil
+		}
+
+		log.PanicIf(err)
+
 		if cacheFilepath != "" {
 			g, err := os.OpenFile(cacheFilepath, os.O_CREATE|os.O_RDWR, 0644)
 			log.PanicIf(err)
@@ -0,0 +1,292 @@
+package geoindex
+
+import (
+	"io"
+	"time"
+
+	"github.com/dsoprea/go-logging"
+	"github.com/dsoprea/go-time-index"
+)
+
+// Index owns a `TimeIndex` and a `GeographicIndex` and keeps them consistent.
+// Every record is added to the time-index and, if it has geographic data, to
+// the geographic-index. The ID, file-path, and source lookups are kept once,
+// by the time-index, since it has every record.
+type Index struct {
+	ti    *TimeIndex
+	gi    *GeographicIndex
+	dedup DeduplicationPolicy
+}
+
+func NewIndex() (index *Index) {
+	return &Index{
+		ti: NewTimeIndex(),
+		gi: NewGeographicIndex(),
+	}
+}
+
+// TimeIndex returns the underlying time-index.
+func (index *Index) TimeIndex() *TimeIndex {
+	return index.ti
+}
+
+// GeographicIndex returns the underlying geographic-index. This only has the
+// records with geographic data.
+func (index *Index) GeographicIndex() *GeographicIndex {
+	return index.gi
+}
+
+// SetDeduplicationPolicy determines what happens when a record is added that
+// is a duplicate of one that is already indexed. Duplicates are resolved once
+// for both indices so that they always have the same records. The
+// underlying indices are not given a policy of their own.
+func (index *Index) SetDeduplicationPolicy(dp DeduplicationPolicy) {
+	index.dedup = dp
+
+	index.ti.SetDeduplicationPolicy(DeduplicationPolicy{})
+	index.gi.SetDeduplicationPolicy(DeduplicationPolicy{})
+}
+
+// RecordCount returns the number of records in the index.
+func (index *Index) RecordCount() int {
+	return index.ti.RecordCount()
+}
+
+func (index *Index) AddWithRecord(gr *GeographicRecord) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	if gr.HasGeographic == true {
+		err := index.gi.validateRecord(gr)
+		log.PanicIf(err)
+	}
+
+	if index.dedup.IsActive() == true {
+		// Every record is in the time-index, so that is where we look.
+		if existingGr := index.ti.findDuplicate(index.dedup, gr); existingGr != nil {
+			addIncoming, removeExisting := index.dedup.resolve(existingGr, gr)
+
+			if removeExisting == true {
+				err := index.Remove(existingGr)
+				log.PanicIf(err)
+			}
+
+			if addIncoming == false {
+				return nil
+			}
+		}
+	}
+
+	err = index.ti.AddWithRecord(gr)
+	log.PanicIf(err)
+
+	if gr.HasGeographic == true {
+		err := index.gi.AddWithRecord(gr)
+		log.PanicIf(err)
+	}
+
+	return nil
+}
+
+// AddRecords adds many records at once. See `TimeIndex.AddRecords`. If a
+// deduplication policy is set, the records are added one at a time instead.
+func (index *Index) AddRecords(records []*GeographicRecord) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	if index.dedup.IsActive() == true {
+		for _, gr := range records {
+			err := index.AddWithRecord(gr)
+			log.PanicIf(err)
+		}
+
+		return nil
+	}
+
+	for _, gr := range records {
+		if gr.HasGeographic == true {
+			err := index.gi.validateRecord(gr)
+			log.PanicIf(err)
+		}
+	}
+
+	err = index.ti.AddRecords(records)
+	log.PanicIf(err)
+
+	for _, gr := range records {
+		if gr.HasGeographic == false {
+			continue
+		}
+
+		err := index.gi.AddWithRecord(gr)
+		log.PanicIf(err)
+	}
+
+	return nil
+}
+
+func (index *Index) Add(sourceName string, filepath string, timestamp time.Time, hasGeographic bool, latitude float64, longitude float64, metadata interface{}) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	gr := NewGeographicRecord(sourceName, filepath, timestamp, hasGeographic, latitude, longitude, metadata)
+
+	err = index.AddWithRecord(gr)
+	log.PanicIf(err)
+
+	return nil
+}
+
+// Remove removes the given record from both indices. The record is matched by
+// identity. Returns `ErrRecordNotFound` if the record is not in the index.
+func (index *Index) Remove(gr *GeographicRecord) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	err = index.ti.Remove(gr)
+	if err == ErrRecordNotFound {
+		return err
+	}
+
+	log.PanicIf(err)
+
+	if gr.HasGeographic == true {
+		err := index.gi.Remove(gr)
+		if err != nil && err != ErrRecordNotFound {
+			log.Panic(err)
+		}
+	}
+
+	return nil
+}
+
+func (index *Index) Series() timeindex.TimeSlice {
+	return index.ti.Series()
+}
+
+// Range returns the part of the series from `from` (inclusive) to `to`
+// (exclusive). See `TimeIndex.Range`.
+func (index *Index) Range(from, to time.Time) timeindex.TimeSlice {
+	return index.ti.Range(from, to)
+}
+
+// Iterate returns an iterator over the whole index. See `TimeIndex.Iterate`.
+func (index *Index) Iterate(direction IterationDirection) *TimeIndexIterator {
+	return index.ti.Iterate(direction)
+}
+
+// IterateFromCursor returns an iterator that resumes from the given cursor.
+// See `TimeIndex.IterateFromCursor`.
+func (index *Index) IterateFromCursor(cursor TimeIndexCursor, direction IterationDirection) (tii *TimeIndexIterator, err error) {
+	return index.ti.IterateFromCursor(cursor, direction)
+}
+
+// GetWithCoordinates returns the records nearest to the given coordinates.
+// See `GeographicIndex.GetWithCoordinates`.
+func (index *Index) GetWithCoordinates(latitude, longitude float64, lowestAllowedLevel int) (results []*GeographicRecord, err error) {
+	return index.gi.GetWithCoordinates(latitude, longitude, lowestAllowedLevel)
+}
+
+// GetWithCoordinatesMetroLimited returns the records nearest to the given
+// coordinates. See `GeographicIndex.GetWithCoordinatesMetroLimited`.
+func (index *Index) GetWithCoordinatesMetroLimited(latitude, longitude float64) (results []*GeographicRecord, err error) {
+	return index.gi.GetWithCoordinatesMetroLimited(latitude, longitude)
+}
+
+// GetById returns the record with the given ID (see `GeographicRecord.Id`).
+// Returns `ErrRecordNotFound` if there is no such record.
+func (index *Index) GetById(id string) (gr *GeographicRecord, err error) {
+	return index.ti.GetById(id)
+}
+
+// GetByFilepath returns every record from the given file, ordered by time.
+func (index *Index) GetByFilepath(filepath string) []*GeographicRecord {
+	return index.ti.GetByFilepath(filepath)
+}
+
+// ListFilepaths returns the path of every file that has records in the index,
+// sorted.
+func (index *Index) ListFilepaths() []string {
+	return index.ti.ListFilepaths()
+}
+
+// GetBySource returns every record from the given source, ordered by time.
+func (index *Index) GetBySource(sourceName string) []*GeographicRecord {
+	return index.ti.GetBySource(sourceName)
+}
+
+// ListSources returns the name of every source that has records in the index,
+// sorted.
+func (index *Index) ListSources() []string {
+	return index.ti.ListSources()
+}
+
+func (index *Index) ExportGpx(w io.Writer) (err error) {
+	return index.ti.ExportGpx(w)
+}
+
+// ExportGpxWithOptions writes the selected records to GPX. See
+// `TimeIndex.ExportGpxWithOptions`.
+func (index *Index) ExportGpxWithOptions(w io.Writer, options GpxExportOptions) (err error) {
+	return index.ti.ExportGpxWithOptions(w, options)
+}
+
+// ExportGeoJson writes the selected records as GeoJSON. See
+// `TimeIndex.ExportGeoJson`.
+func (index *Index) ExportGeoJson(w io.Writer, options GeoJsonExportOptions) (err error) {
+	return index.ti.ExportGeoJson(w, options)
+}
+
+// ExportKml writes the selected records as KML or KMZ. See
+// `TimeIndex.ExportKml`.
+func (index *Index) ExportKml(w io.Writer, options KmlExportOptions) (err error) {
+	return index.ti.ExportKml(w, options)
+}
+
+// ExportCsv writes the selected records as CSV. See `TimeIndex.ExportCsv`.
+func (index *Index) ExportCsv(w io.Writer, options CsvExportOptions) (err error) {
+	return index.ti.ExportCsv(w, options)
+}
+
+// Save writes both indices to the given stream. See `SaveIndices`.
+func (index *Index) Save(w io.Writer) (err error) {
+	return SaveIndices(w, index.ti, index.gi)
+}
+
+// Load replaces the content of the index with the index previously written by
+// `Save`. The underlying indices are updated in place, so collectors that
+// were created for this index can still be used.
+func (index *Index) Load(r io.Reader) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	ti, gi, err := LoadIndices(r)
+	log.PanicIf(err)
+
+	if ti == nil || gi == nil {
+		log.Panicf("both indices must have been saved")
+	}
+
+	ti.dedup = index.ti.dedup
+	gi.dedup = index.gi.dedup
+
+	*index.ti = *ti
+	*index.gi = *gi
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package geoindex
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrDuplicateRecord = errors.New("duplicate record")
+)
+
+// MergePolicy determines what happens when merging a record that is equal (see
+// `GeographicRecord.Equal`) to one that is already indexed.
+type MergePolicy int
+
+const (
+	// MergeKeepAll adds every record, even if an equal one is already
+	// indexed.
+	MergeKeepAll MergePolicy = iota
+
+	// MergeSkipDuplicates only adds records that are not already indexed.
+	MergeSkipDuplicates
+
+	// MergeFailOnDuplicate fails with `ErrDuplicateRecord` without adding
+	// anything if any record is already indexed.
+	MergeFailOnDuplicate
+)
+
+// RecordPredicate decides whether a record should be included.
+type RecordPredicate func(gr *GeographicRecord) bool
+
+// RecordChange is a record that is in both indices but that is not equal.
+type RecordChange struct {
+	Old *GeographicRecord
+	New *GeographicRecord
+}
+
+// IndexDiff describes the difference between two indices. Records are
+// considered to be the same record if they have the same timestamp, file-path,
+// and source, and are considered changed if they are then not equal (see
+// `GeographicRecord.Equal`).
+type IndexDiff struct {
+	Added   []*GeographicRecord
+	Removed []*GeographicRecord
+	Changed []RecordChange
+}
+
+// IsEmpty returns true if there are no differences.
+func (id IndexDiff) IsEmpty() bool {
+	return len(id.Added) == 0 && len(id.Removed) == 0 && len(id.Changed) == 0
+}
+
+func (id IndexDiff) String() string {
+	return fmt.Sprintf("IndexDiff<ADDED=(%d) REMOVED=(%d) CHANGED=(%d)>", len(id.Added), len(id.Removed), len(id.Changed))
+}
+
+// recordDiffKey returns what identifies a record for the purpose of a diff.
+func recordDiffKey(gr *GeographicRecord) string {
+	return fmt.Sprintf("%d|%s|%s", gr.Timestamp.UnixNano(), gr.SourceName, gr.Filepath)
+}
+
+// diffRecords compares two lists of records. The results are in the order of
+// the given lists.
+func diffRecords(from, to []*GeographicRecord) (id IndexDiff) {
+	id = IndexDiff{
+		Added:   make([]*GeographicRecord, 0),
+		Removed: make([]*GeographicRecord, 0),
+		Changed: make([]RecordChange, 0),
+	}
+
+	keys := make([]string, 0)
+	fromByKey := make(map[string][]*GeographicRecord)
+	toByKey := make(map[string][]*GeographicRecord)
+
+	for _, gr := range from {
+		key := recordDiffKey(gr)
+		if _, found := fromByKey[key]; found == false {
+			keys = append(keys, key)
+		}
+
+		fromByKey[key] = append(fromByKey[key], gr)
+	}
+
+	for _, gr := range to {
+		key := recordDiffKey(gr)
+		if _, found := fromByKey[key]; found == false {
+			if _, found := toByKey[key]; found == false {
+				keys = append(keys, key)
+			}
+		}
+
+		toByKey[key] = append(toByKey[key], gr)
+	}
+
+	for _, key := range keys {
+		fromRecords := fromByKey[key]
+		toRecords := toByKey[key]
+
+		// Pair off the records that are equal first.
+
+		unmatchedFrom := make([]*GeographicRecord, 0, len(fromRecords))
+		unmatchedTo := make([]*GeographicRecord, len(toRecords))
+		copy(unmatchedTo, toRecords)
+
+		for _, fromGr := range fromRecords {
+			matched := false
+			for i, toGr := range unmatchedTo {
+				if fromGr == toGr || fromGr.Equal(toGr) == true {
+					unmatchedTo = append(unmatchedTo[:i], unmatchedTo[i+1:]...)
+					matched = true
+
+					break
+				}
+			}
+
+			if matched == false {
+				unmatchedFrom = append(unmatchedFrom, fromGr)
+			}
+		}
+
+		// Whatever is left over in both was changed.
+
+		for len(unmatchedFrom) > 0 && len(unmatchedTo) > 0 {
+			rc := RecordChange{
+				Old: unmatchedFrom[0],
+				New: unmatchedTo[0],
+			}
+
+			id.Changed = append(id.Changed, rc)
+
+			unmatchedFrom = unmatchedFrom[1:]
+			unmatchedTo = unmatchedTo[1:]
+		}
+
+		id.Removed = append(id.Removed, unmatchedFrom...)
+		id.Added = append(id.Added, unmatchedTo...)
+	}
+
+	return id
+}
@@ -0,0 +1,37 @@
+package geoindex
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDiffRecords(t *testing.T) {
+	now := time.Now()
+
+	unchanged := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.34, 34.56, nil)
+	removed := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now.Add(time.Second), true, 12.34, 34.56, nil)
+	oldChanged := NewGeographicRecord(SourceImageJpeg, "image.jpg", now, false, 0, 0, nil)
+
+	newChanged := NewGeographicRecord(SourceImageJpeg, "image.jpg", now, true, 12.34, 34.56, nil)
+	added := NewGeographicRecord(SourceGeographicGpx, "other.gpx", now, true, 12.34, 34.56, nil)
+
+	// An equal but distinct record is not a change.
+	unchangedCopy := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.34, 34.56, nil)
+
+	id := diffRecords(
+		[]*GeographicRecord{unchanged, removed, oldChanged},
+		[]*GeographicRecord{unchangedCopy, newChanged, added})
+
+	if len(id.Added) != 1 || id.Added[0] != added {
+		t.Fatalf("Added not correct: %v", id.Added)
+	} else if len(id.Removed) != 1 || id.Removed[0] != removed {
+		t.Fatalf("Removed not correct: %v", id.Removed)
+	} else if len(id.Changed) != 1 || id.Changed[0].Old != oldChanged || id.Changed[0].New != newChanged {
+		t.Fatalf("Changed not correct: %v", id.Changed)
+	}
+
+	id = diffRecords([]*GeographicRecord{unchanged}, []*GeographicRecord{unchangedCopy})
+	if id.IsEmpty() != true {
+		t.Fatalf("Expected no differences: %s", id)
+	}
+}
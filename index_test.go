@@ -0,0 +1,118 @@
+package geoindex
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dsoprea/go-logging"
+)
+
+func TestIndex_AddWithRecord_Remove(t *testing.T) {
+	index := NewIndex()
+
+	now := time.Now()
+
+	gr1 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.34, 34.56, nil)
+	gr2 := NewGeographicRecord(SourceImageJpeg, "image.jpg", now.Add(time.Minute), false, 0, 0, nil)
+
+	for _, gr := range []*GeographicRecord{gr1, gr2} {
+		err := index.AddWithRecord(gr)
+		log.PanicIf(err)
+	}
+
+	if index.RecordCount() != 2 {
+		t.Fatalf("Record count not correct: (%d)", index.RecordCount())
+	} else if index.GeographicIndex().RecordCount() != 1 {
+		t.Fatalf("Geographic-index record count not correct: (%d)", index.GeographicIndex().RecordCount())
+	}
+
+	results, err := index.GetWithCoordinatesMetroLimited(12.34, 34.56)
+	log.PanicIf(err)
+
+	if len(results) != 1 || results[0] != gr1 {
+		t.Fatalf("Coordinate results not correct: %v", results)
+	}
+
+	if sources := index.ListSources(); reflect.DeepEqual(sources, []string{SourceGeographicGpx, SourceImageJpeg}) != true {
+		t.Fatalf("Sources not correct: %v", sources)
+	} else if records := index.GetBySource(SourceImageJpeg); len(records) != 1 || records[0] != gr2 {
+		t.Fatalf("Source records not correct: %v", records)
+	}
+
+	err = index.Remove(gr1)
+	log.PanicIf(err)
+
+	if index.RecordCount() != 1 || index.GeographicIndex().RecordCount() != 0 {
+		t.Fatalf("Record counts not correct after remove: (%d) (%d)", index.RecordCount(), index.GeographicIndex().RecordCount())
+	} else if sources := index.ListSources(); reflect.DeepEqual(sources, []string{SourceImageJpeg}) != true {
+		t.Fatalf("Sources not correct after remove: %v", sources)
+	}
+
+	err = index.Remove(gr1)
+	if err != ErrRecordNotFound {
+		t.Fatalf("Expected record to not be found: %v", err)
+	}
+}
+
+func TestIndex_Save_Load(t *testing.T) {
+	index := NewIndex()
+
+	now := time.Now().UTC()
+
+	gr1 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.34, 34.56, nil)
+	gr2 := NewGeographicRecord(SourceImageJpeg, "image.jpg", now.Add(time.Minute), false, 0, 0, nil)
+
+	err := index.AddRecords([]*GeographicRecord{gr1, gr2})
+	log.PanicIf(err)
+
+	b := new(bytes.Buffer)
+
+	err = index.Save(b)
+	log.PanicIf(err)
+
+	recovered := NewIndex()
+
+	// The collector should see the loaded records.
+	gc := NewGeographicCollectorWithIndex(recovered)
+
+	err = recovered.Load(b)
+	log.PanicIf(err)
+
+	if recovered.RecordCount() != 2 || gc.recordCount() != 2 {
+		t.Fatalf("Record count not correct: (%d) (%d)", recovered.RecordCount(), gc.recordCount())
+	}
+
+	timeGr, err := recovered.GetById(gr1.Id())
+	log.PanicIf(err)
+
+	results, err := recovered.GetWithCoordinatesMetroLimited(12.34, 34.56)
+	log.PanicIf(err)
+
+	if results[0] != timeGr {
+		t.Fatalf("Indices do not share the same record.")
+	} else if timeGr.Equal(gr1) != true {
+		t.Fatalf("Record not correct: %s", timeGr)
+	}
+}
+
+func TestIndex_Lookups(t *testing.T) {
+	index := NewIndex()
+
+	gr := NewGeographicRecord(SourceGeographicGpx, "data.gpx", time.Now(), true, 12.34, 34.56, nil)
+
+	err := index.AddWithRecord(gr)
+	log.PanicIf(err)
+
+	if found, err := index.GetById(gr.Id()); err != nil || found != gr {
+		t.Fatalf("Record not found by ID.")
+	}
+
+	// The lookups are only kept by the time-index.
+	if index.ti.secondary == nil {
+		t.Fatalf("Time-index lookups were not built.")
+	} else if index.gi.secondary != nil {
+		t.Fatalf("Geographic-index lookups were built.")
+	}
+}
@@ -0,0 +1,294 @@
+package geoindex
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"time"
+
+	"archive/zip"
+
+	"github.com/dsoprea/go-logging"
+)
+
+const (
+	kmlOpen = `<kml xmlns="http://www.opengis.net/kml/2.2" xmlns:gx="http://www.google.com/kml/ext/2.2">`
+
+	// kmzDocumentName is the name of the KML document within a KMZ archive.
+	kmzDocumentName = "doc.kml"
+
+	kmlImagesFolderName = "Images"
+	kmlDayLayout        = "2006-01-02"
+)
+
+// KmlFolderGrouping determines how placemarks are arranged into folders.
+type KmlFolderGrouping int
+
+const (
+	// KmlFolderNone writes every placemark directly into the document.
+	KmlFolderNone KmlFolderGrouping = iota
+
+	// KmlFolderPerFile writes a folder for each data-log file. Images are
+	// written to an additional "Images" folder.
+	KmlFolderPerFile
+
+	// KmlFolderPerDay writes a folder for each day (see
+	// `KmlExportOptions.Location`). A track that crosses midnight is split
+	// between the two days.
+	KmlFolderPerDay
+)
+
+func (kfg KmlFolderGrouping) String() string {
+	switch kfg {
+	case KmlFolderNone:
+		return "none"
+	case KmlFolderPerFile:
+		return "file"
+	case KmlFolderPerDay:
+		return "day"
+	}
+
+	return fmt.Sprintf("KmlFolderGrouping<%d>", int(kfg))
+}
+
+// KmlExportOptions determines what is exported by `TimeIndex.ExportKml` and
+// how it is arranged.
+type KmlExportOptions struct {
+	// Folders determines how placemarks are arranged into folders.
+	Folders KmlFolderGrouping
+
+	// Location is the time zone that days are determined in for
+	// `KmlFolderPerDay`. Defaults to UTC.
+	Location *time.Location
+
+	// SegmentGap starts a new track when consecutive points are further apart
+	// in time than this.
+	SegmentGap time.Duration
+
+	// SegmentJump starts a new track when consecutive points are further
+	// apart than this many meters.
+	SegmentJump float64
+
+	// Kmz writes a KMZ archive rather than a plain KML document.
+	Kmz bool
+
+	// Filter selects the records to export.
+	Filter RecordFilter
+}
+
+// kmlWriter writes KML.
+type kmlWriter struct {
+	*xmlWriter
+}
+
+func newKmlWriter(w io.Writer) *kmlWriter {
+	return &kmlWriter{
+		xmlWriter: &xmlWriter{
+			w: w,
+		},
+	}
+}
+
+func (kw *kmlWriter) begin() {
+	kw.line(0, "%s", xmlHeader)
+	kw.line(0, "%s", kmlOpen)
+	kw.line(1, "<Document>")
+}
+
+func (kw *kmlWriter) end() {
+	kw.line(1, "</Document>")
+	kw.line(0, "</kml>")
+}
+
+func (kw *kmlWriter) beginFolder(name string) {
+	kw.line(2, "<Folder>")
+	kw.line(3, "<name>%s</name>", escapeXml(name))
+}
+
+func (kw *kmlWriter) endFolder() {
+	kw.line(2, "</Folder>")
+}
+
+// track writes a placemark with a gx:Track for every segment. The depth is the
+// depth of the placemark.
+func (kw *kmlWriter) track(depth int, track *gpxTrack) {
+	kw.line(depth, "<Placemark>")
+
+	if track.name != "" {
+		kw.line(depth+1, "<name>%s</name>", escapeXml(track.name))
+	}
+
+	kw.line(depth+1, "<gx:MultiTrack>")
+	kw.line(depth+2, "<gx:interpolate>0</gx:interpolate>")
+
+	for _, segment := range track.segments {
+		kw.line(depth+2, "<gx:Track>")
+
+		for _, gr := range segment {
+			kw.line(depth+3, "<when>%s</when>", gr.Timestamp.Format(time.RFC3339))
+		}
+
+		for _, gr := range segment {
+			altitude := float64(0)
+			if gr.HasAltitude == true {
+				altitude = gr.Altitude
+			}
+
+			kw.line(depth+3, "<gx:coord>%s %s %s</gx:coord>", strconv.FormatFloat(gr.Longitude, 'f', -1, 64), strconv.FormatFloat(gr.Latitude, 'f', -1, 64), strconv.FormatFloat(altitude, 'f', -1, 64))
+		}
+
+		kw.line(depth+2, "</gx:Track>")
+	}
+
+	kw.line(depth+1, "</gx:MultiTrack>")
+	kw.line(depth, "</Placemark>")
+}
+
+// image writes a placemark for an image. The depth is the depth of the
+// placemark.
+func (kw *kmlWriter) image(depth int, gr *GeographicRecord) {
+	kw.line(depth, "<Placemark>")
+	kw.line(depth+1, "<name>%s</name>", escapeXml(path.Base(gr.Filepath)))
+
+	if cameraModel := recordCameraModel(gr); cameraModel != "" {
+		kw.line(depth+1, "<description>%s</description>", escapeXml(cameraModel))
+	}
+
+	kw.line(depth+1, "<TimeStamp>")
+	kw.line(depth+2, "<when>%s</when>", gr.Timestamp.Format(time.RFC3339))
+	kw.line(depth+1, "</TimeStamp>")
+	kw.line(depth+1, "<Point>")
+	kw.line(depth+2, "<coordinates>%s,%s</coordinates>", strconv.FormatFloat(gr.Longitude, 'f', -1, 64), strconv.FormatFloat(gr.Latitude, 'f', -1, 64))
+	kw.line(depth+1, "</Point>")
+	kw.line(depth, "</Placemark>")
+}
+
+// kmlFolder is the records that will be written to one folder.
+type kmlFolder struct {
+	name    string
+	records []*GeographicRecord
+}
+
+// kmlFolders arranges the records with geographic data into folders, in the
+// order that they are first encountered. The records must be in time order.
+func kmlFolders(records []*GeographicRecord, options KmlExportOptions) []*kmlFolder {
+	location := options.Location
+	if location == nil {
+		location = time.UTC
+	}
+
+	folders := make([]*kmlFolder, 0)
+	foldersByName := make(map[string]*kmlFolder)
+
+	for _, gr := range records {
+		if gr.HasGeographic == false {
+			continue
+		}
+
+		name := ""
+		if options.Folders == KmlFolderPerFile {
+			if isTrackRecord(gr) == true {
+				name = trackName(gr.Filepath)
+			} else {
+				name = kmlImagesFolderName
+			}
+		} else if options.Folders == KmlFolderPerDay {
+			name = gr.Timestamp.In(location).Format(kmlDayLayout)
+		}
+
+		folder, found := foldersByName[name]
+		if found == false {
+			folder = &kmlFolder{
+				name: name,
+			}
+
+			folders = append(folders, folder)
+			foldersByName[name] = folder
+		}
+
+		folder.records = append(folder.records, gr)
+	}
+
+	return folders
+}
+
+// writeKml writes the records as a KML document.
+func writeKml(w io.Writer, records []*GeographicRecord, options KmlExportOptions) {
+	gpxOptions := GpxExportOptions{
+		SegmentGap:   options.SegmentGap,
+		SegmentJump:  options.SegmentJump,
+		TrackPerFile: true,
+	}
+
+	kw := newKmlWriter(w)
+
+	kw.begin()
+
+	for _, folder := range kmlFolders(records, options) {
+		depth := 2
+		if folder.name != "" {
+			kw.beginFolder(folder.name)
+			depth = 3
+		}
+
+		trackRecords := make([]*GeographicRecord, 0, len(folder.records))
+		imageRecords := make([]*GeographicRecord, 0)
+
+		for _, gr := range folder.records {
+			if isTrackRecord(gr) == true {
+				trackRecords = append(trackRecords, gr)
+			} else {
+				imageRecords = append(imageRecords, gr)
+			}
+		}
+
+		// `gpxTracks` always returns a track, even if empty.
+		if len(trackRecords) > 0 {
+			for _, track := range gpxTracks(trackRecords, gpxOptions) {
+				kw.track(depth, track)
+			}
+		}
+
+		for _, gr := range imageRecords {
+			kw.image(depth, gr)
+		}
+
+		if folder.name != "" {
+			kw.endFolder()
+		}
+	}
+
+	kw.end()
+}
+
+// ExportKml writes the records with geographic data that satisfy the filter
+// as KML (or KMZ). The points from each data-log file are written as a
+// placemark with a gx:Track for each segment, which has the time of every
+// point, and images are written as placemarks with a timestamp.
+func (index *TimeIndex) ExportKml(w io.Writer, options KmlExportOptions) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	records := index.filteredRecords(options.Filter)
+
+	if options.Kmz == false {
+		writeKml(w, records, options)
+		return nil
+	}
+
+	zw := zip.NewWriter(w)
+
+	f, err := zw.Create(kmzDocumentName)
+	log.PanicIf(err)
+
+	writeKml(f, records, options)
+
+	err = zw.Close()
+	log.PanicIf(err)
+
+	return nil
+}
@@ -0,0 +1,159 @@
+package geoindex
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"archive/zip"
+
+	"github.com/dsoprea/go-logging"
+)
+
+func kmlTestRecords() (records []*GeographicRecord) {
+	epoch := time.Date(2020, 6, 1, 23, 0, 0, 0, time.UTC)
+
+	records = []*GeographicRecord{
+		NewGeographicRecord(SourceGeographicGpx, "/trips/ride.gpx", epoch, true, 10.0, 20.0, nil),
+		NewGeographicRecord(SourceGeographicGpx, "/trips/ride.gpx", epoch.Add(time.Minute), true, 10.001, 20.0, nil),
+		NewGeographicRecord(SourceImageJpeg, "/images/image.jpg", epoch.Add(time.Minute*2), true, 10.001, 20.0, ImageMetadata{CameraModel: "some camera"}),
+
+		// The next day.
+		NewGeographicRecord(SourceGeographicGpx, "/trips/ride.gpx", epoch.Add(time.Hour*2), true, 10.002, 20.0, nil),
+		NewGeographicRecord(SourceGeographicGpx, "/trips/walk.gpx", epoch.Add(time.Hour*3), true, 30.0, 40.0, nil),
+	}
+
+	return records
+}
+
+func TestTimeIndex_ExportKml(t *testing.T) {
+	ti := getTestIndex(kmlTestRecords())
+
+	buffer := new(bytes.Buffer)
+
+	err := ti.ExportKml(buffer, KmlExportOptions{})
+	log.PanicIf(err)
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<kml xmlns="http://www.opengis.net/kml/2.2" xmlns:gx="http://www.google.com/kml/ext/2.2">
+  <Document>
+    <Placemark>
+      <name>ride</name>
+      <gx:MultiTrack>
+        <gx:interpolate>0</gx:interpolate>
+        <gx:Track>
+          <when>2020-06-01T23:00:00Z</when>
+          <when>2020-06-01T23:01:00Z</when>
+          <when>2020-06-02T01:00:00Z</when>
+          <gx:coord>20 10 0</gx:coord>
+          <gx:coord>20 10.001 0</gx:coord>
+          <gx:coord>20 10.002 0</gx:coord>
+        </gx:Track>
+      </gx:MultiTrack>
+    </Placemark>
+    <Placemark>
+      <name>walk</name>
+      <gx:MultiTrack>
+        <gx:interpolate>0</gx:interpolate>
+        <gx:Track>
+          <when>2020-06-02T02:00:00Z</when>
+          <gx:coord>40 30 0</gx:coord>
+        </gx:Track>
+      </gx:MultiTrack>
+    </Placemark>
+    <Placemark>
+      <name>image.jpg</name>
+      <description>some camera</description>
+      <TimeStamp>
+        <when>2020-06-01T23:02:00Z</when>
+      </TimeStamp>
+      <Point>
+        <coordinates>20,10.001</coordinates>
+      </Point>
+    </Placemark>
+  </Document>
+</kml>`
+
+	actual := buffer.String()
+
+	if actual != expected {
+		t.Fatalf("KML not correct:\n%s", actual)
+	}
+}
+
+func TestTimeIndex_ExportKml_Folders(t *testing.T) {
+	ti := getTestIndex(kmlTestRecords())
+
+	cases := []struct {
+		options KmlExportOptions
+		folders []string
+		tracks  int
+	}{
+		{KmlExportOptions{Folders: KmlFolderPerFile}, []string{"ride", "Images", "walk"}, 2},
+		{KmlExportOptions{Folders: KmlFolderPerDay}, []string{"2020-06-01", "2020-06-02"}, 3},
+		{KmlExportOptions{Folders: KmlFolderPerDay, Location: time.FixedZone("UTC+2", 2*60*60)}, []string{"2020-06-02"}, 2},
+		{KmlExportOptions{Folders: KmlFolderPerFile, SegmentGap: time.Hour}, []string{"ride", "Images", "walk"}, 3},
+	}
+
+	for i, c := range cases {
+		buffer := new(bytes.Buffer)
+
+		err := ti.ExportKml(buffer, c.options)
+		log.PanicIf(err)
+
+		output := buffer.String()
+
+		if count := strings.Count(output, "<Folder>"); count != len(c.folders) {
+			t.Fatalf("Case (%d): folder count not correct: (%d)\n%s", i, count, output)
+		} else if count := strings.Count(output, "<gx:Track>"); count != c.tracks {
+			t.Fatalf("Case (%d): track count not correct: (%d)\n%s", i, count, output)
+		}
+
+		position := 0
+		for _, name := range c.folders {
+			j := strings.Index(output[position:], "<Folder>\n      <name>"+name+"</name>")
+			if j == -1 {
+				t.Fatalf("Case (%d): folder [%s] not found in order:\n%s", i, name, output)
+			}
+
+			position += j + 1
+		}
+	}
+}
+
+func TestTimeIndex_ExportKml_Kmz(t *testing.T) {
+	ti := getTestIndex(kmlTestRecords())
+
+	kmlBuffer := new(bytes.Buffer)
+
+	err := ti.ExportKml(kmlBuffer, KmlExportOptions{})
+	log.PanicIf(err)
+
+	kmzBuffer := new(bytes.Buffer)
+
+	err = ti.ExportKml(kmzBuffer, KmlExportOptions{Kmz: true})
+	log.PanicIf(err)
+
+	zr, err := zip.NewReader(bytes.NewReader(kmzBuffer.Bytes()), int64(kmzBuffer.Len()))
+	log.PanicIf(err)
+
+	if len(zr.File) != 1 {
+		t.Fatalf("KMZ should have exactly one file: (%d)", len(zr.File))
+	} else if zr.File[0].Name != "doc.kml" {
+		t.Fatalf("KMZ document name not correct: [%s]", zr.File[0].Name)
+	}
+
+	f, err := zr.File[0].Open()
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(f)
+	log.PanicIf(err)
+
+	if string(data) != kmlBuffer.String() {
+		t.Fatalf("KMZ document not correct:\n%s", string(data))
+	}
+}
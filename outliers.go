@@ -0,0 +1,249 @@
+package geoindex
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/dsoprea/go-logging"
+)
+
+const (
+	// DefaultMaximumSpeed is the default fastest speed (in meters per second)
+	// that we consider possible. This is a bit faster than an airliner.
+	DefaultMaximumSpeed = 300.0
+
+	// outlierLookahead is how many points after a jump we look at to see if
+	// the track comes back. If it doesn't, the jump was real.
+	outlierLookahead = 10
+)
+
+// OutlierReason describes why a record is an outlier.
+type OutlierReason int
+
+const (
+	// OutlierImpossibleSpeed indicates that getting to the record from the
+	// previous point in the track, and back again, would require an
+	// impossible speed.
+	OutlierImpossibleSpeed OutlierReason = iota
+
+	// OutlierNullIsland indicates that the coordinates are (0, 0), which is
+	// what many devices write when they have no fix.
+	OutlierNullIsland
+
+	// OutlierOutOfRange indicates that the latitude or longitude is not a
+	// valid coordinate.
+	OutlierOutOfRange
+)
+
+func (reason OutlierReason) String() string {
+	switch reason {
+	case OutlierImpossibleSpeed:
+		return "impossible speed"
+	case OutlierNullIsland:
+		return "null island"
+	case OutlierOutOfRange:
+		return "out of range"
+	}
+
+	return fmt.Sprintf("unknown<%d>", int(reason))
+}
+
+// Outlier is a record whose coordinates are probably wrong.
+type Outlier struct {
+	Record *GeographicRecord
+	Reason OutlierReason
+}
+
+// Comment returns the comment that we add to the record.
+func (o Outlier) Comment() string {
+	return fmt.Sprintf("outlier: %s", o.Reason)
+}
+
+func (o Outlier) String() string {
+	return fmt.Sprintf("Outlier<REASON=[%s] RECORD=%s>", o.Reason, o.Record)
+}
+
+// coordinatesInRange returns true if the coordinates are numbers within the
+// valid range.
+func coordinatesInRange(latitude, longitude float64) bool {
+	if math.IsNaN(latitude) == true || math.IsNaN(longitude) == true {
+		return false
+	}
+
+	return latitude >= -90 && latitude <= 90 && longitude >= -180 && longitude <= 180
+}
+
+// isNullIsland returns true if the coordinates are (0, 0) to six decimal
+// places.
+func isNullIsland(latitude, longitude float64) bool {
+	return math.Abs(latitude) < 0.0000005 && math.Abs(longitude) < 0.0000005
+}
+
+// OutlierDetector finds records with coordinates that are probably wrong.
+// Every record with geographic data is checked for invalid and (0, 0)
+// coordinates. Data-log points are also checked against the points around
+// them from the same file for jumps that would require an impossible speed.
+// Images are not since they might come from a different device.
+type OutlierDetector struct {
+	// MaximumSpeed is the fastest speed (in meters per second) that we
+	// consider possible. If it's zero, `DefaultMaximumSpeed` is used.
+	MaximumSpeed float64
+
+	// Annotate adds a comment describing the problem to every outlier.
+	Annotate bool
+
+	// ExcludeFromGeographicIndex removes every outlier from the geographic
+	// index.
+	ExcludeFromGeographicIndex bool
+}
+
+// NewOutlierDetector returns a detector that uses `DefaultMaximumSpeed` and
+// annotates outliers.
+func NewOutlierDetector() *OutlierDetector {
+	return &OutlierDetector{
+		MaximumSpeed: DefaultMaximumSpeed,
+		Annotate:     true,
+	}
+}
+
+// isImpossible returns true if going between the two records would require
+// an impossible speed. Timestamps only have a resolution of a second, so we
+// allow at least that much time.
+func (od *OutlierDetector) isImpossible(from, to *GeographicRecord) bool {
+	elapsed := to.Timestamp.Sub(from.Timestamp)
+	if elapsed < time.Second {
+		elapsed = time.Second
+	}
+
+	maximumSpeed := od.MaximumSpeed
+	if maximumSpeed == 0 {
+		maximumSpeed = DefaultMaximumSpeed
+	}
+
+	return recordDistanceMeters(from, to)/elapsed.Seconds() > maximumSpeed
+}
+
+// detectJumps returns the points in the track that are impossibly far from the
+// points around them.
+func (od *OutlierDetector) detectJumps(track []*GeographicRecord) (outliers []Outlier) {
+	outliers = make([]Outlier, 0)
+
+	// The first point has nothing before it, so it's an outlier if it's
+	// impossibly far from the next point but that one isn't from the one
+	// after it.
+	if len(track) >= 3 && od.isImpossible(track[0], track[1]) == true && od.isImpossible(track[1], track[2]) == false {
+		outliers = append(outliers, Outlier{Record: track[0], Reason: OutlierImpossibleSpeed})
+		track = track[1:]
+	}
+
+	// Any other point is an outlier if it's impossibly far from the last good
+	// point but the track then returns to somewhere that's possible.
+
+	var previous *GeographicRecord
+	for i, gr := range track {
+		if previous == nil || od.isImpossible(previous, gr) == false {
+			previous = gr
+			continue
+		}
+
+		returns := false
+		for j := i + 1; j < len(track) && j <= i+outlierLookahead; j++ {
+			if od.isImpossible(previous, track[j]) == false {
+				returns = true
+				break
+			}
+		}
+
+		if returns == true {
+			outliers = append(outliers, Outlier{Record: gr, Reason: OutlierImpossibleSpeed})
+		} else {
+			previous = gr
+		}
+	}
+
+	return outliers
+}
+
+// Detect returns the outliers in the index, in time order. The index is not
+// changed.
+func (od *OutlierDetector) Detect(ti *TimeIndex) (outliers []Outlier) {
+	outliers = make([]Outlier, 0)
+
+	// Each file's points are checked separately, in the order that the files
+	// were first seen so that the result is stable.
+	filepaths := make([]string, 0)
+	tracks := make(map[string][]*GeographicRecord)
+
+	for _, gr := range ti.records() {
+		if gr.HasGeographic == false {
+			continue
+		}
+
+		if coordinatesInRange(gr.Latitude, gr.Longitude) == false {
+			outliers = append(outliers, Outlier{Record: gr, Reason: OutlierOutOfRange})
+		} else if isNullIsland(gr.Latitude, gr.Longitude) == true {
+			outliers = append(outliers, Outlier{Record: gr, Reason: OutlierNullIsland})
+		} else if isTrackRecord(gr) == true {
+			if _, found := tracks[gr.Filepath]; found == false {
+				filepaths = append(filepaths, gr.Filepath)
+			}
+
+			tracks[gr.Filepath] = append(tracks[gr.Filepath], gr)
+		}
+	}
+
+	for _, filepath := range filepaths {
+		outliers = append(outliers, od.detectJumps(tracks[filepath])...)
+	}
+
+	sort.SliceStable(outliers, func(i, j int) bool {
+		return outliers[i].Record.Timestamp.Before(outliers[j].Record.Timestamp)
+	})
+
+	return outliers
+}
+
+// Apply detects the outliers in the time-index and, depending on the
+// configuration, annotates them and removes them from the geographic-index.
+// `gi` may be `nil`. Applying more than once will not add the same comment
+// twice.
+func (od *OutlierDetector) Apply(ti *TimeIndex, gi *GeographicIndex) (outliers []Outlier, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	outliers = od.Detect(ti)
+
+	for _, outlier := range outliers {
+		gr := outlier.Record
+
+		if od.Annotate == true {
+			comment := outlier.Comment()
+
+			found := false
+			for _, existing := range gr.comments {
+				if existing == comment {
+					found = true
+					break
+				}
+			}
+
+			if found == false {
+				gr.AddComment(comment)
+			}
+		}
+
+		if od.ExcludeFromGeographicIndex == true && gi != nil {
+			err := gi.Remove(gr)
+			if err != nil && err != ErrRecordNotFound {
+				log.Panic(err)
+			}
+		}
+	}
+
+	return outliers, nil
+}
@@ -0,0 +1,162 @@
+package geoindex
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dsoprea/go-logging"
+)
+
+func TestOutlierDetector_Apply(t *testing.T) {
+	ti := NewTimeIndex()
+	gi := NewGeographicIndex()
+
+	epoch := time.Date(2020, 6, 1, 8, 0, 0, 0, time.UTC)
+
+	// A track moving about 110 meters a minute with a spike and a real
+	// relocation (e.g. a flight with the logger turned off).
+	track := []*GeographicRecord{
+		NewGeographicRecord(SourceGeographicGpx, "data.gpx", epoch, true, 10.0, 20.0, nil),
+		NewGeographicRecord(SourceGeographicGpx, "data.gpx", epoch.Add(time.Minute), true, 10.001, 20.0, nil),
+		NewGeographicRecord(SourceGeographicGpx, "data.gpx", epoch.Add(time.Minute*2), true, 15.0, 25.0, nil),
+		NewGeographicRecord(SourceGeographicGpx, "data.gpx", epoch.Add(time.Minute*3), true, 10.002, 20.0, nil),
+		NewGeographicRecord(SourceGeographicGpx, "data.gpx", epoch.Add(time.Minute*4), true, 10.003, 20.0, nil),
+		NewGeographicRecord(SourceGeographicGpx, "data.gpx", epoch.Add(time.Minute*5), true, 40.0, -70.0, nil),
+		NewGeographicRecord(SourceGeographicGpx, "data.gpx", epoch.Add(time.Minute*6), true, 40.001, -70.0, nil),
+	}
+
+	nullIsland := NewGeographicRecord(SourceImageJpeg, "image.jpg", epoch.Add(time.Second*30), true, 0, 0, nil)
+
+	// Images are not checked for speed.
+	farImage := NewGeographicRecord(SourceImageJpeg, "image2.jpg", epoch.Add(time.Second*90), true, -30.0, 100.0, nil)
+
+	outOfRange := &GeographicRecord{
+		SourceName:    SourceImageJpeg,
+		Filepath:      "image3.jpg",
+		Timestamp:     epoch.Add(time.Second * 150),
+		HasGeographic: true,
+		Latitude:      123.456,
+		Longitude:     20.0,
+	}
+
+	records := append(track, nullIsland, farImage, outOfRange)
+
+	err := ti.AddRecords(records)
+	log.PanicIf(err)
+
+	for _, gr := range records[:len(records)-1] {
+		err := gi.AddWithRecord(gr)
+		log.PanicIf(err)
+	}
+
+	od := NewOutlierDetector()
+	od.ExcludeFromGeographicIndex = true
+
+	outliers, err := od.Apply(ti, gi)
+	log.PanicIf(err)
+
+	if len(outliers) != 3 {
+		t.Fatalf("Outlier count not correct: %v", outliers)
+	} else if outliers[0].Record != nullIsland || outliers[0].Reason != OutlierNullIsland {
+		t.Fatalf("First outlier not correct: %s", outliers[0])
+	} else if outliers[1].Record != track[2] || outliers[1].Reason != OutlierImpossibleSpeed {
+		t.Fatalf("Second outlier not correct: %s", outliers[1])
+	} else if outliers[2].Record != outOfRange || outliers[2].Reason != OutlierOutOfRange {
+		t.Fatalf("Third outlier not correct: %s", outliers[2])
+	}
+
+	comments := track[2].Comments()
+	if len(comments) != 1 || comments[0] != "outlier: impossible speed" {
+		t.Fatalf("Comments not correct: %v", comments)
+	}
+
+	if ti.RecordCount() != len(records) {
+		t.Fatalf("Time-index should not have changed: (%d)", ti.RecordCount())
+	} else if gi.RecordCount() != len(records)-3 {
+		t.Fatalf("Outliers not removed from geographic-index: (%d)", gi.RecordCount())
+	}
+
+	// Applying again doesn't add the comments again.
+
+	_, err = od.Apply(ti, gi)
+	log.PanicIf(err)
+
+	if len(track[2].Comments()) != 1 {
+		t.Fatalf("Comment added twice: %v", track[2].Comments())
+	}
+}
+
+func TestOutlierDetector_Detect_FirstPoint(t *testing.T) {
+	ti := NewTimeIndex()
+
+	epoch := time.Date(2020, 6, 1, 8, 0, 0, 0, time.UTC)
+
+	records := []*GeographicRecord{
+		NewGeographicRecord(SourceGeographicGpx, "data.gpx", epoch, true, 15.0, 25.0, nil),
+		NewGeographicRecord(SourceGeographicGpx, "data.gpx", epoch.Add(time.Second), true, 10.0, 20.0, nil),
+		NewGeographicRecord(SourceGeographicGpx, "data.gpx", epoch.Add(time.Second*2), true, 10.00001, 20.0, nil),
+	}
+
+	err := ti.AddRecords(records)
+	log.PanicIf(err)
+
+	outliers := NewOutlierDetector().Detect(ti)
+	if len(outliers) != 1 || outliers[0].Record != records[0] {
+		t.Fatalf("Outliers not correct: %v", outliers)
+	} else if len(records[0].Comments()) != 0 {
+		t.Fatalf("Detect should not annotate.")
+	}
+}
+
+func TestOutlierDetector_Detect_ZeroValue(t *testing.T) {
+	ti := NewTimeIndex()
+
+	epoch := time.Date(2020, 6, 1, 8, 0, 0, 0, time.UTC)
+
+	// Moving about 110 meters a minute and then stopping.
+	records := []*GeographicRecord{
+		NewGeographicRecord(SourceGeographicGpx, "data.gpx", epoch, true, 10.0, 20.0, nil),
+		NewGeographicRecord(SourceGeographicGpx, "data.gpx", epoch.Add(time.Minute), true, 10.001, 20.0, nil),
+		NewGeographicRecord(SourceGeographicGpx, "data.gpx", epoch.Add(time.Minute*2), true, 10.001, 20.0, nil),
+		NewGeographicRecord(SourceGeographicGpx, "data.gpx", epoch.Add(time.Minute*3), true, 10.001, 20.0, nil),
+	}
+
+	err := ti.AddRecords(records)
+	log.PanicIf(err)
+
+	od := new(OutlierDetector)
+
+	outliers := od.Detect(ti)
+	if len(outliers) != 0 {
+		t.Fatalf("Outliers not correct: %v", outliers)
+	}
+}
+
+func TestOutlierDetector_Detect_OverlappingFiles(t *testing.T) {
+	ti := NewTimeIndex()
+
+	epoch := time.Date(2020, 6, 1, 8, 0, 0, 0, time.UTC)
+
+	// Two devices logging at the same time in different cities. Neither
+	// track has a jump, but they would if they were chained together.
+	records := make([]*GeographicRecord, 0)
+	for i := 0; i < 4; i++ {
+		timestamp := epoch.Add(time.Minute * time.Duration(i))
+		latitude := 10.0 + float64(i)*0.001
+
+		records = append(records, NewGeographicRecord(SourceGeographicGpx, "a.gpx", timestamp, true, latitude, 20.0, nil))
+		records = append(records, NewGeographicRecord(SourceGeographicGpx, "b.gpx", timestamp.Add(time.Second*30), true, latitude, 40.0, nil))
+	}
+
+	// A spike in one of them.
+	spike := NewGeographicRecord(SourceGeographicGpx, "b.gpx", epoch.Add(time.Minute+time.Second*45), true, 15.0, 40.0, nil)
+	records = append(records, spike)
+
+	err := ti.AddRecords(records)
+	log.PanicIf(err)
+
+	outliers := NewOutlierDetector().Detect(ti)
+	if len(outliers) != 1 || outliers[0].Record != spike || outliers[0].Reason != OutlierImpossibleSpeed {
+		t.Fatalf("Outliers not correct: %v", outliers)
+	}
+}
@@ -0,0 +1,393 @@
+package geoindex
+
+import (
+	"io"
+	"time"
+
+	"encoding/gob"
+
+	"github.com/dsoprea/go-logging"
+)
+
+const (
+	// persistenceMagic identifies a stream written by this package.
+	persistenceMagic = "go-geographic-index"
+
+	// persistenceVersion is the version of the persistence format. It is
+	// incremented whenever the format changes incompatibly. Version 1 did not
+	// store the position of records within their files, which their IDs are
+	// derived from, so it can not be loaded.
+	persistenceVersion = 2
+)
+
+const (
+	persistedKindTimeIndex       = "time-index"
+	persistedKindGeographicIndex = "geographic-index"
+	persistedKindIndices         = "indices"
+)
+
+// persistenceHeader precedes all persisted data.
+type persistenceHeader struct {
+	Magic   string
+	Version int
+	Kind    string
+}
+
+// writePersistenceHeader writes a header for the given kind of data.
+func writePersistenceHeader(e *gob.Encoder, kind string) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	ph := persistenceHeader{
+		Magic:   persistenceMagic,
+		Version: persistenceVersion,
+		Kind:    kind,
+	}
+
+	err = e.Encode(ph)
+	log.PanicIf(err)
+
+	return nil
+}
+
+// readPersistenceHeader reads a header and verifies that it describes the
+// given kind of data in a version that we support.
+func readPersistenceHeader(d *gob.Decoder, kind string) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	ph := persistenceHeader{}
+
+	err = d.Decode(&ph)
+	log.PanicIf(err)
+
+	if ph.Magic != persistenceMagic {
+		log.Panicf("data was not written by this package")
+	} else if ph.Version != persistenceVersion {
+		log.Panicf("persistence version (%d) not supported", ph.Version)
+	} else if ph.Kind != kind {
+		log.Panicf("persisted data is [%s] and not [%s]", ph.Kind, kind)
+	}
+
+	return nil
+}
+
+// persistedRelationship is the serialized form of a
+// `GeographicRecordRelatedTo`. The related record is referred to by its
+// position in the list of persisted records.
+type persistedRelationship struct {
+	RecordIndex  int
+	Relationship string
+}
+
+// persistedRecord is the serialized form of a `GeographicRecord`. Unlike the
+// record itself, every field is exported so that it will survive gob.
+type persistedRecord struct {
+	Timestamp     time.Time
+	Filepath      string
+	HasGeographic bool
+	Latitude      float64
+	Longitude     float64
+	S2CellId      uint64
+	SourceName    string
+	Metadata      interface{}
+	Position      int
+	HasAltitude   bool
+	Altitude      float64
+	Comments      []string
+	RelatedTo     []persistedRelationship
+}
+
+// encodeRecord converts a single record to its serialized form. The caller
+// is responsible for encoding the relationships.
+func encodeRecord(gr *GeographicRecord, relatedTo []persistedRelationship) persistedRecord {
+	return persistedRecord{
+		Timestamp:     gr.Timestamp,
+		Filepath:      gr.Filepath,
+		HasGeographic: gr.HasGeographic,
+		Latitude:      gr.Latitude,
+		Longitude:     gr.Longitude,
+		S2CellId:      gr.S2CellId,
+		SourceName:    gr.SourceName,
+		Metadata:      gr.Metadata,
+		Position:      gr.Position,
+		HasAltitude:   gr.HasAltitude,
+		Altitude:      gr.Altitude,
+		Comments:      gr.comments,
+		RelatedTo:     relatedTo,
+	}
+}
+
+// decodeRecord converts a single serialized record back to a record. The
+// relationships are not restored.
+func decodeRecord(pr persistedRecord) *GeographicRecord {
+	comments := pr.Comments
+	if comments == nil {
+		comments = make([]string, 0)
+	}
+
+	return &GeographicRecord{
+		Timestamp:     pr.Timestamp,
+		Filepath:      pr.Filepath,
+		HasGeographic: pr.HasGeographic,
+		Latitude:      pr.Latitude,
+		Longitude:     pr.Longitude,
+		S2CellId:      pr.S2CellId,
+		SourceName:    pr.SourceName,
+		Metadata:      pr.Metadata,
+		Position:      pr.Position,
+		HasAltitude:   pr.HasAltitude,
+		Altitude:      pr.Altitude,
+		comments:      comments,
+		relatedTo:     make([]GeographicRecordRelatedTo, 0),
+	}
+}
+
+// encodeRecords converts the given records to their serialized form. Any
+// related records that are not in the list are appended so that every
+// relationship can be restored. The identity of records is preserved: a
+// record that appears more than once is only encoded once.
+func encodeRecords(records []*GeographicRecord) (encoded []persistedRecord) {
+	encoded, _ = encodeRecordsWithPositions(records)
+	return encoded
+}
+
+// encodeRecordsWithPositions is `encodeRecords` but also returns the position
+// of each of the given records in the encoded list.
+func encodeRecordsWithPositions(records []*GeographicRecord) (encoded []persistedRecord, recordPositions []int) {
+	return encodeNewRecords(records, make(map[*GeographicRecord]int))
+}
+
+// encodeNewRecords is `encodeRecordsWithPositions` but continues a list that
+// was already partially encoded. `positions` has the position of every record
+// that was already encoded, which will not be encoded again, and is updated
+// with the positions of the new ones. The new records follow the existing
+// ones.
+func encodeNewRecords(records []*GeographicRecord, positions map[*GeographicRecord]int) (encoded []persistedRecord, recordPositions []int) {
+	offset := len(positions)
+	ordered := make([]*GeographicRecord, 0, len(records))
+
+	var add func(gr *GeographicRecord) int
+	add = func(gr *GeographicRecord) int {
+		if i, found := positions[gr]; found == true {
+			return i
+		}
+
+		i := offset + len(ordered)
+		positions[gr] = i
+		ordered = append(ordered, gr)
+
+		return i
+	}
+
+	recordPositions = make([]int, len(records))
+	for i, gr := range records {
+		recordPositions[i] = add(gr)
+	}
+
+	encoded = make([]persistedRecord, 0, len(ordered))
+
+	// `ordered` may grow as we discover related records.
+	for i := 0; i < len(ordered); i++ {
+		gr := ordered[i]
+
+		relatedTo := make([]persistedRelationship, len(gr.relatedTo))
+		for j, grrt := range gr.relatedTo {
+			relatedTo[j] = persistedRelationship{
+				RecordIndex:  add(grrt.GeographicRecord),
+				Relationship: grrt.Relationship,
+			}
+		}
+
+		encoded = append(encoded, encodeRecord(gr, relatedTo))
+	}
+
+	return encoded, recordPositions
+}
+
+// decodeRecords converts serialized records back to records. The returned
+// list is parallel to the given one.
+func decodeRecords(encoded []persistedRecord) (records []*GeographicRecord, err error) {
+	return appendDecodedRecords(make([]*GeographicRecord, 0, len(encoded)), encoded)
+}
+
+// appendDecodedRecords converts serialized records back to records and
+// appends them to `records`, which are the records that were already decoded
+// from the same list (see `encodeNewRecords`). Relationships may refer to any
+// of them.
+func appendDecodedRecords(records []*GeographicRecord, encoded []persistedRecord) (extended []*GeographicRecord, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	offset := len(records)
+
+	for _, pr := range encoded {
+		gr := decodeRecord(pr)
+		gr.relatedTo = make([]GeographicRecordRelatedTo, len(pr.RelatedTo))
+
+		records = append(records, gr)
+	}
+
+	for k, pr := range encoded {
+		i := offset + k
+
+		for j, prr := range pr.RelatedTo {
+			if prr.RecordIndex < 0 || prr.RecordIndex >= len(records) {
+				log.Panicf("relationship refers to invalid record: (%d)", prr.RecordIndex)
+			}
+
+			records[i].relatedTo[j] = GeographicRecordRelatedTo{
+				GeographicRecord: records[prr.RecordIndex],
+				Relationship:     prr.Relationship,
+			}
+		}
+	}
+
+	return records, nil
+}
+
+// persistedIndices is the serialized form of a `TimeIndex` and a
+// `GeographicIndex` that share records.
+type persistedIndices struct {
+	Records []persistedRecord
+
+	HasTimeIndex bool
+	TimeItems    []int
+
+	HasGeographicIndex    bool
+	GeographicBuckets     map[uint64][]int
+	GeographicRecordCount int
+}
+
+// encodeIndices converts both indices to their serialized form. Either index
+// may be `nil`. Also returns the position of every indexed record in the
+// encoded records.
+func encodeIndices(ti *TimeIndex, gi *GeographicIndex) (pi persistedIndices, positions map[*GeographicRecord]int) {
+	records := make([]*GeographicRecord, 0)
+
+	if ti != nil {
+		records = append(records, ti.records()...)
+	}
+
+	tiCount := len(records)
+
+	if gi != nil {
+		records = append(records, gi.records()...)
+	}
+
+	encoded, recordPositions := encodeRecordsWithPositions(records)
+
+	positions = make(map[*GeographicRecord]int, len(records))
+	for i, gr := range records {
+		positions[gr] = recordPositions[i]
+	}
+
+	pi = persistedIndices{
+		Records: encoded,
+	}
+
+	if ti != nil {
+		pi.HasTimeIndex = true
+		pi.TimeItems = recordPositions[:tiCount]
+	}
+
+	if gi != nil {
+		pi.HasGeographicIndex = true
+		pi.GeographicBuckets = gi.encodeBuckets(positions)
+		pi.GeographicRecordCount = gi.recordCount
+	}
+
+	return pi, positions
+}
+
+// decodeIndices converts serialized indices back to indices. An index that
+// was not encoded will be returned as `nil`. Also returns the decoded records,
+// parallel to the encoded ones.
+func decodeIndices(pi persistedIndices) (ti *TimeIndex, gi *GeographicIndex, records []*GeographicRecord, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	records, err = decodeRecords(pi.Records)
+	log.PanicIf(err)
+
+	if pi.HasTimeIndex == true {
+		ts, err := timeSliceFromPositions(records, pi.TimeItems)
+		log.PanicIf(err)
+
+		ti = NewTimeIndexFromSlice(ts)
+	}
+
+	if pi.HasGeographicIndex == true {
+		gi = NewGeographicIndex()
+
+		err := gi.restoreBuckets(records, pi.GeographicBuckets, pi.GeographicRecordCount)
+		log.PanicIf(err)
+	}
+
+	return ti, gi, records, nil
+}
+
+// SaveIndices writes both indices to the given stream. Records that are in
+// both indices are only stored once and, after `LoadIndices`, both indices
+// will refer to the same record objects. Either index may be `nil`.
+func SaveIndices(w io.Writer, ti *TimeIndex, gi *GeographicIndex) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	pi, _ := encodeIndices(ti, gi)
+
+	e := gob.NewEncoder(w)
+
+	err = writePersistenceHeader(e, persistedKindIndices)
+	log.PanicIf(err)
+
+	err = e.Encode(pi)
+	log.PanicIf(err)
+
+	return nil
+}
+
+// LoadIndices reads indices previously written by `SaveIndices`. An index that
+// was not saved will be returned as `nil`.
+func LoadIndices(r io.Reader) (ti *TimeIndex, gi *GeographicIndex, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	d := gob.NewDecoder(r)
+
+	err = readPersistenceHeader(d, persistedKindIndices)
+	log.PanicIf(err)
+
+	pi := persistedIndices{}
+
+	err = d.Decode(&pi)
+	log.PanicIf(err)
+
+	ti, gi, _, err = decodeIndices(pi)
+	log.PanicIf(err)
+
+	return ti, gi, nil
+}
+
+func init() {
+	// This is the default metadata for records created without any.
+	gob.Register(map[string]interface{}{})
+}
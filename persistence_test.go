@@ -0,0 +1,181 @@
+package geoindex
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"encoding/gob"
+
+	"github.com/dsoprea/go-logging"
+)
+
+func TestEncodeRecords_DecodeRecords(t *testing.T) {
+	now := time.Now().UTC()
+
+	gr1 := NewGeographicRecord(SourceImageJpeg, "image.jpg", now, true, 12.34, 34.56, ImageMetadata{CameraModel: "some camera"})
+	gr1.AddComment("comment1")
+
+	gr2 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.34, 34.56, nil)
+	gr1.AddRelated(gr2, "nearest")
+
+	// Only `gr1` is given. `gr2` should be included because it's related.
+	encoded := encodeRecords([]*GeographicRecord{gr1, gr1})
+
+	if len(encoded) != 2 {
+		t.Fatalf("Expected two encoded records: (%d)", len(encoded))
+	}
+
+	b := new(bytes.Buffer)
+
+	err := gob.NewEncoder(b).Encode(encoded)
+	log.PanicIf(err)
+
+	recovered := make([]persistedRecord, 0)
+
+	err = gob.NewDecoder(b).Decode(&recovered)
+	log.PanicIf(err)
+
+	records, err := decodeRecords(recovered)
+	log.PanicIf(err)
+
+	if records[0].Equal(gr1) != true {
+		t.Fatalf("First record not correct: %s", records[0])
+	} else if records[1].Equal(gr2) != true {
+		t.Fatalf("Second record not correct: %s", records[1])
+	}
+
+	related := records[0].Relationships()["nearest"]
+	if len(related) != 1 || related[0] != records[1] {
+		t.Fatalf("Relationship not restored: %v", related)
+	}
+}
+
+func TestSaveIndices_LoadIndices(t *testing.T) {
+	ti := NewTimeIndex()
+	gi := NewGeographicIndex()
+
+	now := time.Now().UTC()
+
+	gr1 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.34, 34.56, nil)
+	gr2 := NewGeographicRecord(SourceImageJpeg, "image.jpg", now.Add(time.Minute), false, 0, 0, ImageMetadata{CameraModel: "some camera"})
+
+	for _, gr := range []*GeographicRecord{gr1, gr2} {
+		err := ti.AddWithRecord(gr)
+		log.PanicIf(err)
+
+		if gr.HasGeographic == true {
+			err := gi.AddWithRecord(gr)
+			log.PanicIf(err)
+		}
+	}
+
+	b := new(bytes.Buffer)
+
+	err := SaveIndices(b, ti, gi)
+	log.PanicIf(err)
+
+	recoveredTi, recoveredGi, err := LoadIndices(b)
+	log.PanicIf(err)
+
+	if recoveredTi.RecordCount() != 2 {
+		t.Fatalf("Time-index record count not correct: (%d)", recoveredTi.RecordCount())
+	} else if recoveredGi.RecordCount() != 1 {
+		t.Fatalf("Geographic-index record count not correct: (%d)", recoveredGi.RecordCount())
+	}
+
+	results, err := recoveredGi.GetWithCoordinatesMetroLimited(12.34, 34.56)
+	log.PanicIf(err)
+
+	timeGr := recoveredTi.Series()[0].Items[0].(*GeographicRecord)
+	if results[0] != timeGr {
+		t.Fatalf("Indices do not share the same record.")
+	} else if timeGr.Equal(gr1) != true {
+		t.Fatalf("Record not correct: %s", timeGr)
+	}
+}
+
+func TestSaveIndices_LoadIndices_TimeIndexOnly(t *testing.T) {
+	ti := NewTimeIndex()
+
+	gr := NewGeographicRecord(SourceGeographicGpx, "data.gpx", time.Now(), true, 12.34, 34.56, nil)
+
+	err := ti.AddWithRecord(gr)
+	log.PanicIf(err)
+
+	b := new(bytes.Buffer)
+
+	err = SaveIndices(b, ti, nil)
+	log.PanicIf(err)
+
+	recoveredTi, recoveredGi, err := LoadIndices(b)
+	log.PanicIf(err)
+
+	if recoveredTi.RecordCount() != 1 {
+		t.Fatalf("Time-index record count not correct: (%d)", recoveredTi.RecordCount())
+	} else if recoveredGi != nil {
+		t.Fatalf("Geographic index should not have been loaded.")
+	}
+}
+
+func TestSaveIndices_LoadIndices_GetById(t *testing.T) {
+	ti := NewTimeIndex()
+	gi := NewGeographicIndex()
+
+	now := time.Now().UTC()
+
+	gr1 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.34, 34.56, nil)
+	gr1.Position = 5
+
+	gr2 := NewGeographicRecord(SourceImageJpeg, "image.jpg", now.Add(time.Minute), false, 0, 0, nil)
+
+	for _, gr := range []*GeographicRecord{gr1, gr2} {
+		err := ti.AddWithRecord(gr)
+		log.PanicIf(err)
+
+		if gr.HasGeographic == true {
+			err := gi.AddWithRecord(gr)
+			log.PanicIf(err)
+		}
+	}
+
+	b := new(bytes.Buffer)
+
+	err := SaveIndices(b, ti, gi)
+	log.PanicIf(err)
+
+	recoveredTi, recoveredGi, err := LoadIndices(b)
+	log.PanicIf(err)
+
+	timeGr, err := recoveredTi.GetById(gr1.Id())
+	log.PanicIf(err)
+
+	geographicGr, err := recoveredGi.GetById(gr1.Id())
+	log.PanicIf(err)
+
+	if timeGr != geographicGr {
+		t.Fatalf("Indices do not return the same record.")
+	} else if timeGr.Id() != gr1.Id() || timeGr.Position != 5 {
+		t.Fatalf("Record not correct: %s", timeGr)
+	}
+
+	timeGr, err = recoveredTi.GetById(gr2.Id())
+	log.PanicIf(err)
+
+	if timeGr.Filepath != "image.jpg" {
+		t.Fatalf("Record not correct: %s", timeGr)
+	}
+
+	_, err = recoveredGi.GetById(gr2.Id())
+	if err != ErrRecordNotFound {
+		t.Fatalf("Expected record without geographic data to not be found: %v", err)
+	}
+
+	err = recoveredTi.Remove(timeGr)
+	log.PanicIf(err)
+
+	_, err = recoveredTi.GetById(gr2.Id())
+	if err != ErrRecordNotFound {
+		t.Fatalf("Expected removed record to not be found: %v", err)
+	}
+}
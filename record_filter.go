@@ -0,0 +1,93 @@
+package geoindex
+
+import (
+	"time"
+)
+
+// RecordFilter selects the records to be exported. The zero value selects
+// every record.
+type RecordFilter struct {
+	// From (inclusive) and To (exclusive) limit the records to a time range.
+	// Either may be zero to leave that end open.
+	From time.Time
+	To   time.Time
+
+	// SourceNames limits the records to the given sources (e.g.
+	// `SourceImageJpeg` or `SourceGeographicGpx`) if not empty.
+	SourceNames []string
+
+	// BoundingBox limits the records to those with geographic data inside the
+	// box if not nil.
+	BoundingBox *BoundingBox
+
+	// Predicate, if not nil, is called for every record that satisfies the
+	// other criteria.
+	Predicate RecordPredicate
+}
+
+// Includes returns true if the record satisfies the filter.
+func (rf RecordFilter) Includes(gr *GeographicRecord) bool {
+	if rf.From.IsZero() == false && gr.Timestamp.Before(rf.From) == true {
+		return false
+	} else if rf.To.IsZero() == false && gr.Timestamp.Before(rf.To) == false {
+		return false
+	}
+
+	if len(rf.SourceNames) > 0 {
+		found := false
+		for _, sourceName := range rf.SourceNames {
+			if sourceName == gr.SourceName {
+				found = true
+				break
+			}
+		}
+
+		if found == false {
+			return false
+		}
+	}
+
+	if rf.BoundingBox != nil {
+		if gr.HasGeographic == false || rf.BoundingBox.Contains(gr.Latitude, gr.Longitude) == false {
+			return false
+		}
+	}
+
+	if rf.Predicate != nil && rf.Predicate(gr) == false {
+		return false
+	}
+
+	return true
+}
+
+// filteredRecords returns the records that satisfy the filter in time order.
+// Only the part of the series within the time range is visited.
+func (index *TimeIndex) filteredRecords(filter RecordFilter) []*GeographicRecord {
+	i := 0
+	if filter.From.IsZero() == false {
+		i = index.ts.Search(filter.From)
+	}
+
+	j := len(index.ts)
+	if filter.To.IsZero() == false {
+		j = index.ts.Search(filter.To)
+	}
+
+	records := make([]*GeographicRecord, 0)
+
+	if j <= i {
+		return records
+	}
+
+	for _, timeItem := range index.ts[i:j] {
+		for _, item := range timeItem.Items {
+			gr := item.(*GeographicRecord)
+
+			if filter.Includes(gr) == true {
+				records = append(records, gr)
+			}
+		}
+	}
+
+	return records
+}
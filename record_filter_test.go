@@ -0,0 +1,96 @@
+package geoindex
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dsoprea/go-logging"
+)
+
+func filterTestRecords() (records []*GeographicRecord) {
+	epoch := time.Date(2020, 6, 1, 8, 0, 0, 0, time.UTC)
+
+	records = []*GeographicRecord{
+		NewGeographicRecord(SourceGeographicGpx, "ride.gpx", epoch, true, 10.0, 20.0, nil),
+		NewGeographicRecord(SourceImageJpeg, "image1.jpg", epoch.Add(time.Hour*24), true, 10.5, 20.5, nil),
+		NewGeographicRecord(SourceGeographicGpx, "ride.gpx", epoch.Add(time.Hour*48), true, 30.0, 40.0, nil),
+		NewGeographicRecord(SourceImageJpeg, "image2.jpg", epoch.Add(time.Hour*72), false, 0, 0, nil),
+	}
+
+	return records
+}
+
+func TestRecordFilter_Includes(t *testing.T) {
+	records := filterTestRecords()
+	ti := getTestIndex(records)
+
+	epoch := records[0].Timestamp
+
+	bb := &BoundingBox{
+		MinLatitude:  9.0,
+		MinLongitude: 19.0,
+		MaxLatitude:  11.0,
+		MaxLongitude: 21.0,
+	}
+
+	cases := []struct {
+		filter   RecordFilter
+		expected []*GeographicRecord
+	}{
+		{RecordFilter{}, records},
+		{RecordFilter{From: epoch.Add(time.Hour * 24)}, records[1:]},
+		{RecordFilter{To: epoch.Add(time.Hour * 48)}, records[:2]},
+		{RecordFilter{From: epoch.Add(time.Hour), To: epoch.Add(time.Hour * 49)}, records[1:3]},
+		{RecordFilter{From: epoch.Add(time.Hour * 49), To: epoch.Add(time.Hour)}, []*GeographicRecord{}},
+		{RecordFilter{SourceNames: []string{SourceImageJpeg}}, []*GeographicRecord{records[1], records[3]}},
+		{RecordFilter{BoundingBox: bb}, records[:2]},
+		{RecordFilter{Predicate: func(gr *GeographicRecord) bool { return gr.Filepath == "ride.gpx" }}, []*GeographicRecord{records[0], records[2]}},
+		{RecordFilter{SourceNames: []string{SourceGeographicGpx}, BoundingBox: bb}, records[:1]},
+	}
+
+	for i, c := range cases {
+		actual := ti.filteredRecords(c.filter)
+
+		if len(actual) != len(c.expected) {
+			t.Fatalf("Case (%d): record count not correct: (%d) != (%d)", i, len(actual), len(c.expected))
+		}
+
+		for j, gr := range actual {
+			if gr != c.expected[j] {
+				t.Fatalf("Case (%d): record (%d) not correct: %s", i, j, gr)
+			}
+
+			if c.filter.Includes(gr) != true {
+				t.Fatalf("Case (%d): record (%d) not included by filter.", i, j)
+			}
+		}
+	}
+}
+
+func TestTimeIndex_ExportGpxWithOptions_Filter(t *testing.T) {
+	records := filterTestRecords()
+	ti := getTestIndex(records)
+
+	options := GpxExportOptions{
+		Filter: RecordFilter{
+			From:        records[0].Timestamp,
+			To:          records[2].Timestamp,
+			SourceNames: []string{SourceGeographicGpx},
+		},
+	}
+
+	buffer := new(bytes.Buffer)
+
+	err := ti.ExportGpxWithOptions(buffer, options)
+	log.PanicIf(err)
+
+	output := buffer.String()
+
+	if count := strings.Count(output, "<trkpt "); count != 1 {
+		t.Fatalf("Point count not correct: (%d)\n%s", count, output)
+	} else if strings.Contains(output, `<trkpt lat="10" lon="20">`) != true {
+		t.Fatalf("Wrong point exported:\n%s", output)
+	}
+}
@@ -0,0 +1,25 @@
+package geoindex
+
+import (
+	"fmt"
+
+	"crypto/sha1"
+	"encoding/hex"
+)
+
+const (
+	// recordIdLength is the number of bytes of the hash that we use for a
+	// record ID.
+	recordIdLength = 16
+)
+
+// Id returns an ID for the record that is derived from its source, file-path,
+// timestamp, and position within the file. Unlike the identity of the record,
+// this is the same after the record has been serialized, persisted, or
+// copied, and will be the same if the file is processed again.
+func (gr *GeographicRecord) Id() string {
+	phrase := fmt.Sprintf("%s\x00%s\x00%d\x00%d", gr.SourceName, gr.Filepath, gr.Timestamp.UnixNano(), gr.Position)
+	sum := sha1.Sum([]byte(phrase))
+
+	return hex.EncodeToString(sum[:recordIdLength])
+}
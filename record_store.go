@@ -0,0 +1,653 @@
+package geoindex
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path"
+
+	"encoding/gob"
+	"io/ioutil"
+
+	"github.com/dsoprea/go-logging"
+)
+
+const (
+	// DefaultCompactionThreshold is the default number of log entries after
+	// which the log is compacted into a new snapshot.
+	DefaultCompactionThreshold = 10000
+
+	recordStoreSnapshotFilename = "snapshot"
+	recordStoreLogFilename      = "log"
+)
+
+const (
+	persistedKindRecordStoreSnapshot = "record-store-snapshot"
+)
+
+var (
+	storeLogger = log.NewLogger("geoindex.record_store")
+)
+
+var (
+	// ErrRecordStoreFailed is returned for every change after a write to the
+	// log failed in a way that could not be undone.
+	ErrRecordStoreFailed = errors.New("record store failed")
+)
+
+type recordLogOperation int
+
+const (
+	recordLogAdd recordLogOperation = iota
+	recordLogRemove
+)
+
+// recordLogRelationship is a relationship to another record in the store.
+type recordLogRelationship struct {
+	RecordId     uint64
+	Relationship string
+}
+
+// recordLogEntry is a single change in the log.
+type recordLogEntry struct {
+	// Version is the persistence version that the entry was written with.
+	// Entries written before this was added will have zero.
+	Version int
+
+	Sequence  uint64
+	Operation recordLogOperation
+	RecordId  uint64
+
+	// Record and RelatedTo are only set for additions.
+	Record    persistedRecord
+	RelatedTo []recordLogRelationship
+}
+
+// recordStoreSnapshot is the compacted state of the store. `RecordIds` is
+// parallel to the records in `Indices` and is zero for records that are only
+// referred to by relationships.
+type recordStoreSnapshot struct {
+	Indices      persistedIndices
+	RecordIds    []uint64
+	NextRecordId uint64
+	LastSequence uint64
+}
+
+// RecordStore is a durable `TimeIndex` and `GeographicIndex`. Every change is
+// appended to a log before it is applied and the log is periodically compacted
+// into a snapshot. When opened, the snapshot is loaded and the log is replayed
+// over it. A partially-written entry at the end of the log (e.g. from a crash)
+// is detected and discarded.
+//
+// Relationships are captured when a record is added and only to records that
+// are already in the store. Relationships and comments added afterward are
+// only persisted by the next compaction.
+type RecordStore struct {
+	rootPath string
+
+	ti *TimeIndex
+	gi *GeographicIndex
+
+	recordIds map[*GeographicRecord]uint64
+	records   map[uint64]*GeographicRecord
+
+	nextRecordId uint64
+	lastSequence uint64
+
+	f          *os.File
+	logSize    int64
+	logEntries int
+
+	// failed is set if the log could not be restored after a failed write.
+	failed bool
+
+	compactionThreshold int
+	sync                bool
+}
+
+// OpenRecordStore opens the store in the given directory, creating it if
+// necessary.
+func OpenRecordStore(rootPath string) (rs *RecordStore, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	err = os.MkdirAll(rootPath, 0755)
+	log.PanicIf(err)
+
+	rs = &RecordStore{
+		rootPath:            rootPath,
+		ti:                  NewTimeIndex(),
+		gi:                  NewGeographicIndex(),
+		recordIds:           make(map[*GeographicRecord]uint64),
+		records:             make(map[uint64]*GeographicRecord),
+		nextRecordId:        1,
+		compactionThreshold: DefaultCompactionThreshold,
+		sync:                true,
+	}
+
+	err = rs.loadSnapshot()
+	log.PanicIf(err)
+
+	err = rs.replayLog()
+	log.PanicIf(err)
+
+	return rs, nil
+}
+
+// TimeIndex returns the time index. It should not be modified directly.
+func (rs *RecordStore) TimeIndex() *TimeIndex {
+	return rs.ti
+}
+
+// GeographicIndex returns the geographic index. It should not be modified
+// directly.
+func (rs *RecordStore) GeographicIndex() *GeographicIndex {
+	return rs.gi
+}
+
+// SetCompactionThreshold sets the number of log entries after which the log
+// will be compacted. Zero disables automatic compaction.
+func (rs *RecordStore) SetCompactionThreshold(threshold int) {
+	rs.compactionThreshold = threshold
+}
+
+// SetSync determines whether the log is flushed to disk after every change.
+// This is the default. Disabling it is faster but recent changes may be lost
+// in a crash.
+func (rs *RecordStore) SetSync(sync bool) {
+	rs.sync = sync
+}
+
+func (rs *RecordStore) snapshotFilepath() string {
+	return path.Join(rs.rootPath, recordStoreSnapshotFilename)
+}
+
+func (rs *RecordStore) logFilepath() string {
+	return path.Join(rs.rootPath, recordStoreLogFilename)
+}
+
+// loadSnapshot loads the snapshot, if there is one.
+func (rs *RecordStore) loadSnapshot() (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	f, err := os.Open(rs.snapshotFilepath())
+	if err != nil {
+		if os.IsNotExist(err) == true {
+			return nil
+		}
+
+		log.Panic(err)
+	}
+
+	defer f.Close()
+
+	d := gob.NewDecoder(f)
+
+	err = readPersistenceHeader(d, persistedKindRecordStoreSnapshot)
+	log.PanicIf(err)
+
+	rss := recordStoreSnapshot{}
+
+	err = d.Decode(&rss)
+	log.PanicIf(err)
+
+	ti, gi, records, err := decodeIndices(rss.Indices)
+	log.PanicIf(err)
+
+	if len(rss.RecordIds) != len(records) {
+		log.Panicf("snapshot record IDs do not match records: (%d) != (%d)", len(rss.RecordIds), len(records))
+	}
+
+	for i, recordId := range rss.RecordIds {
+		if recordId == 0 {
+			continue
+		}
+
+		gr := records[i]
+
+		rs.recordIds[gr] = recordId
+		rs.records[recordId] = gr
+	}
+
+	rs.ti = ti
+	rs.gi = gi
+	rs.nextRecordId = rss.NextRecordId
+	rs.lastSequence = rss.LastSequence
+
+	return nil
+}
+
+// readLogEntry reads the next entry from the log. `ok` will be false if there
+// are no more complete and valid entries. `remaining` is the number of bytes
+// left in the log and protects us from a torn length.
+func readLogEntry(r io.Reader, remaining int64) (rle recordLogEntry, size int, ok bool, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	payload, ok, err := readFrame(r, remaining)
+	log.PanicIf(err)
+
+	if ok == false {
+		return rle, 0, false, nil
+	}
+
+	d := gob.NewDecoder(bytes.NewReader(payload))
+
+	err = d.Decode(&rle)
+	if err != nil {
+		return rle, 0, false, nil
+	}
+
+	if rle.Version != persistenceVersion {
+		log.Panicf("log entry persistence version (%d) not supported", rle.Version)
+	}
+
+	return rle, frameHeaderSize + len(payload), true, nil
+}
+
+// replayLog applies every entry in the log that isn't already reflected in
+// the snapshot and leaves the log open for appending. Anything after the last
+// valid entry is truncated.
+func (rs *RecordStore) replayLog() (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	f, err := os.OpenFile(rs.logFilepath(), os.O_RDWR|os.O_CREATE, 0644)
+	log.PanicIf(err)
+
+	rs.f = f
+
+	fi, err := f.Stat()
+	log.PanicIf(err)
+
+	offset := int64(0)
+
+	for {
+		rle, size, ok, err := readLogEntry(f, fi.Size()-offset)
+		log.PanicIf(err)
+
+		if ok == false {
+			break
+		}
+
+		offset += int64(size)
+		rs.logEntries++
+
+		// The log may not have been truncated after the last compaction.
+		if rle.Sequence <= rs.lastSequence {
+			continue
+		}
+
+		err = rs.apply(rle)
+		log.PanicIf(err)
+
+		rs.lastSequence = rle.Sequence
+	}
+
+	if fi.Size() > offset {
+		storeLogger.Warningf(nil, "Discarding (%d) bytes of incomplete or corrupt data at the end of the log: [%s]", fi.Size()-offset, rs.logFilepath())
+
+		err := f.Truncate(offset)
+		log.PanicIf(err)
+
+		err = f.Sync()
+		log.PanicIf(err)
+	}
+
+	rs.logSize = offset
+
+	return nil
+}
+
+// apply applies a log entry to the indices.
+func (rs *RecordStore) apply(rle recordLogEntry) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	switch rle.Operation {
+	case recordLogAdd:
+		gr := decodeRecord(rle.Record)
+
+		for _, rlr := range rle.RelatedTo {
+			relatedGr, found := rs.records[rlr.RecordId]
+			if found == false {
+				storeLogger.Warningf(nil, "Record (%d) is related to unknown record (%d).", rle.RecordId, rlr.RecordId)
+				continue
+			}
+
+			gr.AddRelated(relatedGr, rlr.Relationship)
+		}
+
+		err := rs.addToIndices(gr)
+		log.PanicIf(err)
+
+		rs.recordIds[gr] = rle.RecordId
+		rs.records[rle.RecordId] = gr
+
+		if rle.RecordId >= rs.nextRecordId {
+			rs.nextRecordId = rle.RecordId + 1
+		}
+
+	case recordLogRemove:
+		gr, found := rs.records[rle.RecordId]
+		if found == false {
+			storeLogger.Warningf(nil, "Log removes unknown record (%d).", rle.RecordId)
+			return nil
+		}
+
+		err := rs.removeFromIndices(gr)
+		log.PanicIf(err)
+
+		delete(rs.recordIds, gr)
+		delete(rs.records, rle.RecordId)
+
+	default:
+		log.Panicf("log operation (%d) not valid", rle.Operation)
+	}
+
+	return nil
+}
+
+// addToIndices adds the record to both indices or, if that fails, to neither.
+func (rs *RecordStore) addToIndices(gr *GeographicRecord) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	err = rs.ti.AddWithRecord(gr)
+	log.PanicIf(err)
+
+	if gr.HasGeographic == true {
+		err := rs.gi.AddWithRecord(gr)
+		if err != nil {
+			removeErr := rs.ti.Remove(gr)
+			if removeErr != nil && removeErr != ErrRecordNotFound {
+				storeLogger.Errorf(nil, removeErr, "Could not remove record from time index after geographic index failed: %s", gr)
+			}
+
+			log.Panic(err)
+		}
+	}
+
+	return nil
+}
+
+func (rs *RecordStore) removeFromIndices(gr *GeographicRecord) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	err = rs.ti.Remove(gr)
+	log.PanicIf(err)
+
+	if gr.HasGeographic == true {
+		err := rs.gi.Remove(gr)
+		log.PanicIf(err)
+	}
+
+	return nil
+}
+
+// appendLogEntry durably writes an entry to the log. If the write fails, the
+// log is truncated back to where it was so that the entries written after it
+// are not lost on replay. If even that fails, the store refuses any further
+// changes.
+func (rs *RecordStore) appendLogEntry(rle recordLogEntry) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	if rs.failed == true {
+		log.Panic(ErrRecordStoreFailed)
+	}
+
+	rle.Version = persistenceVersion
+
+	payload := new(bytes.Buffer)
+
+	e := gob.NewEncoder(payload)
+
+	err = e.Encode(rle)
+	log.PanicIf(err)
+
+	logSize, rolledBack, err := appendFrame(rs.f, rs.logSize, payload.Bytes(), rs.sync)
+	if err != nil {
+		if rolledBack == false {
+			rs.failed = true
+			storeLogger.Errorf(nil, err, "Could not truncate the log after a failed write. No further changes will be accepted: [%s]", rs.logFilepath())
+		}
+
+		log.Panic(err)
+	}
+
+	rs.logSize = logSize
+	rs.logEntries++
+
+	return nil
+}
+
+// AddWithRecord durably adds a record to both indices. Only records with
+// geographic data are added to the geographic index.
+func (rs *RecordStore) AddWithRecord(gr *GeographicRecord) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	if _, found := rs.recordIds[gr]; found == true {
+		log.Panicf("record already in store: %s", gr)
+	}
+
+	relatedTo := make([]recordLogRelationship, 0, len(gr.relatedTo))
+	for _, grrt := range gr.relatedTo {
+		relatedId, found := rs.recordIds[grrt.GeographicRecord]
+		if found == false {
+			storeLogger.Warningf(nil, "Not persisting relationship to a record that is not in the store: %s => %s", gr, grrt.GeographicRecord)
+			continue
+		}
+
+		rlr := recordLogRelationship{
+			RecordId:     relatedId,
+			Relationship: grrt.Relationship,
+		}
+
+		relatedTo = append(relatedTo, rlr)
+	}
+
+	// Make sure that the indices will accept the record before we log it.
+	// Otherwise the log would have an addition that the indices never saw.
+	if gr.HasGeographic == true {
+		err := rs.gi.validateRecord(gr)
+		log.PanicIf(err)
+	}
+
+	recordId := rs.nextRecordId
+
+	rle := recordLogEntry{
+		Sequence:  rs.lastSequence + 1,
+		Operation: recordLogAdd,
+		RecordId:  recordId,
+		Record:    encodeRecord(gr, nil),
+		RelatedTo: relatedTo,
+	}
+
+	err = rs.appendLogEntry(rle)
+	log.PanicIf(err)
+
+	err = rs.addToIndices(gr)
+	log.PanicIf(err)
+
+	rs.recordIds[gr] = recordId
+	rs.records[recordId] = gr
+	rs.nextRecordId++
+	rs.lastSequence = rle.Sequence
+
+	rs.compactIfDue()
+
+	return nil
+}
+
+// Remove durably removes a record from both indices. Returns
+// `ErrRecordNotFound` if the record is not in the store.
+func (rs *RecordStore) Remove(gr *GeographicRecord) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	recordId, found := rs.recordIds[gr]
+	if found == false {
+		return ErrRecordNotFound
+	}
+
+	rle := recordLogEntry{
+		Sequence:  rs.lastSequence + 1,
+		Operation: recordLogRemove,
+		RecordId:  recordId,
+	}
+
+	err = rs.appendLogEntry(rle)
+	log.PanicIf(err)
+
+	err = rs.removeFromIndices(gr)
+	log.PanicIf(err)
+
+	delete(rs.recordIds, gr)
+	delete(rs.records, recordId)
+	rs.lastSequence = rle.Sequence
+
+	rs.compactIfDue()
+
+	return nil
+}
+
+// compactIfDue compacts the store if the log has reached the compaction
+// threshold. This is called after a change has already been logged and
+// applied, so a failure here doesn't undo that change. It is only logged and
+// compaction will be tried again after the next change.
+func (rs *RecordStore) compactIfDue() {
+	if rs.compactionThreshold <= 0 || rs.logEntries < rs.compactionThreshold {
+		return
+	}
+
+	err := rs.Compact()
+	if err != nil {
+		storeLogger.Errorf(nil, err, "Could not compact the store: [%s]", rs.rootPath)
+	}
+}
+
+// Compact writes the current state of the store to a new snapshot and empties
+// the log. The snapshot is replaced atomically and records the last change
+// that it includes, so a crash at any point leaves a consistent store.
+func (rs *RecordStore) Compact() (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	pi, positions := encodeIndices(rs.ti, rs.gi)
+
+	recordIds := make([]uint64, len(pi.Records))
+	for gr, i := range positions {
+		recordIds[i] = rs.recordIds[gr]
+	}
+
+	rss := recordStoreSnapshot{
+		Indices:      pi,
+		RecordIds:    recordIds,
+		NextRecordId: rs.nextRecordId,
+		LastSequence: rs.lastSequence,
+	}
+
+	f, err := ioutil.TempFile(rs.rootPath, recordStoreSnapshotFilename+".")
+	log.PanicIf(err)
+
+	tempFilepath := f.Name()
+
+	defer os.Remove(tempFilepath)
+
+	e := gob.NewEncoder(f)
+
+	err = writePersistenceHeader(e, persistedKindRecordStoreSnapshot)
+	if err != nil {
+		f.Close()
+		log.Panic(err)
+	}
+
+	err = e.Encode(rss)
+	if err != nil {
+		f.Close()
+		log.Panic(err)
+	}
+
+	err = f.Sync()
+	if err != nil {
+		f.Close()
+		log.Panic(err)
+	}
+
+	err = f.Close()
+	log.PanicIf(err)
+
+	err = os.Rename(tempFilepath, rs.snapshotFilepath())
+	log.PanicIf(err)
+
+	// The rename must be durable before we empty the log. Otherwise a crash
+	// could leave the old snapshot with an empty log.
+
+	err = syncDirectory(rs.rootPath)
+	log.PanicIf(err)
+
+	// Everything in the log is now in the snapshot.
+
+	err = rs.f.Truncate(0)
+	log.PanicIf(err)
+
+	err = rs.f.Sync()
+	log.PanicIf(err)
+
+	rs.logSize = 0
+	rs.logEntries = 0
+
+	return nil
+}
+
+// Close closes the log. The store can not be used afterward.
+func (rs *RecordStore) Close() (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	err = rs.f.Close()
+	log.PanicIf(err)
+
+	return nil
+}
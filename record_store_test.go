@@ -0,0 +1,373 @@
+package geoindex
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"encoding/gob"
+	"io/ioutil"
+
+	"github.com/dsoprea/go-logging"
+)
+
+func TestRecordStore_AddWithRecord_Remove(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "geoindex")
+	log.PanicIf(err)
+
+	defer os.RemoveAll(tempPath)
+
+	rs, err := OpenRecordStore(tempPath)
+	log.PanicIf(err)
+
+	now := time.Now().UTC()
+
+	gr1 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.34, 34.56, nil)
+
+	err = rs.AddWithRecord(gr1)
+	log.PanicIf(err)
+
+	gr2 := NewGeographicRecord(SourceImageJpeg, "image.jpg", now.Add(time.Second), false, 0, 0, ImageMetadata{CameraModel: "some camera"})
+	gr2.AddRelated(gr1, "nearest")
+
+	err = rs.AddWithRecord(gr2)
+	log.PanicIf(err)
+
+	gr3 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now.Add(time.Minute), true, 12.35, 34.57, nil)
+
+	err = rs.AddWithRecord(gr3)
+	log.PanicIf(err)
+
+	err = rs.Remove(gr3)
+	log.PanicIf(err)
+
+	err = rs.Remove(gr3)
+	if err != ErrRecordNotFound {
+		t.Fatalf("Expected not-found error for second removal: %v", err)
+	}
+
+	err = rs.Close()
+	log.PanicIf(err)
+
+	// Reopen and replay.
+
+	rs, err = OpenRecordStore(tempPath)
+	log.PanicIf(err)
+
+	defer rs.Close()
+
+	if rs.TimeIndex().RecordCount() != 2 {
+		t.Fatalf("Time-index record count not correct: (%d)", rs.TimeIndex().RecordCount())
+	} else if rs.GeographicIndex().RecordCount() != 1 {
+		t.Fatalf("Geographic-index record count not correct: (%d)", rs.GeographicIndex().RecordCount())
+	}
+
+	records := rs.TimeIndex().records()
+	if records[0].Equal(gr1) != true {
+		t.Fatalf("First record not correct: %s", records[0])
+	} else if records[1].Equal(gr2) != true {
+		t.Fatalf("Second record not correct: %s", records[1])
+	}
+
+	related := records[1].Relationships()["nearest"]
+	if len(related) != 1 || related[0] != records[0] {
+		t.Fatalf("Relationship not restored: %v", related)
+	}
+
+	results, err := rs.GeographicIndex().GetWithCoordinatesMetroLimited(12.34, 34.56)
+	log.PanicIf(err)
+
+	if len(results) != 1 || results[0] != records[0] {
+		t.Fatalf("Indices do not share the same record: %v", results)
+	}
+}
+
+func TestRecordStore_TornWrite(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "geoindex")
+	log.PanicIf(err)
+
+	defer os.RemoveAll(tempPath)
+
+	rs, err := OpenRecordStore(tempPath)
+	log.PanicIf(err)
+
+	gr := NewGeographicRecord(SourceGeographicGpx, "data.gpx", time.Now(), true, 12.34, 34.56, nil)
+
+	err = rs.AddWithRecord(gr)
+	log.PanicIf(err)
+
+	err = rs.Close()
+	log.PanicIf(err)
+
+	logFilepath := path.Join(tempPath, recordStoreLogFilename)
+
+	fi, err := os.Stat(logFilepath)
+	log.PanicIf(err)
+
+	validSize := fi.Size()
+
+	// Simulate a crash in the middle of writing the next entry.
+
+	f, err := os.OpenFile(logFilepath, os.O_WRONLY|os.O_APPEND, 0644)
+	log.PanicIf(err)
+
+	_, err = f.Write([]byte{0, 0, 1, 0, 0xaa, 0xbb, 0xcc, 0xdd, 1, 2, 3})
+	log.PanicIf(err)
+
+	err = f.Close()
+	log.PanicIf(err)
+
+	rs, err = OpenRecordStore(tempPath)
+	log.PanicIf(err)
+
+	if rs.TimeIndex().RecordCount() != 1 {
+		t.Fatalf("Record count not correct: (%d)", rs.TimeIndex().RecordCount())
+	}
+
+	fi, err = os.Stat(logFilepath)
+	log.PanicIf(err)
+
+	if fi.Size() != validSize {
+		t.Fatalf("Torn write was not truncated: (%d) != (%d)", fi.Size(), validSize)
+	}
+
+	// We should still be able to append after recovering.
+
+	gr = NewGeographicRecord(SourceGeographicGpx, "data.gpx", time.Now(), true, 12.35, 34.57, nil)
+
+	err = rs.AddWithRecord(gr)
+	log.PanicIf(err)
+
+	err = rs.Close()
+	log.PanicIf(err)
+
+	rs, err = OpenRecordStore(tempPath)
+	log.PanicIf(err)
+
+	defer rs.Close()
+
+	if rs.TimeIndex().RecordCount() != 2 {
+		t.Fatalf("Record count after recovery not correct: (%d)", rs.TimeIndex().RecordCount())
+	}
+}
+
+func TestRecordStore_Compact(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "geoindex")
+	log.PanicIf(err)
+
+	defer os.RemoveAll(tempPath)
+
+	rs, err := OpenRecordStore(tempPath)
+	log.PanicIf(err)
+
+	rs.SetCompactionThreshold(2)
+
+	now := time.Now().UTC()
+
+	for i := 0; i < 3; i++ {
+		gr := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now.Add(time.Duration(i)*time.Second), true, 12.34, 34.56, nil)
+
+		err := rs.AddWithRecord(gr)
+		log.PanicIf(err)
+	}
+
+	logFilepath := path.Join(tempPath, recordStoreLogFilename)
+
+	staleLog, err := ioutil.ReadFile(logFilepath)
+	log.PanicIf(err)
+
+	err = rs.Compact()
+	log.PanicIf(err)
+
+	err = rs.Close()
+	log.PanicIf(err)
+
+	// Simulate a crash after the snapshot was written but before the log was
+	// emptied. The entries should not be applied twice.
+
+	err = ioutil.WriteFile(logFilepath, staleLog, 0644)
+	log.PanicIf(err)
+
+	rs, err = OpenRecordStore(tempPath)
+	log.PanicIf(err)
+
+	defer rs.Close()
+
+	if rs.TimeIndex().RecordCount() != 3 {
+		t.Fatalf("Time-index record count not correct: (%d)", rs.TimeIndex().RecordCount())
+	} else if rs.GeographicIndex().RecordCount() != 3 {
+		t.Fatalf("Geographic-index record count not correct: (%d)", rs.GeographicIndex().RecordCount())
+	}
+
+	// Removing a record from the snapshot should still work.
+
+	gr := rs.TimeIndex().records()[0]
+
+	err = rs.Remove(gr)
+	log.PanicIf(err)
+
+	if rs.TimeIndex().RecordCount() != 2 {
+		t.Fatalf("Record was not removed: (%d)", rs.TimeIndex().RecordCount())
+	}
+}
+
+func TestRecordStore_AddWithRecord_RejectedBeforeLogging(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "geoindex")
+	log.PanicIf(err)
+
+	defer os.RemoveAll(tempPath)
+
+	rs, err := OpenRecordStore(tempPath)
+	log.PanicIf(err)
+
+	now := time.Now().UTC()
+
+	gr1 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.34, 34.56, nil)
+
+	err = rs.AddWithRecord(gr1)
+	log.PanicIf(err)
+
+	// The geographic-index can not accept a record without a cell.
+	gr2 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now.Add(time.Second), true, 12.35, 34.57, nil)
+	gr2.S2CellId = 0
+
+	err = rs.AddWithRecord(gr2)
+	if err == nil {
+		t.Fatalf("Expected failure for record that can not be indexed.")
+	}
+
+	gr3 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now.Add(time.Minute), true, 12.36, 34.58, nil)
+
+	err = rs.AddWithRecord(gr3)
+	log.PanicIf(err)
+
+	if rs.TimeIndex().RecordCount() != 2 {
+		t.Fatalf("Time-index record count not correct: (%d)", rs.TimeIndex().RecordCount())
+	}
+
+	err = rs.Close()
+	log.PanicIf(err)
+
+	// The state after reopening must match the state before.
+
+	rs, err = OpenRecordStore(tempPath)
+	log.PanicIf(err)
+
+	defer rs.Close()
+
+	if rs.TimeIndex().RecordCount() != 2 {
+		t.Fatalf("Time-index record count not correct after reopening: (%d)", rs.TimeIndex().RecordCount())
+	} else if rs.GeographicIndex().RecordCount() != 2 {
+		t.Fatalf("Geographic-index record count not correct after reopening: (%d)", rs.GeographicIndex().RecordCount())
+	}
+}
+
+func TestRecordStore_FailedWrite(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "geoindex")
+	log.PanicIf(err)
+
+	defer os.RemoveAll(tempPath)
+
+	rs, err := OpenRecordStore(tempPath)
+	log.PanicIf(err)
+
+	now := time.Now().UTC()
+
+	gr1 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.34, 34.56, nil)
+
+	err = rs.AddWithRecord(gr1)
+	log.PanicIf(err)
+
+	// Neither the write nor the truncation can succeed on a closed file.
+	err = rs.f.Close()
+	log.PanicIf(err)
+
+	gr2 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now.Add(time.Second), true, 12.35, 34.57, nil)
+
+	err = rs.AddWithRecord(gr2)
+	if err == nil {
+		t.Fatalf("Expected failure for write to closed log.")
+	} else if rs.failed != true {
+		t.Fatalf("Store not marked as failed.")
+	}
+
+	err = rs.Remove(gr1)
+	if log.Is(err, ErrRecordStoreFailed) != true {
+		t.Fatalf("Expected failed-store error: %v", err)
+	}
+
+	if rs.TimeIndex().RecordCount() != 1 {
+		t.Fatalf("Failed changes were applied: (%d)", rs.TimeIndex().RecordCount())
+	}
+}
+
+func TestRecordStore_OldLogEntry(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "geoindex")
+	log.PanicIf(err)
+
+	defer os.RemoveAll(tempPath)
+
+	// An entry from before the log had versions.
+
+	rle := recordLogEntry{
+		Sequence:  1,
+		Operation: recordLogAdd,
+		RecordId:  1,
+		Record:    encodeRecord(NewGeographicRecord(SourceGeographicGpx, "data.gpx", time.Now(), true, 12.34, 34.56, nil), nil),
+	}
+
+	payload := new(bytes.Buffer)
+
+	err = gob.NewEncoder(payload).Encode(rle)
+	log.PanicIf(err)
+
+	logFilepath := path.Join(tempPath, recordStoreLogFilename)
+
+	err = ioutil.WriteFile(logFilepath, encodeFrame(payload.Bytes()), 0644)
+	log.PanicIf(err)
+
+	_, err = OpenRecordStore(tempPath)
+	if err == nil {
+		t.Fatalf("Expected failure for a log entry without a version.")
+	}
+}
+
+func TestRecordStore_AddWithRecord_CompactionFails(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "geoindex")
+	log.PanicIf(err)
+
+	defer os.RemoveAll(tempPath)
+
+	rs, err := OpenRecordStore(tempPath)
+	log.PanicIf(err)
+
+	defer rs.Close()
+
+	rs.SetCompactionThreshold(1)
+
+	// The log stays open but a new snapshot can't be created.
+	err = os.RemoveAll(tempPath)
+	log.PanicIf(err)
+
+	gr := NewGeographicRecord(SourceGeographicGpx, "data.gpx", time.Now(), true, 12.34, 34.56, nil)
+
+	err = rs.AddWithRecord(gr)
+	if err != nil {
+		t.Fatalf("Add failed because compaction failed: [%v]", err)
+	}
+
+	if rs.TimeIndex().RecordCount() != 1 || rs.logEntries != 1 {
+		t.Fatalf("Add not applied: (%d) (%d)", rs.TimeIndex().RecordCount(), rs.logEntries)
+	}
+
+	err = rs.Remove(gr)
+	if err != nil {
+		t.Fatalf("Remove failed because compaction failed: [%v]", err)
+	}
+
+	if rs.TimeIndex().RecordCount() != 0 {
+		t.Fatalf("Remove not applied: (%d)", rs.TimeIndex().RecordCount())
+	}
+}
@@ -0,0 +1,175 @@
+package geoindex
+
+import (
+	"sort"
+)
+
+// secondaryIndex finds records by some key derived from them. Separate
+// records may have the same key so we keep all of them.
+type secondaryIndex struct {
+	key     func(gr *GeographicRecord) string
+	records map[string]map[*GeographicRecord]struct{}
+
+	// sorted has the records for a key in time order. It is populated when
+	// the key is read and dropped when the key is modified.
+	sorted map[string][]*GeographicRecord
+}
+
+func newSecondaryIndex(key func(gr *GeographicRecord) string) *secondaryIndex {
+	return &secondaryIndex{
+		key:     key,
+		records: make(map[string]map[*GeographicRecord]struct{}),
+		sorted:  make(map[string][]*GeographicRecord),
+	}
+}
+
+func (si *secondaryIndex) add(gr *GeographicRecord) {
+	key := si.key(gr)
+
+	indexed, found := si.records[key]
+	if found == false {
+		indexed = make(map[*GeographicRecord]struct{})
+		si.records[key] = indexed
+	}
+
+	indexed[gr] = struct{}{}
+	delete(si.sorted, key)
+}
+
+// remove removes the given record. The record is matched by identity.
+func (si *secondaryIndex) remove(gr *GeographicRecord) {
+	key := si.key(gr)
+
+	indexed := si.records[key]
+	if _, found := indexed[gr]; found == false {
+		return
+	}
+
+	if len(indexed) == 1 {
+		delete(si.records, key)
+	} else {
+		delete(indexed, gr)
+	}
+
+	delete(si.sorted, key)
+}
+
+// ordered returns the records with the given key in time order. The returned
+// slice must not be modified.
+func (si *secondaryIndex) ordered(key string) []*GeographicRecord {
+	if records, found := si.sorted[key]; found == true {
+		return records
+	}
+
+	indexed := si.records[key]
+	if len(indexed) == 0 {
+		return nil
+	}
+
+	records := make([]*GeographicRecord, 0, len(indexed))
+	for gr := range indexed {
+		records = append(records, gr)
+	}
+
+	// Ties are broken by ID so that the order doesn't depend on the map.
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Timestamp.Equal(records[j].Timestamp) == false {
+			return records[i].Timestamp.Before(records[j].Timestamp)
+		}
+
+		return records[i].Id() < records[j].Id()
+	})
+
+	si.sorted[key] = records
+
+	return records
+}
+
+// first returns the first record with the given key.
+func (si *secondaryIndex) first(key string) (gr *GeographicRecord, err error) {
+	indexed := si.records[key]
+	if len(indexed) == 0 {
+		return nil, ErrRecordNotFound
+	} else if len(indexed) == 1 {
+		for gr := range indexed {
+			return gr, nil
+		}
+	}
+
+	return si.ordered(key)[0], nil
+}
+
+// get returns every record with the given key, ordered by time.
+func (si *secondaryIndex) get(key string) []*GeographicRecord {
+	ordered := si.ordered(key)
+
+	records := make([]*GeographicRecord, len(ordered))
+	copy(records, ordered)
+
+	return records
+}
+
+// keys returns every key, sorted.
+func (si *secondaryIndex) keys() []string {
+	keys := make([]string, 0, len(si.records))
+	for key := range si.records {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// secondaryIndices are the secondary indices of a `TimeIndex` or
+// `GeographicIndex`. They are only built once they are first queried, so
+// indices that are never queried this way (such as the geographic-index of an
+// `Index`, whose lookups are served by its time-index) don't pay for them.
+type secondaryIndices struct {
+	ids       *secondaryIndex
+	filepaths *secondaryIndex
+	sources   *secondaryIndex
+}
+
+// newSecondaryIndices returns secondary indices of the given records.
+func newSecondaryIndices(records []*GeographicRecord) *secondaryIndices {
+	si := &secondaryIndices{
+		ids: newSecondaryIndex(func(gr *GeographicRecord) string {
+			return gr.Id()
+		}),
+		filepaths: newSecondaryIndex(func(gr *GeographicRecord) string {
+			return gr.Filepath
+		}),
+		sources: newSecondaryIndex(func(gr *GeographicRecord) string {
+			return gr.SourceName
+		}),
+	}
+
+	for _, gr := range records {
+		si.add(gr)
+	}
+
+	return si
+}
+
+// add adds the record. Does nothing if the indices haven't been built.
+func (si *secondaryIndices) add(gr *GeographicRecord) {
+	if si == nil {
+		return
+	}
+
+	si.ids.add(gr)
+	si.filepaths.add(gr)
+	si.sources.add(gr)
+}
+
+// remove removes the record. Does nothing if the indices haven't been built.
+func (si *secondaryIndices) remove(gr *GeographicRecord) {
+	if si == nil {
+		return
+	}
+
+	si.ids.remove(gr)
+	si.filepaths.remove(gr)
+	si.sources.remove(gr)
+}
@@ -0,0 +1,638 @@
+package geoindex
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"encoding/gob"
+	"io/ioutil"
+
+	"github.com/dsoprea/go-logging"
+	"github.com/dsoprea/go-time-index"
+)
+
+const (
+	// DefaultShardMemoryBudget is the default maximum number of records that
+	// we will keep loaded across all shards.
+	DefaultShardMemoryBudget = 1000000
+
+	shardManifestFilename = "manifest"
+	shardFileExtension    = ".ti"
+)
+
+// ShardPeriod is the span of time covered by a single shard.
+type ShardPeriod int
+
+const (
+	ShardByMonth ShardPeriod = iota
+	ShardByYear
+)
+
+// key returns the name of the shard that the given time belongs to.
+func (sp ShardPeriod) key(t time.Time) string {
+	t = t.UTC()
+
+	if sp == ShardByYear {
+		return t.Format("2006")
+	}
+
+	return t.Format("2006-01")
+}
+
+// bounds returns the time span covered by the shard with the given name.
+func (sp ShardPeriod) bounds(key string) (start, end time.Time, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	if sp == ShardByYear {
+		start, err = time.Parse("2006", key)
+		log.PanicIf(err)
+
+		return start, start.AddDate(1, 0, 0), nil
+	}
+
+	start, err = time.Parse("2006-01", key)
+	log.PanicIf(err)
+
+	return start, start.AddDate(0, 1, 0), nil
+}
+
+// timeIndexShard is the part of the index for a single period. `ti` is `nil`
+// while the shard is not loaded.
+type timeIndexShard struct {
+	key         string
+	start       time.Time
+	end         time.Time
+	ti          *TimeIndex
+	recordCount int
+	dirty       bool
+	lastUsed    uint64
+
+	// pins is the number of calls that are still using the shard's records.
+	// Pinned shards are not evicted.
+	pins int
+
+	// persisted is true if the shard has been saved and persistedCount is the
+	// number of records that it was saved with. Only these go into the
+	// manifest.
+	persisted      bool
+	persistedCount int
+}
+
+// shardManifest records the shards that exist so that we don't have to load
+// them to know how many records they have.
+type shardManifest struct {
+	Period ShardPeriod
+	Shards map[string]int
+}
+
+// ShardedTimeIndex is a `TimeIndex` that is partitioned by month or year into
+// shards that are stored separately on disk. Shards are loaded as queries
+// touch them and the least-recently used shards are persisted and unloaded
+// when more than the memory-budget number of records are loaded.
+//
+// Relationships between records in different shards are not preserved.
+type ShardedTimeIndex struct {
+	rootPath     string
+	period       ShardPeriod
+	shards       map[string]*timeIndexShard
+	memoryBudget int
+	clock        uint64
+}
+
+// OpenShardedTimeIndex opens the sharded index in the given directory,
+// creating it if necessary. `period` must match the period that an existing
+// index was created with.
+func OpenShardedTimeIndex(rootPath string, period ShardPeriod) (sti *ShardedTimeIndex, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	err = os.MkdirAll(rootPath, 0755)
+	log.PanicIf(err)
+
+	sti = &ShardedTimeIndex{
+		rootPath:     rootPath,
+		period:       period,
+		shards:       make(map[string]*timeIndexShard),
+		memoryBudget: DefaultShardMemoryBudget,
+	}
+
+	f, err := os.Open(sti.manifestFilepath())
+	if err != nil {
+		if os.IsNotExist(err) == true {
+			return sti, nil
+		}
+
+		log.Panic(err)
+	}
+
+	defer f.Close()
+
+	sm := shardManifest{}
+
+	d := gob.NewDecoder(f)
+
+	err = d.Decode(&sm)
+	log.PanicIf(err)
+
+	if sm.Period != period {
+		log.Panicf("index was sharded with a different period")
+	}
+
+	for key, recordCount := range sm.Shards {
+		start, end, err := period.bounds(key)
+		log.PanicIf(err)
+
+		sti.shards[key] = &timeIndexShard{
+			key:            key,
+			start:          start,
+			end:            end,
+			recordCount:    recordCount,
+			persisted:      true,
+			persistedCount: recordCount,
+		}
+	}
+
+	return sti, nil
+}
+
+// SetMemoryBudget sets the maximum number of records that will be kept
+// loaded. The shard currently being used is always loaded even if it alone
+// exceeds the budget.
+func (sti *ShardedTimeIndex) SetMemoryBudget(records int) {
+	sti.memoryBudget = records
+}
+
+func (sti *ShardedTimeIndex) manifestFilepath() string {
+	return path.Join(sti.rootPath, shardManifestFilename)
+}
+
+func (sti *ShardedTimeIndex) shardFilepath(key string) string {
+	return path.Join(sti.rootPath, key+shardFileExtension)
+}
+
+// sortedShards returns the shards in time order.
+func (sti *ShardedTimeIndex) sortedShards() []*timeIndexShard {
+	shards := make([]*timeIndexShard, 0, len(sti.shards))
+	for _, shard := range sti.shards {
+		shards = append(shards, shard)
+	}
+
+	sort.Slice(shards, func(i, j int) bool {
+		return shards[i].start.Before(shards[j].start)
+	})
+
+	return shards
+}
+
+// loadedRecordCount returns the number of records in loaded shards.
+func (sti *ShardedTimeIndex) loadedRecordCount() int {
+	count := 0
+	for _, shard := range sti.shards {
+		if shard.ti != nil {
+			count += shard.recordCount
+		}
+	}
+
+	return count
+}
+
+// saveShard persists a shard.
+func (sti *ShardedTimeIndex) saveShard(shard *timeIndexShard) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	shardFilepath := sti.shardFilepath(shard.key)
+
+	f, err := ioutil.TempFile(sti.rootPath, shard.key+".")
+	log.PanicIf(err)
+
+	tempFilepath := f.Name()
+
+	defer os.Remove(tempFilepath)
+
+	err = shard.ti.Save(f)
+	if err != nil {
+		f.Close()
+		log.Panic(err)
+	}
+
+	err = f.Close()
+	log.PanicIf(err)
+
+	err = os.Rename(tempFilepath, shardFilepath)
+	log.PanicIf(err)
+
+	shard.dirty = false
+	shard.persisted = true
+	shard.persistedCount = shard.recordCount
+
+	return nil
+}
+
+// evict unloads the least-recently-used shards until we are within the
+// memory budget. `current` and pinned shards are never evicted. Modified
+// shards are persisted
+// and, if any were, so is the manifest, so that evicted shards (including new
+// ones) are known when the index is reopened even without a `Flush`.
+func (sti *ShardedTimeIndex) evict(current *timeIndexShard) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	loadedCount := sti.loadedRecordCount()
+	saved := false
+
+	for loadedCount > sti.memoryBudget {
+		var lru *timeIndexShard
+		for _, shard := range sti.shards {
+			if shard.ti == nil || shard == current || shard.pins > 0 {
+				continue
+			}
+
+			if lru == nil || shard.lastUsed < lru.lastUsed {
+				lru = shard
+			}
+		}
+
+		if lru == nil {
+			break
+		}
+
+		if lru.dirty == true {
+			err := sti.saveShard(lru)
+			log.PanicIf(err)
+
+			saved = true
+		}
+
+		lru.ti = nil
+		loadedCount -= lru.recordCount
+	}
+
+	if saved == true {
+		err := sti.saveManifest()
+		log.PanicIf(err)
+	}
+
+	return nil
+}
+
+// unpinShards releases shards that were pinned by a call that has finished
+// with their records.
+func unpinShards(shards []*timeIndexShard) {
+	for _, shard := range shards {
+		shard.pins--
+	}
+}
+
+// use makes sure that the given shard is loaded, evicting others if
+// necessary.
+func (sti *ShardedTimeIndex) use(shard *timeIndexShard) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	sti.clock++
+	shard.lastUsed = sti.clock
+
+	if shard.ti != nil {
+		return nil
+	}
+
+	ti := NewTimeIndex()
+
+	// A shard that was never saved has no file yet.
+	f, err := os.Open(sti.shardFilepath(shard.key))
+	if err == nil {
+		defer f.Close()
+
+		err := ti.Load(f)
+		log.PanicIf(err)
+	} else if os.IsNotExist(err) == false || shard.persisted == true {
+		log.Panic(err)
+	}
+
+	shard.ti = ti
+	shard.recordCount = ti.RecordCount()
+
+	err = sti.evict(shard)
+	log.PanicIf(err)
+
+	return nil
+}
+
+// shardFor returns the shard for the given time, creating it if `create` is
+// true. Returns `nil` if the shard doesn't exist and `create` is false.
+func (sti *ShardedTimeIndex) shardFor(t time.Time, create bool) (shard *timeIndexShard, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	key := sti.period.key(t)
+
+	shard, found := sti.shards[key]
+	if found == false {
+		if create == false {
+			return nil, nil
+		}
+
+		start, end, err := sti.period.bounds(key)
+		log.PanicIf(err)
+
+		shard = &timeIndexShard{
+			key:   key,
+			start: start,
+			end:   end,
+			ti:    NewTimeIndex(),
+		}
+
+		sti.shards[key] = shard
+	}
+
+	err = sti.use(shard)
+	log.PanicIf(err)
+
+	return shard, nil
+}
+
+// AddWithRecord adds a record to the shard for its timestamp.
+func (sti *ShardedTimeIndex) AddWithRecord(gr *GeographicRecord) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	shard, err := sti.shardFor(gr.Timestamp, true)
+	log.PanicIf(err)
+
+	err = shard.ti.AddWithRecord(gr)
+	log.PanicIf(err)
+
+	shard.recordCount++
+	shard.dirty = true
+
+	err = sti.evict(shard)
+	log.PanicIf(err)
+
+	return nil
+}
+
+// Add adds a record to the index. See `TimeIndex.Add`.
+func (sti *ShardedTimeIndex) Add(sourceName string, filepath string, timestamp time.Time, hasGeographic bool, latitude float64, longitude float64, metadata interface{}) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	gr := NewGeographicRecord(sourceName, filepath, timestamp, hasGeographic, latitude, longitude, metadata)
+
+	err = sti.AddWithRecord(gr)
+	log.PanicIf(err)
+
+	return nil
+}
+
+// Remove removes the given record. If the record itself is not indexed (e.g.
+// it was returned before its shard was unloaded and loaded again), the record
+// with the same ID (see `GeographicRecord.Id`) is removed instead.
+func (sti *ShardedTimeIndex) Remove(gr *GeographicRecord) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	shard, err := sti.shardFor(gr.Timestamp, false)
+	log.PanicIf(err)
+
+	if shard == nil {
+		return ErrRecordNotFound
+	}
+
+	err = shard.ti.Remove(gr)
+	if err == ErrRecordNotFound {
+		indexedGr, err := shard.ti.GetById(gr.Id())
+		if err == ErrRecordNotFound {
+			return err
+		}
+
+		log.PanicIf(err)
+
+		err = shard.ti.Remove(indexedGr)
+		log.PanicIf(err)
+	} else if err != nil {
+		log.Panic(err)
+	}
+
+	shard.recordCount--
+	shard.dirty = true
+
+	return nil
+}
+
+// RecordCount returns the number of records across all shards, without
+// loading them.
+func (sti *ShardedTimeIndex) RecordCount() int {
+	count := 0
+	for _, shard := range sti.shards {
+		count += shard.recordCount
+	}
+
+	return count
+}
+
+// Range returns the part of the series from `from` (inclusive) to `to`
+// (exclusive). Only the shards that overlap the range are loaded, and they are
+// kept loaded for the duration of the call.
+func (sti *ShardedTimeIndex) Range(from, to time.Time) (ts timeindex.TimeSlice, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	ts = make(timeindex.TimeSlice, 0)
+
+	// The shards that we've already visited stay loaded until we return since
+	// `ts` refers to their records.
+	pinned := make([]*timeIndexShard, 0)
+	defer func() {
+		unpinShards(pinned)
+	}()
+
+	for _, shard := range sti.sortedShards() {
+		if shard.start.Before(to) == false || shard.end.After(from) == false {
+			continue
+		}
+
+		err := sti.use(shard)
+		log.PanicIf(err)
+
+		shard.pins++
+		pinned = append(pinned, shard)
+
+		ts = append(ts, shard.ti.Range(from, to)...)
+	}
+
+	return ts, nil
+}
+
+// Series returns every record in the index. This loads every shard and keeps
+// them loaded for the duration of the call, so it may exceed the memory
+// budget.
+func (sti *ShardedTimeIndex) Series() (ts timeindex.TimeSlice, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	ts = make(timeindex.TimeSlice, 0)
+
+	pinned := make([]*timeIndexShard, 0)
+	defer func() {
+		unpinShards(pinned)
+	}()
+
+	for _, shard := range sti.sortedShards() {
+		err := sti.use(shard)
+		log.PanicIf(err)
+
+		shard.pins++
+		pinned = append(pinned, shard)
+
+		ts = append(ts, shard.ti.Series()...)
+	}
+
+	return ts, nil
+}
+
+// ExportGpx writes every record to GPX. See `TimeIndex.ExportGpx`.
+func (sti *ShardedTimeIndex) ExportGpx(w io.Writer) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	ts, err := sti.Series()
+	log.PanicIf(err)
+
+	err = NewTimeIndexFromSlice(ts).ExportGpx(w)
+	log.PanicIf(err)
+
+	return nil
+}
+
+// Flush persists every modified shard and the manifest.
+func (sti *ShardedTimeIndex) Flush() (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	for _, shard := range sti.shards {
+		if shard.dirty == true {
+			err := sti.saveShard(shard)
+			log.PanicIf(err)
+		}
+	}
+
+	err = sti.saveManifest()
+	log.PanicIf(err)
+
+	return nil
+}
+
+// saveManifest persists the list of shards that have been saved and the
+// number of records that they were saved with.
+func (sti *ShardedTimeIndex) saveManifest() (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	sm := shardManifest{
+		Period: sti.period,
+		Shards: make(map[string]int, len(sti.shards)),
+	}
+
+	for key, shard := range sti.shards {
+		if shard.persisted == true {
+			sm.Shards[key] = shard.persistedCount
+		}
+	}
+
+	f, err := ioutil.TempFile(sti.rootPath, shardManifestFilename+".")
+	log.PanicIf(err)
+
+	tempFilepath := f.Name()
+
+	defer os.Remove(tempFilepath)
+
+	e := gob.NewEncoder(f)
+
+	err = e.Encode(sm)
+	if err != nil {
+		f.Close()
+		log.Panic(err)
+	}
+
+	err = f.Close()
+	log.PanicIf(err)
+
+	err = os.Rename(tempFilepath, sti.manifestFilepath())
+	log.PanicIf(err)
+
+	return nil
+}
+
+// Close flushes the index.
+func (sti *ShardedTimeIndex) Close() (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	err = sti.Flush()
+	log.PanicIf(err)
+
+	return nil
+}
+
+func (sti *ShardedTimeIndex) String() string {
+	loaded := make([]string, 0)
+	for _, shard := range sti.sortedShards() {
+		if shard.ti != nil {
+			loaded = append(loaded, shard.key)
+		}
+	}
+
+	return fmt.Sprintf("ShardedTimeIndex<PATH=[%s] SHARDS=(%d) LOADED=[%s]>", sti.rootPath, len(sti.shards), strings.Join(loaded, ","))
+}
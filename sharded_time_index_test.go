@@ -0,0 +1,253 @@
+package geoindex
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"io/ioutil"
+
+	"github.com/dsoprea/go-logging"
+)
+
+func TestShardedTimeIndex(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "geoindex")
+	log.PanicIf(err)
+
+	defer os.RemoveAll(tempPath)
+
+	sti, err := OpenShardedTimeIndex(tempPath, ShardByMonth)
+	log.PanicIf(err)
+
+	sti.SetMemoryBudget(2)
+
+	timestamps := []time.Time{
+		time.Date(2018, 1, 10, 0, 0, 0, 0, time.UTC),
+		time.Date(2018, 1, 20, 0, 0, 0, 0, time.UTC),
+		time.Date(2018, 2, 10, 0, 0, 0, 0, time.UTC),
+		time.Date(2018, 3, 10, 0, 0, 0, 0, time.UTC),
+	}
+
+	for _, timestamp := range timestamps {
+		err := sti.Add(SourceGeographicGpx, "data.gpx", timestamp, true, 12.34, 34.56, nil)
+		log.PanicIf(err)
+
+		if sti.loadedRecordCount() > 2 && len(sti.shards) > 1 {
+			t.Fatalf("Memory budget exceeded: %s", sti)
+		}
+	}
+
+	if sti.RecordCount() != 4 {
+		t.Fatalf("Record count not correct: (%d)", sti.RecordCount())
+	}
+
+	ts, err := sti.Range(time.Date(2018, 1, 15, 0, 0, 0, 0, time.UTC), time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC))
+	log.PanicIf(err)
+
+	if len(ts) != 2 {
+		t.Fatalf("Range not correct: %v", ts)
+	} else if ts[0].Time.Equal(timestamps[1]) == false || ts[1].Time.Equal(timestamps[2]) == false {
+		t.Fatalf("Range has wrong records: %v", ts)
+	}
+
+	err = sti.Close()
+	log.PanicIf(err)
+
+	// Reopen. Nothing should be loaded until it is queried.
+
+	sti, err = OpenShardedTimeIndex(tempPath, ShardByMonth)
+	log.PanicIf(err)
+
+	if sti.RecordCount() != 4 {
+		t.Fatalf("Record count after reopening not correct: (%d)", sti.RecordCount())
+	} else if sti.loadedRecordCount() != 0 {
+		t.Fatalf("Shards were loaded eagerly: %s", sti)
+	}
+
+	ts, err = sti.Range(time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2018, 4, 1, 0, 0, 0, 0, time.UTC))
+	log.PanicIf(err)
+
+	if len(ts) != 1 || ts[0].Time.Equal(timestamps[3]) == false {
+		t.Fatalf("Range after reopening not correct: %v", ts)
+	} else if sti.loadedRecordCount() != 1 {
+		t.Fatalf("Only the March shard should be loaded: %s", sti)
+	}
+
+	ts, err = sti.Series()
+	log.PanicIf(err)
+
+	if len(ts) != 4 {
+		t.Fatalf("Series not correct: %v", ts)
+	}
+
+	for i, timeItem := range ts {
+		if timeItem.Time.Equal(timestamps[i]) == false {
+			t.Fatalf("Series is not in order: %v", ts)
+		}
+	}
+}
+
+func TestOpenShardedTimeIndex_WrongPeriod(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "geoindex")
+	log.PanicIf(err)
+
+	defer os.RemoveAll(tempPath)
+
+	sti, err := OpenShardedTimeIndex(tempPath, ShardByYear)
+	log.PanicIf(err)
+
+	err = sti.Close()
+	log.PanicIf(err)
+
+	_, err = OpenShardedTimeIndex(tempPath, ShardByMonth)
+	if err == nil {
+		t.Fatalf("Expected failure for a different period.")
+	}
+}
+
+func TestShardedTimeIndex_EvictWithoutFlush(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "geoindex")
+	log.PanicIf(err)
+
+	defer os.RemoveAll(tempPath)
+
+	sti, err := OpenShardedTimeIndex(tempPath, ShardByMonth)
+	log.PanicIf(err)
+
+	sti.SetMemoryBudget(1)
+
+	january := time.Date(2018, 1, 10, 0, 0, 0, 0, time.UTC)
+	february := time.Date(2018, 2, 10, 0, 0, 0, 0, time.UTC)
+
+	err = sti.Add(SourceGeographicGpx, "data.gpx", january, true, 12.34, 34.56, nil)
+	log.PanicIf(err)
+
+	// This evicts the new January shard.
+	err = sti.Add(SourceGeographicGpx, "data.gpx", february, true, 12.34, 34.56, nil)
+	log.PanicIf(err)
+
+	// Reopen without flushing. The evicted shard must still be known.
+
+	reopenedSti, err := OpenShardedTimeIndex(tempPath, ShardByMonth)
+	log.PanicIf(err)
+
+	if reopenedSti.RecordCount() != 1 {
+		t.Fatalf("Record count after reopening not correct: (%d)", reopenedSti.RecordCount())
+	}
+
+	ts, err := reopenedSti.Range(january, february)
+	log.PanicIf(err)
+
+	if len(ts) != 1 || ts[0].Time.Equal(january) == false {
+		t.Fatalf("Evicted shard not found after reopening: %v", ts)
+	}
+}
+
+func TestShardedTimeIndex_Remove_AfterReload(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "geoindex")
+	log.PanicIf(err)
+
+	defer os.RemoveAll(tempPath)
+
+	sti, err := OpenShardedTimeIndex(tempPath, ShardByMonth)
+	log.PanicIf(err)
+
+	sti.SetMemoryBudget(1)
+
+	january := time.Date(2018, 1, 10, 0, 0, 0, 0, time.UTC)
+	february := time.Date(2018, 2, 10, 0, 0, 0, 0, time.UTC)
+
+	gr := NewGeographicRecord(SourceGeographicGpx, "data.gpx", january, true, 12.34, 34.56, nil)
+
+	err = sti.AddWithRecord(gr)
+	log.PanicIf(err)
+
+	// Evict the January shard. Removing the record will load it again, so
+	// the indexed record will be a different object.
+	err = sti.Add(SourceGeographicGpx, "data.gpx", february, true, 12.34, 34.56, nil)
+	log.PanicIf(err)
+
+	err = sti.Remove(gr)
+	log.PanicIf(err)
+
+	if sti.RecordCount() != 1 {
+		t.Fatalf("Record count not correct after removal: (%d)", sti.RecordCount())
+	}
+
+	err = sti.Remove(gr)
+	if err != ErrRecordNotFound {
+		t.Fatalf("Expected not-found error for second removal: %v", err)
+	}
+}
+
+func TestShardedTimeIndex_MissingShardFile(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "geoindex")
+	log.PanicIf(err)
+
+	defer os.RemoveAll(tempPath)
+
+	sti, err := OpenShardedTimeIndex(tempPath, ShardByMonth)
+	log.PanicIf(err)
+
+	january := time.Date(2018, 1, 10, 0, 0, 0, 0, time.UTC)
+
+	err = sti.Add(SourceGeographicGpx, "data.gpx", january, true, 12.34, 34.56, nil)
+	log.PanicIf(err)
+
+	err = sti.Flush()
+	log.PanicIf(err)
+
+	err = os.Remove(sti.shardFilepath("2018-01"))
+	log.PanicIf(err)
+
+	reopenedSti, err := OpenShardedTimeIndex(tempPath, ShardByMonth)
+	log.PanicIf(err)
+
+	_, err = reopenedSti.Range(january, january.AddDate(0, 1, 0))
+	if err == nil {
+		t.Fatalf("Expected failure for a shard whose file is missing.")
+	}
+}
+
+func TestShardedTimeIndex_Range_PinsShards(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "geoindex")
+	log.PanicIf(err)
+
+	defer os.RemoveAll(tempPath)
+
+	sti, err := OpenShardedTimeIndex(tempPath, ShardByMonth)
+	log.PanicIf(err)
+
+	january := time.Date(2018, 1, 10, 0, 0, 0, 0, time.UTC)
+	february := time.Date(2018, 2, 10, 0, 0, 0, 0, time.UTC)
+	march := time.Date(2018, 3, 10, 0, 0, 0, 0, time.UTC)
+
+	for _, timestamp := range []time.Time{january, february, march} {
+		err := sti.Add(SourceGeographicGpx, "data.gpx", timestamp, true, 12.34, 34.56, nil)
+		log.PanicIf(err)
+	}
+
+	err = sti.Flush()
+	log.PanicIf(err)
+
+	reopenedSti, err := OpenShardedTimeIndex(tempPath, ShardByMonth)
+	log.PanicIf(err)
+
+	reopenedSti.SetMemoryBudget(1)
+
+	ts, err := reopenedSti.Range(january, march.AddDate(0, 1, 0))
+	log.PanicIf(err)
+
+	if len(ts) != 3 {
+		t.Fatalf("Range not correct: %v", ts)
+	}
+
+	// Every shard that the range used was kept loaded.
+	for _, shard := range reopenedSti.shards {
+		if shard.ti == nil {
+			t.Fatalf("Shard was evicted during the range: [%s]", shard.key)
+		} else if shard.pins != 0 {
+			t.Fatalf("Shard is still pinned: [%s]", shard.key)
+		}
+	}
+}
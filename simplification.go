@@ -0,0 +1,341 @@
+package geoindex
+
+import (
+	"math"
+	"time"
+
+	"container/heap"
+
+	"github.com/dsoprea/go-logging"
+)
+
+// SimplifyOptions determines which data-log points are dropped by
+// `TimeIndex.Simplify`. Every option that is zero is disabled. Distances are
+// in meters.
+type SimplifyOptions struct {
+	// MinimumInterval drops points that are less than this long after the
+	// last point that was kept.
+	MinimumInterval time.Duration
+
+	// MinimumDistance drops points that are less than this far from the last
+	// point that was kept.
+	MinimumDistance float64
+
+	// DouglasPeuckerTolerance drops points that are less than this far from
+	// the line that the track would follow without them (Ramer-Douglas-
+	// Peucker).
+	DouglasPeuckerTolerance float64
+
+	// VisvalingamArea repeatedly drops the point that forms the smallest
+	// triangle with its neighbors until every triangle is at least this
+	// many square meters (Visvalingam-Whyatt).
+	VisvalingamArea float64
+}
+
+// projectMeters returns the position of the record relative to the origin on
+// a flat plane, in meters. This is accurate enough over the short distances
+// between consecutive points.
+func projectMeters(origin, gr *GeographicRecord) (x, y float64) {
+	metersPerDegree := earthRadiusMeters * math.Pi / 180
+
+	x = (gr.Longitude - origin.Longitude) * math.Cos(origin.Latitude*math.Pi/180) * metersPerDegree
+	y = (gr.Latitude - origin.Latitude) * metersPerDegree
+
+	return x, y
+}
+
+// distanceFromSegment returns the distance in meters from the record to the
+// segment between `from` and `to`.
+func distanceFromSegment(gr, from, to *GeographicRecord) float64 {
+	x, y := projectMeters(from, gr)
+	toX, toY := projectMeters(from, to)
+
+	lengthSquared := toX*toX + toY*toY
+	if lengthSquared == 0 {
+		return math.Hypot(x, y)
+	}
+
+	// Where the record falls along the segment, clamped to its ends.
+	t := (x*toX + y*toY) / lengthSquared
+	if t < 0 {
+		t = 0
+	} else if t > 1 {
+		t = 1
+	}
+
+	return math.Hypot(x-t*toX, y-t*toY)
+}
+
+// triangleArea returns the area in square meters of the triangle formed by
+// the three records.
+func triangleArea(a, b, c *GeographicRecord) float64 {
+	bX, bY := projectMeters(a, b)
+	cX, cY := projectMeters(a, c)
+
+	return math.Abs(bX*cY-cX*bY) / 2
+}
+
+// decimate drops points that are too close in time or distance to the last
+// point that was kept. The first and last points and pinned points are always
+// kept.
+func decimate(track []*GeographicRecord, pinned map[*GeographicRecord]bool, minimumInterval time.Duration, minimumDistance float64) []*GeographicRecord {
+	if len(track) <= 2 {
+		return track
+	}
+
+	kept := []*GeographicRecord{track[0]}
+	for i, gr := range track[1:] {
+		last := kept[len(kept)-1]
+
+		if i+1 < len(track)-1 && pinned[gr] == false {
+			if minimumInterval > 0 && gr.Timestamp.Sub(last.Timestamp) < minimumInterval {
+				continue
+			} else if minimumDistance > 0 && recordDistanceMeters(last, gr) < minimumDistance {
+				continue
+			}
+		}
+
+		kept = append(kept, gr)
+	}
+
+	return kept
+}
+
+// douglasPeucker simplifies the track with the Ramer-Douglas-Peucker
+// algorithm. The track is first split at the pinned points so that they are
+// always kept.
+func douglasPeucker(track []*GeographicRecord, pinned map[*GeographicRecord]bool, tolerance float64) []*GeographicRecord {
+	if len(track) <= 2 {
+		return track
+	}
+
+	keep := make([]bool, len(track))
+	keep[0] = true
+	keep[len(track)-1] = true
+
+	for i, gr := range track {
+		if pinned[gr] == true {
+			keep[i] = true
+		}
+	}
+
+	// Simplify between every pair of consecutive kept points. Use a stack
+	// rather than recursing since tracks can be very long.
+
+	type span struct {
+		from, to int
+	}
+
+	stack := make([]span, 0)
+
+	from := 0
+	for i := 1; i < len(track); i++ {
+		if keep[i] == true {
+			stack = append(stack, span{from, i})
+			from = i
+		}
+	}
+
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		furthest := -1
+		furthestDistance := tolerance
+		for i := s.from + 1; i < s.to; i++ {
+			distance := distanceFromSegment(track[i], track[s.from], track[s.to])
+			if distance > furthestDistance {
+				furthest = i
+				furthestDistance = distance
+			}
+		}
+
+		if furthest == -1 {
+			continue
+		}
+
+		keep[furthest] = true
+		stack = append(stack, span{s.from, furthest}, span{furthest, s.to})
+	}
+
+	kept := make([]*GeographicRecord, 0)
+	for i, gr := range track {
+		if keep[i] == true {
+			kept = append(kept, gr)
+		}
+	}
+
+	return kept
+}
+
+// visvalingamPoint is a point in the track that may still be dropped.
+type visvalingamPoint struct {
+	index    int
+	area     float64
+	previous *visvalingamPoint
+	next     *visvalingamPoint
+
+	// heapIndex is the position of the point in the heap, or -1 if it's been
+	// dropped.
+	heapIndex int
+}
+
+// visvalingamHeap orders points by the area of their triangles.
+type visvalingamHeap []*visvalingamPoint
+
+func (vh visvalingamHeap) Len() int {
+	return len(vh)
+}
+
+func (vh visvalingamHeap) Less(i, j int) bool {
+	return vh[i].area < vh[j].area
+}
+
+func (vh visvalingamHeap) Swap(i, j int) {
+	vh[i], vh[j] = vh[j], vh[i]
+	vh[i].heapIndex = i
+	vh[j].heapIndex = j
+}
+
+func (vh *visvalingamHeap) Push(x interface{}) {
+	vp := x.(*visvalingamPoint)
+	vp.heapIndex = len(*vh)
+	*vh = append(*vh, vp)
+}
+
+func (vh *visvalingamHeap) Pop() interface{} {
+	old := *vh
+	vp := old[len(old)-1]
+	vp.heapIndex = -1
+	*vh = old[:len(old)-1]
+
+	return vp
+}
+
+// visvalingam simplifies the track with the Visvalingam-Whyatt algorithm. The
+// first and last points and pinned points are always kept.
+func visvalingam(track []*GeographicRecord, pinned map[*GeographicRecord]bool, minimumArea float64) []*GeographicRecord {
+	if len(track) <= 2 {
+		return track
+	}
+
+	points := make([]*visvalingamPoint, len(track))
+	for i := range track {
+		points[i] = &visvalingamPoint{
+			index:     i,
+			heapIndex: -1,
+		}
+
+		if i > 0 {
+			points[i].previous = points[i-1]
+			points[i-1].next = points[i]
+		}
+	}
+
+	vh := make(visvalingamHeap, 0, len(track))
+	for _, vp := range points[1 : len(points)-1] {
+		if pinned[track[vp.index]] == true {
+			continue
+		}
+
+		vp.area = triangleArea(track[vp.previous.index], track[vp.index], track[vp.next.index])
+		heap.Push(&vh, vp)
+	}
+
+	dropped := make([]bool, len(track))
+
+	// A neighbor's area is never allowed to be less than that of the point
+	// that was just dropped so that points are dropped in order of
+	// significance.
+	for vh.Len() > 0 && vh[0].area < minimumArea {
+		vp := heap.Pop(&vh).(*visvalingamPoint)
+		dropped[vp.index] = true
+
+		vp.previous.next = vp.next
+		vp.next.previous = vp.previous
+
+		for _, neighbor := range []*visvalingamPoint{vp.previous, vp.next} {
+			if neighbor.heapIndex == -1 {
+				continue
+			}
+
+			area := triangleArea(track[neighbor.previous.index], track[neighbor.index], track[neighbor.next.index])
+			if area < vp.area {
+				area = vp.area
+			}
+
+			neighbor.area = area
+			heap.Fix(&vh, neighbor.heapIndex)
+		}
+	}
+
+	kept := make([]*GeographicRecord, 0)
+	for i, gr := range track {
+		if dropped[i] == false {
+			kept = append(kept, gr)
+		}
+	}
+
+	return kept
+}
+
+// simplifyTrack applies the options to a single track, in order.
+func simplifyTrack(track []*GeographicRecord, pinned map[*GeographicRecord]bool, options SimplifyOptions) []*GeographicRecord {
+	if options.MinimumInterval > 0 || options.MinimumDistance > 0 {
+		track = decimate(track, pinned, options.MinimumInterval, options.MinimumDistance)
+	}
+
+	if options.DouglasPeuckerTolerance > 0 {
+		track = douglasPeucker(track, pinned, options.DouglasPeuckerTolerance)
+	}
+
+	if options.VisvalingamArea > 0 {
+		track = visvalingam(track, pinned, options.VisvalingamArea)
+	}
+
+	return track
+}
+
+// Simplify returns a new index with fewer data-log points, which can then be
+// exported with any of the exporters. Each file's track is simplified
+// separately. Records that aren't data-log points (e.g. images) are always
+// kept, as are the points that they are related to (see
+// `GeographicRecord.AddRelated`). The records are shared rather than copied.
+func (index *TimeIndex) Simplify(options SimplifyOptions) (simplified *TimeIndex, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	records := index.records()
+
+	pinned := make(map[*GeographicRecord]bool)
+	tracks := make(map[string][]*GeographicRecord)
+
+	for _, gr := range records {
+		if isTrackRecord(gr) == true {
+			tracks[gr.Filepath] = append(tracks[gr.Filepath], gr)
+			continue
+		}
+
+		for _, grrt := range gr.relatedTo {
+			pinned[grrt.GeographicRecord] = true
+		}
+	}
+
+	kept := make(map[*GeographicRecord]bool, len(records))
+	for _, track := range tracks {
+		for _, gr := range simplifyTrack(track, pinned, options) {
+			kept[gr] = true
+		}
+	}
+
+	simplified, err = index.Subset(func(gr *GeographicRecord) bool {
+		return isTrackRecord(gr) == false || kept[gr] == true
+	})
+
+	log.PanicIf(err)
+
+	return simplified, nil
+}
@@ -0,0 +1,115 @@
+package geoindex
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dsoprea/go-logging"
+)
+
+// simplificationTestTrack returns a track heading north at about 1m/s with
+// a few centimeters of noise and a detour of about 50 meters in the middle.
+func simplificationTestTrack() (track []*GeographicRecord) {
+	epoch := time.Date(2020, 6, 1, 8, 0, 0, 0, time.UTC)
+
+	track = make([]*GeographicRecord, 101)
+	for i := range track {
+		longitude := 20.0
+		if i%2 == 1 {
+			longitude += 0.0000005
+		}
+
+		if i == 50 {
+			longitude += 0.0005
+		}
+
+		track[i] = NewGeographicRecord(SourceGeographicGpx, "data.gpx", epoch.Add(time.Second*time.Duration(i)), true, 10.0+float64(i)*0.00001, longitude, nil)
+	}
+
+	return track
+}
+
+func TestTimeIndex_Simplify_DouglasPeucker(t *testing.T) {
+	track := simplificationTestTrack()
+	ti := getTestIndex(track)
+
+	simplified, err := ti.Simplify(SimplifyOptions{
+		DouglasPeuckerTolerance: 1,
+	})
+
+	log.PanicIf(err)
+
+	records := simplified.records()
+	if len(records) != 5 {
+		t.Fatalf("Simplified record count not correct: (%d)", len(records))
+	}
+
+	expected := []*GeographicRecord{track[0], track[49], track[50], track[51], track[100]}
+	for i, gr := range expected {
+		if records[i] != gr {
+			t.Fatalf("Record (%d) not correct: %s", i, records[i])
+		}
+	}
+
+	if ti.RecordCount() != 101 {
+		t.Fatalf("Original index should not have changed.")
+	}
+}
+
+func TestTimeIndex_Simplify_Visvalingam(t *testing.T) {
+	track := simplificationTestTrack()
+	ti := getTestIndex(track)
+
+	simplified, err := ti.Simplify(SimplifyOptions{
+		VisvalingamArea: 10,
+	})
+
+	log.PanicIf(err)
+
+	records := simplified.records()
+
+	found := false
+	for _, gr := range records {
+		if gr == track[50] {
+			found = true
+			break
+		}
+	}
+
+	if found != true {
+		t.Fatalf("Detour was dropped.")
+	} else if len(records) > 5 || records[0] != track[0] || records[len(records)-1] != track[100] {
+		t.Fatalf("Records not correct: %v", records)
+	}
+}
+
+func TestTimeIndex_Simplify_MinimumInterval(t *testing.T) {
+	track := simplificationTestTrack()
+	ti := getTestIndex(track)
+
+	// Images are always kept, as are the points that they're related to.
+	image := NewGeographicRecord(SourceImageJpeg, "image.jpg", track[33].Timestamp, true, track[33].Latitude, track[33].Longitude, nil)
+	image.AddRelated(track[33], "nearest")
+
+	err := ti.AddWithRecord(image)
+	log.PanicIf(err)
+
+	simplified, err := ti.Simplify(SimplifyOptions{
+		MinimumInterval: time.Second * 10,
+		MinimumDistance: 5,
+	})
+
+	log.PanicIf(err)
+
+	// A point every ten seconds (restarting at the related point), the image,
+	// and the last point.
+	if simplified.RecordCount() != 13 {
+		t.Fatalf("Simplified record count not correct: (%d)", simplified.RecordCount())
+	}
+
+	for _, gr := range []*GeographicRecord{track[0], track[10], track[33], image, track[100]} {
+		if _, err := simplified.GetById(gr.Id()); err != nil {
+			t.Fatalf("Expected record to be kept: %s", gr)
+		}
+	}
+}
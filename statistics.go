@@ -0,0 +1,144 @@
+package geoindex
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dsoprea/go-time-index"
+)
+
+const (
+	// DefaultMovingSpeedThreshold is the default speed (in meters per second)
+	// below which we consider ourselves to be stopped. GPS drift will
+	// otherwise make it look like we're always moving.
+	DefaultMovingSpeedThreshold = 0.5
+)
+
+// TrackStatistics describes the tracks formed by the data-log points in an
+// index (see `isTrackRecord`). The points from each file are a separate track,
+// even if files overlap in time, and the statistics are totals over every
+// track. Distances are in meters and speeds are in meters per second.
+type TrackStatistics struct {
+	PointCount int
+
+	// Distance is the total great-circle distance between consecutive
+	// points in each track.
+	Distance float64
+
+	// Duration is the time from the first point to the last. MovingTime and
+	// StoppedTime are the time between consecutive points in each track,
+	// depending on the speed between them. They only add up to the duration
+	// if there is a single track or the tracks don't overlap.
+	Duration    time.Duration
+	MovingTime  time.Duration
+	StoppedTime time.Duration
+
+	// AverageSpeed is the average speed while moving.
+	AverageSpeed float64
+	MaxSpeed     float64
+
+	// ElevationGain and ElevationLoss are the total ascent and descent
+	// between consecutive points that have an altitude. They are only valid
+	// if HasElevation is true.
+	HasElevation  bool
+	ElevationGain float64
+	ElevationLoss float64
+}
+
+func (stats TrackStatistics) String() string {
+	return fmt.Sprintf("TrackStatistics<POINTS=(%d) DISTANCE=(%.1f) DURATION=[%s] MOVING=[%s] AVG-SPEED=(%.2f) MAX-SPEED=(%.2f)>", stats.PointCount, stats.Distance, stats.Duration, stats.MovingTime, stats.AverageSpeed, stats.MaxSpeed)
+}
+
+// trackStatistics calculates the statistics for the track records in the
+// given series.
+func trackStatistics(series timeindex.TimeSlice, movingSpeedThreshold float64) (stats TrackStatistics) {
+	if movingSpeedThreshold == 0 {
+		movingSpeedThreshold = DefaultMovingSpeedThreshold
+	}
+
+	var first *GeographicRecord
+	var last *GeographicRecord
+
+	previousByFilepath := make(map[string]*GeographicRecord)
+
+	movingDistance := float64(0)
+
+	for _, timeItem := range series {
+		for _, item := range timeItem.Items {
+			gr := item.(*GeographicRecord)
+			if isTrackRecord(gr) == false {
+				continue
+			}
+
+			stats.PointCount++
+
+			if first == nil {
+				first = gr
+			}
+
+			last = gr
+
+			previous := previousByFilepath[gr.Filepath]
+			previousByFilepath[gr.Filepath] = gr
+
+			if previous == nil {
+				continue
+			}
+
+			distance := recordDistanceMeters(previous, gr)
+			elapsed := gr.Timestamp.Sub(previous.Timestamp)
+
+			stats.Distance += distance
+
+			if elapsed > 0 {
+				speed := distance / elapsed.Seconds()
+
+				if speed >= movingSpeedThreshold {
+					stats.MovingTime += elapsed
+					movingDistance += distance
+
+					if speed > stats.MaxSpeed {
+						stats.MaxSpeed = speed
+					}
+				} else {
+					stats.StoppedTime += elapsed
+				}
+			}
+
+			if previous.HasAltitude == true && gr.HasAltitude == true {
+				stats.HasElevation = true
+
+				if climb := gr.Altitude - previous.Altitude; climb > 0 {
+					stats.ElevationGain += climb
+				} else {
+					stats.ElevationLoss -= climb
+				}
+			}
+		}
+	}
+
+	if first != nil {
+		stats.Duration = last.Timestamp.Sub(first.Timestamp)
+	}
+
+	// Only the distance covered while moving counts toward the average.
+	if stats.MovingTime > 0 {
+		stats.AverageSpeed = movingDistance / stats.MovingTime.Seconds()
+	}
+
+	return stats
+}
+
+// TrackStatistics calculates the statistics of the track formed by the
+// data-log points in the index. Points are considered stopped when the speed
+// between them is below `movingSpeedThreshold` meters per second (or
+// `DefaultMovingSpeedThreshold` if zero).
+func (index *TimeIndex) TrackStatistics(movingSpeedThreshold float64) TrackStatistics {
+	return trackStatistics(index.ts, movingSpeedThreshold)
+}
+
+// TrackStatisticsRange is `TrackStatistics` for the points from `from`
+// (inclusive) to `to` (exclusive).
+func (index *TimeIndex) TrackStatisticsRange(from, to time.Time, movingSpeedThreshold float64) TrackStatistics {
+	return trackStatistics(index.Range(from, to), movingSpeedThreshold)
+}
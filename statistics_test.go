@@ -0,0 +1,114 @@
+package geoindex
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/dsoprea/go-logging"
+)
+
+func TestTimeIndex_TrackStatistics(t *testing.T) {
+	ti := NewTimeIndex()
+
+	epoch := time.Date(2020, 6, 1, 8, 0, 0, 0, time.UTC)
+
+	// 0.001 degrees of latitude is about 111 meters.
+	points := []struct {
+		offset   time.Duration
+		latitude float64
+		altitude float64
+	}{
+		{0, 10.0, 100},
+		{time.Minute, 10.001, 110},
+		{time.Minute * 2, 10.002, 105},
+
+		// Standing still for five minutes.
+		{time.Minute * 7, 10.002, 105},
+
+		{time.Minute * 8, 10.003, 125},
+	}
+
+	records := make([]*GeographicRecord, 0)
+	for _, point := range points {
+		gr := NewGeographicRecord(SourceGeographicGpx, "data.gpx", epoch.Add(point.offset), true, point.latitude, 20.0, nil)
+		gr.HasAltitude = true
+		gr.Altitude = point.altitude
+
+		records = append(records, gr)
+	}
+
+	// Images are not part of the track.
+	records = append(records, NewGeographicRecord(SourceImageJpeg, "image.jpg", epoch.Add(time.Minute*3), true, 50.0, 50.0, nil))
+
+	err := ti.AddRecords(records)
+	log.PanicIf(err)
+
+	stats := ti.TrackStatistics(0)
+
+	if stats.PointCount != 5 {
+		t.Fatalf("Point count not correct: (%d)", stats.PointCount)
+	} else if math.Abs(stats.Distance-3*111.2) > 1 {
+		t.Fatalf("Distance not correct: (%.2f)", stats.Distance)
+	} else if stats.Duration != time.Minute*8 {
+		t.Fatalf("Duration not correct: [%s]", stats.Duration)
+	} else if stats.MovingTime != time.Minute*3 || stats.StoppedTime != time.Minute*5 {
+		t.Fatalf("Moving or stopped time not correct: [%s] [%s]", stats.MovingTime, stats.StoppedTime)
+	} else if math.Abs(stats.AverageSpeed-111.2/60) > 0.01 || math.Abs(stats.MaxSpeed-111.2/60) > 0.01 {
+		t.Fatalf("Speeds not correct: (%.4f) (%.4f)", stats.AverageSpeed, stats.MaxSpeed)
+	} else if stats.HasElevation != true || stats.ElevationGain != 30 || stats.ElevationLoss != 5 {
+		t.Fatalf("Elevation not correct: (%.1f) (%.1f)", stats.ElevationGain, stats.ElevationLoss)
+	}
+
+	stats = ti.TrackStatisticsRange(epoch, epoch.Add(time.Minute*2), 0)
+	if stats.PointCount != 2 || stats.Duration != time.Minute {
+		t.Fatalf("Range statistics not correct: %s", stats)
+	}
+}
+
+func TestTimeIndex_TrackStatistics_Empty(t *testing.T) {
+	ti := NewTimeIndex()
+
+	stats := ti.TrackStatistics(0)
+	if stats.PointCount != 0 || stats.Distance != 0 || stats.HasElevation != false {
+		t.Fatalf("Statistics not correct: %s", stats)
+	}
+}
+
+func TestTimeIndex_TrackStatistics_OverlappingFiles(t *testing.T) {
+	ti := NewTimeIndex()
+
+	epoch := time.Date(2020, 6, 1, 8, 0, 0, 0, time.UTC)
+
+	// Two devices logging at the same time, far apart. Each track moves
+	// about 111 meters a minute.
+	records := make([]*GeographicRecord, 0)
+	for i := 0; i < 3; i++ {
+		timestamp := epoch.Add(time.Minute * time.Duration(i))
+		latitude := 10.0 + float64(i)*0.001
+
+		records = append(records, NewGeographicRecord(SourceGeographicGpx, "a.gpx", timestamp, true, latitude, 20.0, nil))
+		records = append(records, NewGeographicRecord(SourceGeographicGpx, "b.gpx", timestamp, true, latitude, 40.0, nil))
+	}
+
+	err := ti.AddRecords(records)
+	log.PanicIf(err)
+
+	stats := ti.TrackStatistics(0)
+
+	if stats.PointCount != 6 {
+		t.Fatalf("Point count not correct: (%d)", stats.PointCount)
+	} else if math.Abs(stats.Distance-4*111.2) > 1 {
+		t.Fatalf("Distance not correct: (%.2f)", stats.Distance)
+	} else if stats.Duration != time.Minute*2 {
+		t.Fatalf("Duration not correct: [%s]", stats.Duration)
+	} else if stats.MovingTime != time.Minute*4 {
+		t.Fatalf("Moving time not correct: [%s]", stats.MovingTime)
+	} else if math.Abs(stats.MaxSpeed-111.2/60) > 0.01 {
+		t.Fatalf("Maximum speed not correct: (%.4f)", stats.MaxSpeed)
+	}
+
+	if distance := trackDistanceMeters(ti.records()); math.Abs(distance-4*111.2) > 1 {
+		t.Fatalf("Track distance not correct: (%.2f)", distance)
+	}
+}
@@ -3,6 +3,8 @@ package geoindex
 import (
 	"os"
 	"path"
+
+	"github.com/dsoprea/go-logging"
 )
 
 var (
@@ -15,3 +17,13 @@ func init() {
 	appPath = path.Join(goPath, "src", "github.com", "dsoprea", "go-geographic-index")
 	testAssetsPath = path.Join(appPath, "test", "asset")
 }
+
+// getTestIndex returns a new time-index with the given records.
+func getTestIndex(records []*GeographicRecord) *TimeIndex {
+	ti := NewTimeIndex()
+
+	err := ti.AddRecords(records)
+	log.PanicIf(err)
+
+	return ti
+}
@@ -2,15 +2,20 @@ package geoindex
 
 import (
 	"io"
+	"sort"
 	"time"
 
-	"github.com/dsoprea/go-gpx/writer"
+	"encoding/gob"
+
 	"github.com/dsoprea/go-logging"
 	"github.com/dsoprea/go-time-index"
 )
 
 type TimeIndex struct {
-	ts timeindex.TimeSlice
+	ts          timeindex.TimeSlice
+	recordCount int
+	dedup       DeduplicationPolicy
+	secondary   *secondaryIndices
 }
 
 func NewTimeIndex() (ti *TimeIndex) {
@@ -20,21 +25,349 @@ func NewTimeIndex() (ti *TimeIndex) {
 }
 
 func NewTimeIndexFromSlice(ts timeindex.TimeSlice) (ti *TimeIndex) {
-	return &TimeIndex{
-		ts: ts,
+	recordCount := 0
+	for _, timeItem := range ts {
+		recordCount += len(timeItem.Items)
+	}
+
+	ti = &TimeIndex{
+		ts:          ts,
+		recordCount: recordCount,
 	}
+
+	return ti
 }
 
 func (index *TimeIndex) Series() timeindex.TimeSlice {
 	return index.ts
 }
 
+// Range returns the part of the series from `from` (inclusive) to `to`
+// (exclusive). The returned slice shares storage with the index.
+func (index *TimeIndex) Range(from, to time.Time) timeindex.TimeSlice {
+	i := index.ts.Search(from)
+	j := index.ts.Search(to)
+
+	if j < i {
+		j = i
+	}
+
+	return index.ts[i:j]
+}
+
+// RecordCount returns the number of records in the index.
+func (index *TimeIndex) RecordCount() int {
+	return index.recordCount
+}
+
+// lookups returns the secondary indices, building them if this is the first
+// time that they are needed.
+func (index *TimeIndex) lookups() *secondaryIndices {
+	if index.secondary == nil {
+		index.secondary = newSecondaryIndices(index.records())
+	}
+
+	return index.secondary
+}
+
+// GetById returns the record with the given ID (see `GeographicRecord.Id`).
+// Returns `ErrRecordNotFound` if there is no such record.
+func (index *TimeIndex) GetById(id string) (gr *GeographicRecord, err error) {
+	return index.lookups().ids.first(id)
+}
+
+// GetByFilepath returns every record from the given file, ordered by time.
+func (index *TimeIndex) GetByFilepath(filepath string) []*GeographicRecord {
+	return index.lookups().filepaths.get(filepath)
+}
+
+// ListFilepaths returns the path of every file that has records in the index,
+// sorted.
+func (index *TimeIndex) ListFilepaths() []string {
+	return index.lookups().filepaths.keys()
+}
+
+// GetBySource returns every record from the given source (e.g.
+// `SourceGeographicGpx`), ordered by time.
+func (index *TimeIndex) GetBySource(sourceName string) []*GeographicRecord {
+	return index.lookups().sources.get(sourceName)
+}
+
+// ListSources returns the name of every source that has records in the index,
+// sorted.
+func (index *TimeIndex) ListSources() []string {
+	return index.lookups().sources.keys()
+}
+
+// records returns every record in the index, in time order.
+func (index *TimeIndex) records() []*GeographicRecord {
+	records := make([]*GeographicRecord, 0, index.recordCount)
+
+	for _, timeItem := range index.ts {
+		for _, item := range timeItem.Items {
+			records = append(records, item.(*GeographicRecord))
+		}
+	}
+
+	return records
+}
+
+// SetDeduplicationPolicy determines what happens when a record is added that
+// is a duplicate of one that is already indexed. Records that are already
+// indexed are not affected.
+func (index *TimeIndex) SetDeduplicationPolicy(dp DeduplicationPolicy) {
+	index.dedup = dp
+}
+
+// findDuplicate returns the first indexed record that is a duplicate of the
+// given record under the given deduplication policy, or `nil`.
+func (index *TimeIndex) findDuplicate(dp DeduplicationPolicy, gr *GeographicRecord) *GeographicRecord {
+	to := gr.Timestamp.Add(dp.TimeTolerance)
+
+	for i := index.ts.Search(gr.Timestamp.Add(-dp.TimeTolerance)); i < len(index.ts); i++ {
+		if index.ts[i].Time.After(to) == true {
+			break
+		}
+
+		for _, item := range index.ts[i].Items {
+			indexedGr := item.(*GeographicRecord)
+			if dp.IsDuplicate(indexedGr, gr) == true {
+				return indexedGr
+			}
+		}
+	}
+
+	return nil
+}
+
 func (index *TimeIndex) AddWithRecord(gr *GeographicRecord) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	if index.dedup.IsActive() == true {
+		if existingGr := index.findDuplicate(index.dedup, gr); existingGr != nil {
+			addIncoming, removeExisting := index.dedup.resolve(existingGr, gr)
+
+			if removeExisting == true {
+				err := index.Remove(existingGr)
+				log.PanicIf(err)
+			}
+
+			if addIncoming == false {
+				return nil
+			}
+		}
+	}
+
 	index.ts = index.ts.Add(gr.Timestamp, gr)
+	index.secondary.add(gr)
+	index.recordCount++
+
+	return nil
+}
+
+// AddRecords adds many records at once. The records are sorted once and
+// merged with the existing series, which is much faster than adding them one
+// at a time with `AddWithRecord` when there are many of them. Records with
+// the same timestamp keep their relative order and follow any that were
+// already indexed. If a deduplication policy is set, the records are added
+// one at a time instead.
+func (index *TimeIndex) AddRecords(records []*GeographicRecord) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	if len(records) == 0 {
+		return nil
+	} else if index.dedup.IsActive() == true {
+		for _, gr := range records {
+			err := index.AddWithRecord(gr)
+			log.PanicIf(err)
+		}
+
+		return nil
+	}
+
+	sorted := make([]*GeographicRecord, len(records))
+	copy(sorted, records)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+
+	incoming := make(timeindex.TimeSlice, 0, len(sorted))
+	for _, gr := range sorted {
+		last := len(incoming) - 1
+		if last >= 0 && incoming[last].Time.Equal(gr.Timestamp) == true {
+			incoming[last].Items = append(incoming[last].Items, gr)
+			continue
+		}
+
+		timeItem := timeindex.TimeItem{
+			Time:  gr.Timestamp,
+			Items: []interface{}{gr},
+		}
+
+		incoming = append(incoming, timeItem)
+	}
+
+	existing := index.ts
+	merged := make(timeindex.TimeSlice, 0, len(existing)+len(incoming))
+
+	i := 0
+	j := 0
+	for i < len(existing) && j < len(incoming) {
+		if existing[i].Time.Before(incoming[j].Time) == true {
+			merged = append(merged, existing[i])
+			i++
+		} else if existing[i].Time.Equal(incoming[j].Time) == true {
+			timeItem := existing[i]
+			timeItem.Items = append(timeItem.Items, incoming[j].Items...)
+
+			merged = append(merged, timeItem)
+			i++
+			j++
+		} else {
+			merged = append(merged, incoming[j])
+			j++
+		}
+	}
+
+	merged = append(merged, existing[i:]...)
+	merged = append(merged, incoming[j:]...)
+
+	index.ts = merged
+	index.recordCount += len(records)
+
+	for _, gr := range records {
+		index.secondary.add(gr)
+	}
 
 	return nil
 }
 
+// Remove removes the given record from the index. The record is matched by
+// identity. Returns `ErrRecordNotFound` if the record is not in the index.
+func (index *TimeIndex) Remove(gr *GeographicRecord) (err error) {
+	i := index.ts.Search(gr.Timestamp)
+	if i >= len(index.ts) || index.ts[i].Time.Equal(gr.Timestamp) == false {
+		return ErrRecordNotFound
+	}
+
+	items := index.ts[i].Items
+	for j, item := range items {
+		if item.(*GeographicRecord) != gr {
+			continue
+		}
+
+		if len(items) == 1 {
+			index.ts = append(index.ts[:i], index.ts[i+1:]...)
+		} else {
+			index.ts[i].Items = append(items[:j], items[j+1:]...)
+		}
+
+		index.secondary.remove(gr)
+		index.recordCount--
+
+		return nil
+	}
+
+	return ErrRecordNotFound
+}
+
+// findEqual returns the indexed record that is the given record or is equal to
+// it, or `nil`.
+func (index *TimeIndex) findEqual(gr *GeographicRecord) *GeographicRecord {
+	i := index.ts.Search(gr.Timestamp)
+	if i >= len(index.ts) || index.ts[i].Time.Equal(gr.Timestamp) == false {
+		return nil
+	}
+
+	for _, item := range index.ts[i].Items {
+		indexedGr := item.(*GeographicRecord)
+		if indexedGr == gr || indexedGr.Equal(gr) == true {
+			return indexedGr
+		}
+	}
+
+	return nil
+}
+
+// Merge adds the records from the other index to this one. The records are
+// shared rather than copied. `policy` determines what happens to records that
+// are already indexed.
+func (index *TimeIndex) Merge(other *TimeIndex, policy MergePolicy) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	toAdd := make([]*GeographicRecord, 0, other.recordCount)
+	for _, gr := range other.records() {
+		if policy != MergeKeepAll && index.findEqual(gr) != nil {
+			if policy == MergeFailOnDuplicate {
+				return ErrDuplicateRecord
+			}
+
+			continue
+		}
+
+		toAdd = append(toAdd, gr)
+	}
+
+	err = index.AddRecords(toAdd)
+	log.PanicIf(err)
+
+	return nil
+}
+
+// Diff returns the records that were added, removed, and changed in the
+// other index relative to this one.
+func (index *TimeIndex) Diff(other *TimeIndex) IndexDiff {
+	return diffRecords(index.records(), other.records())
+}
+
+// Subset returns a new index with the records that satisfy the predicate. The
+// records are shared rather than copied.
+func (index *TimeIndex) Subset(predicate RecordPredicate) (subset *TimeIndex, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	subset = NewTimeIndex()
+
+	for _, timeItem := range index.ts {
+		items := make([]interface{}, 0)
+		for _, item := range timeItem.Items {
+			if predicate(item.(*GeographicRecord)) == true {
+				items = append(items, item)
+			}
+		}
+
+		if len(items) == 0 {
+			continue
+		}
+
+		subsetTimeItem := timeindex.TimeItem{
+			Time:  timeItem.Time,
+			Items: items,
+		}
+
+		subset.ts = append(subset.ts, subsetTimeItem)
+		subset.recordCount += len(items)
+	}
+
+	return subset, nil
+}
+
 // Add adds a record to the time-index. This method is obsolete. Please use
 // `AddWithRecord` instead.
 func (index *TimeIndex) Add(sourceName string, filepath string, timestamp time.Time, hasGeographic bool, latitude float64, longitude float64, metadata interface{}) (err error) {
@@ -54,46 +387,113 @@ func (index *TimeIndex) Add(sourceName string, filepath string, timestamp time.T
 	return nil
 }
 
+// ExportGpx writes every record with geographic data to a single segment of
+// a single GPX track. See `ExportGpxWithOptions`.
 func (index *TimeIndex) ExportGpx(w io.Writer) (err error) {
+	return index.ExportGpxWithOptions(w, GpxExportOptions{})
+}
+
+// persistedTimeIndex is the serialized form of a `TimeIndex`. `Items` are the
+// positions of the indexed records in `Records`, in time order. `Records` may
+// also have records that are only referred to by relationships.
+type persistedTimeIndex struct {
+	Records []persistedRecord
+	Items   []int
+}
+
+// Save writes the index to the given stream. Records, including their
+// metadata, comments, and relationships, can be restored with `Load`. Any
+// custom metadata types must be registered with `gob.Register`.
+func (index *TimeIndex) Save(w io.Writer) (err error) {
 	defer func() {
 		if state := recover(); state != nil {
 			err = log.Wrap(state.(error))
 		}
 	}()
 
-	b := gpxwriter.NewBuilder(w)
-	gb := b.Gpx()
+	e := gob.NewEncoder(w)
 
-	tb, err := gb.Track()
+	err = writePersistenceHeader(e, persistedKindTimeIndex)
 	log.PanicIf(err)
 
-	tsb, err := tb.TrackSegment()
+	encoded, items := encodeRecordsWithPositions(index.records())
+
+	pti := persistedTimeIndex{
+		Records: encoded,
+		Items:   items,
+	}
+
+	err = e.Encode(pti)
 	log.PanicIf(err)
 
-	for _, timeItem := range index.ts {
-		for _, item := range timeItem.Items {
-			gr := item.(*GeographicRecord)
+	return nil
+}
 
-			if gr.HasGeographic == true {
-				tpb := tsb.TrackPoint()
+// timeSliceFromPositions builds a series from the records at the given
+// positions. The positions must be in time order, as they are when saved, so
+// we can build the series directly rather than inserting one at a time.
+func timeSliceFromPositions(records []*GeographicRecord, positions []int) (ts timeindex.TimeSlice, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
 
-				tpb.LatitudeDecimal = gr.Latitude
-				tpb.LongitudeDecimal = gr.Longitude
-				tpb.Time = timeItem.Time
+	ts = make(timeindex.TimeSlice, 0)
 
-				err = tpb.Write()
-				log.PanicIf(err)
+	for _, i := range positions {
+		if i < 0 || i >= len(records) {
+			log.Panicf("indexed record is not valid: (%d)", i)
+		}
+
+		gr := records[i]
+
+		last := len(ts) - 1
+		if last >= 0 && ts[last].Time.Equal(gr.Timestamp) == true {
+			ts[last].Items = append(ts[last].Items, gr)
+		} else if last >= 0 && ts[last].Time.After(gr.Timestamp) == true {
+			log.Panicf("indexed records are not in time order")
+		} else {
+			timeItem := timeindex.TimeItem{
+				Time:  gr.Timestamp,
+				Items: []interface{}{gr},
 			}
+
+			ts = append(ts, timeItem)
 		}
 	}
 
-	err = tsb.EndTrackSegment()
+	return ts, nil
+}
+
+// Load replaces the content of the index with the index previously written by
+// `Save`.
+func (index *TimeIndex) Load(r io.Reader) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	d := gob.NewDecoder(r)
+
+	err = readPersistenceHeader(d, persistedKindTimeIndex)
+	log.PanicIf(err)
+
+	pti := persistedTimeIndex{}
+
+	err = d.Decode(&pti)
+	log.PanicIf(err)
+
+	records, err := decodeRecords(pti.Records)
 	log.PanicIf(err)
 
-	err = tb.EndTrack()
+	ts, err := timeSliceFromPositions(records, pti.Items)
 	log.PanicIf(err)
 
-	gb.EndGpx()
+	index.ts = ts
+	index.recordCount = len(pti.Items)
+	index.secondary = nil
 
 	return nil
 }
@@ -0,0 +1,306 @@
+package geoindex
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"encoding/base64"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// IterationDirection is the order in which a `TimeIndexIterator` returns
+// records.
+type IterationDirection int
+
+const (
+	// IterateForward returns the oldest records first.
+	IterateForward IterationDirection = iota
+
+	// IterateBackward returns the newest records first.
+	IterateBackward
+)
+
+func (id IterationDirection) String() string {
+	switch id {
+	case IterateForward:
+		return "forward"
+	case IterateBackward:
+		return "backward"
+	}
+
+	return fmt.Sprintf("unknown<%d>", int(id))
+}
+
+// TimeIndexCursor is an opaque position in a `TimeIndex`. It refers to the
+// position of a record rather than to the record itself, so it stays valid
+// while records are added and removed. An empty cursor refers to the start
+// (or, when iterating backward, the end) of the index.
+type TimeIndexCursor string
+
+// iterationKey totally orders the records in a `TimeIndex`. Records with the
+// same timestamp are ordered by ID and then by the order that records with the
+// same ID were added in.
+type iterationKey struct {
+	timestamp time.Time
+	id        string
+	ordinal   int
+}
+
+// compare returns a negative number, zero, or a positive number if the key is
+// less than, equal to, or greater than the other one.
+func (ik iterationKey) compare(other iterationKey) int {
+	if ik.timestamp.Before(other.timestamp) == true {
+		return -1
+	} else if ik.timestamp.After(other.timestamp) == true {
+		return 1
+	} else if ik.id < other.id {
+		return -1
+	} else if ik.id > other.id {
+		return 1
+	}
+
+	return ik.ordinal - other.ordinal
+}
+
+func (ik iterationKey) cursor() TimeIndexCursor {
+	phrase := fmt.Sprintf("%d|%d|%d|%s", ik.timestamp.Unix(), ik.timestamp.Nanosecond(), ik.ordinal, ik.id)
+	return TimeIndexCursor(base64.RawURLEncoding.EncodeToString([]byte(phrase)))
+}
+
+func parseCursor(cursor TimeIndexCursor) (ik iterationKey, err error) {
+	raw, err := base64.RawURLEncoding.DecodeString(string(cursor))
+	if err != nil {
+		return ik, ErrInvalidCursor
+	}
+
+	parts := strings.SplitN(string(raw), "|", 4)
+	if len(parts) != 4 {
+		return ik, ErrInvalidCursor
+	}
+
+	values := make([]int64, 3)
+	for i, part := range parts[:3] {
+		values[i], err = strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return ik, ErrInvalidCursor
+		}
+	}
+
+	ik = iterationKey{
+		timestamp: time.Unix(values[0], values[1]),
+		ordinal:   int(values[2]),
+		id:        parts[3],
+	}
+
+	return ik, nil
+}
+
+// keyedRecord is a record with its iteration key.
+type keyedRecord struct {
+	key iterationKey
+	gr  *GeographicRecord
+}
+
+// keyedItems returns the records with the given timestamp in iteration order.
+func keyedItems(timestamp time.Time, items []interface{}) []keyedRecord {
+	keyed := make([]keyedRecord, len(items))
+	for i, item := range items {
+		gr := item.(*GeographicRecord)
+
+		keyed[i] = keyedRecord{
+			key: iterationKey{
+				timestamp: timestamp,
+				id:        gr.Id(),
+			},
+			gr: gr,
+		}
+	}
+
+	sort.SliceStable(keyed, func(i, j int) bool {
+		return keyed[i].key.id < keyed[j].key.id
+	})
+
+	for i := 1; i < len(keyed); i++ {
+		if keyed[i].key.id == keyed[i-1].key.id {
+			keyed[i].key.ordinal = keyed[i-1].key.ordinal + 1
+		}
+	}
+
+	return keyed
+}
+
+// TimeIndexIterator returns the records in a `TimeIndex` one at a time. The
+// iterator only remembers the position of the last record that it returned,
+// so the index may be changed between calls. Records added behind the
+// iterator will not be returned and records added ahead of it will be.
+type TimeIndexIterator struct {
+	index     *TimeIndex
+	direction IterationDirection
+
+	// key is the position of the last record that was returned. If
+	// `bounded` is false, we haven't returned anything and should start at
+	// the very beginning (or end).
+	key     iterationKey
+	bounded bool
+
+	current *GeographicRecord
+}
+
+// Iterate returns an iterator over the whole index. Use `IterateBackward` to
+// get the newest records first.
+func (index *TimeIndex) Iterate(direction IterationDirection) *TimeIndexIterator {
+	return &TimeIndexIterator{
+		index:     index,
+		direction: direction,
+	}
+}
+
+// IterateFrom returns an iterator that starts at the given time. Iterating
+// forward, the records at or after that time are returned. Iterating
+// backward, the records at or before that time are returned.
+func (index *TimeIndex) IterateFrom(from time.Time, direction IterationDirection) *TimeIndexIterator {
+	// Construct a key that is just before (or after) every record with the
+	// timestamp.
+
+	key := iterationKey{
+		timestamp: from,
+		ordinal:   -1,
+	}
+
+	if direction == IterateBackward {
+		key.timestamp = from.Add(time.Nanosecond)
+	}
+
+	return &TimeIndexIterator{
+		index:     index,
+		direction: direction,
+		key:       key,
+		bounded:   true,
+	}
+}
+
+// IterateFromCursor returns an iterator that starts just after the position of
+// the given cursor (as returned by `TimeIndexIterator.Cursor`) in the given
+// direction. Iterating backward from the cursor of a forward iterator returns
+// the records before that position, and vice-versa.
+func (index *TimeIndex) IterateFromCursor(cursor TimeIndexCursor, direction IterationDirection) (tii *TimeIndexIterator, err error) {
+	if cursor == "" {
+		return index.Iterate(direction), nil
+	}
+
+	key, err := parseCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	tii = &TimeIndexIterator{
+		index:     index,
+		direction: direction,
+		key:       key,
+		bounded:   true,
+	}
+
+	return tii, nil
+}
+
+// Next moves to the next record and returns true, or returns false if there
+// are no more records.
+func (tii *TimeIndexIterator) Next() bool {
+	var kr *keyedRecord
+	if tii.direction == IterateBackward {
+		kr = tii.previousRecord()
+	} else {
+		kr = tii.nextRecord()
+	}
+
+	if kr == nil {
+		tii.current = nil
+		return false
+	}
+
+	tii.key = kr.key
+	tii.bounded = true
+	tii.current = kr.gr
+
+	return true
+}
+
+// nextRecord finds the first record after the current position.
+func (tii *TimeIndexIterator) nextRecord() *keyedRecord {
+	ts := tii.index.ts
+
+	i := 0
+	if tii.bounded == true {
+		i = ts.Search(tii.key.timestamp)
+	}
+
+	for ; i < len(ts); i++ {
+		keyed := keyedItems(ts[i].Time, ts[i].Items)
+		for j := range keyed {
+			if tii.bounded == false || keyed[j].key.compare(tii.key) > 0 {
+				return &keyed[j]
+			}
+		}
+	}
+
+	return nil
+}
+
+// previousRecord finds the last record before the current position.
+func (tii *TimeIndexIterator) previousRecord() *keyedRecord {
+	ts := tii.index.ts
+
+	i := len(ts) - 1
+	if tii.bounded == true {
+		i = ts.Search(tii.key.timestamp)
+		if i >= len(ts) || ts[i].Time.After(tii.key.timestamp) == true {
+			i--
+		}
+	}
+
+	for ; i >= 0; i-- {
+		keyed := keyedItems(ts[i].Time, ts[i].Items)
+		for j := len(keyed) - 1; j >= 0; j-- {
+			if tii.bounded == false || keyed[j].key.compare(tii.key) < 0 {
+				return &keyed[j]
+			}
+		}
+	}
+
+	return nil
+}
+
+// Record returns the current record. This is `nil` before the first call to
+// `Next` and after it has returned false.
+func (tii *TimeIndexIterator) Record() *GeographicRecord {
+	return tii.current
+}
+
+// Cursor returns a cursor for the current position. Resuming from it with
+// `TimeIndex.IterateFromCursor` will continue with the record after the
+// current one.
+func (tii *TimeIndexIterator) Cursor() TimeIndexCursor {
+	if tii.bounded == false {
+		return ""
+	}
+
+	return tii.key.cursor()
+}
+
+// Page returns up to `limit` more records and the cursor to resume from to get
+// the following page.
+func (tii *TimeIndexIterator) Page(limit int) (records []*GeographicRecord, cursor TimeIndexCursor) {
+	records = make([]*GeographicRecord, 0, limit)
+	for len(records) < limit && tii.Next() == true {
+		records = append(records, tii.current)
+	}
+
+	return records, tii.Cursor()
+}
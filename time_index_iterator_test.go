@@ -0,0 +1,157 @@
+package geoindex
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dsoprea/go-logging"
+)
+
+func iteratorTestRecords() (records []*GeographicRecord) {
+	epoch := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	records = make([]*GeographicRecord, 5)
+	for i := range records {
+		records[i] = NewGeographicRecord(SourceGeographicGpx, "data.gpx", epoch.Add(time.Minute*time.Duration(i)), true, 12.34, 34.56, nil)
+		records[i].Position = i
+	}
+
+	return records
+}
+
+func collectIterator(tii *TimeIndexIterator) []*GeographicRecord {
+	records := make([]*GeographicRecord, 0)
+	for tii.Next() == true {
+		records = append(records, tii.Record())
+	}
+
+	return records
+}
+
+func TestTimeIndex_Iterate(t *testing.T) {
+	records := iteratorTestRecords()
+	ti := getTestIndex(records)
+
+	forward := collectIterator(ti.Iterate(IterateForward))
+	if len(forward) != 5 {
+		t.Fatalf("Forward count not correct: (%d)", len(forward))
+	}
+
+	backward := collectIterator(ti.Iterate(IterateBackward))
+	if len(backward) != 5 {
+		t.Fatalf("Backward count not correct: (%d)", len(backward))
+	}
+
+	for i, gr := range records {
+		if forward[i] != gr {
+			t.Fatalf("Forward record (%d) not correct: %s", i, forward[i])
+		} else if backward[len(backward)-i-1] != gr {
+			t.Fatalf("Backward record (%d) not correct: %s", i, backward[i])
+		}
+	}
+}
+
+func TestTimeIndex_IterateFrom(t *testing.T) {
+	records := iteratorTestRecords()
+	ti := getTestIndex(records)
+
+	forward := collectIterator(ti.IterateFrom(records[2].Timestamp, IterateForward))
+	if len(forward) != 3 || forward[0] != records[2] {
+		t.Fatalf("Forward records not correct: %v", forward)
+	}
+
+	backward := collectIterator(ti.IterateFrom(records[2].Timestamp, IterateBackward))
+	if len(backward) != 3 || backward[0] != records[2] || backward[2] != records[0] {
+		t.Fatalf("Backward records not correct: %v", backward)
+	}
+}
+
+func TestTimeIndexIterator_Page(t *testing.T) {
+	records := iteratorTestRecords()
+	ti := getTestIndex(records)
+
+	page, cursor := ti.Iterate(IterateBackward).Page(2)
+	if len(page) != 2 || page[0] != records[4] || page[1] != records[3] {
+		t.Fatalf("First page not correct: %v", page)
+	}
+
+	// A record that arrives at the same time as one that we've already seen
+	// is either before or after the cursor, but the cursor is still valid.
+
+	sameTime := NewGeographicRecord(SourceGeographicGpx, "other.gpx", records[3].Timestamp, true, 12.34, 34.56, nil)
+
+	err := ti.AddWithRecord(sameTime)
+	log.PanicIf(err)
+
+	// A newer record doesn't affect the following pages.
+
+	newer := NewGeographicRecord(SourceGeographicGpx, "data.gpx", records[4].Timestamp.Add(time.Hour), true, 12.34, 34.56, nil)
+
+	err = ti.AddWithRecord(newer)
+	log.PanicIf(err)
+
+	tii, err := ti.IterateFromCursor(cursor, IterateBackward)
+	log.PanicIf(err)
+
+	remaining := collectIterator(tii)
+
+	expectedCount := 3
+	if sameTime.Id() < records[3].Id() {
+		expectedCount = 4
+	}
+
+	if len(remaining) != expectedCount || remaining[len(remaining)-1] != records[0] {
+		t.Fatalf("Remaining records not correct: %v", remaining)
+	}
+
+	for _, gr := range remaining {
+		if gr == newer || gr == records[3] || gr == records[4] {
+			t.Fatalf("Record returned again: %s", gr)
+		}
+	}
+
+	// Iterating forward from the same cursor returns the records that were
+	// after it.
+
+	tii, err = ti.IterateFromCursor(cursor, IterateForward)
+	log.PanicIf(err)
+
+	following := collectIterator(tii)
+	if len(following) != 6-expectedCount || following[len(following)-1] != newer {
+		t.Fatalf("Following records not correct: %v", following)
+	}
+}
+
+func TestTimeIndex_IterateFromCursor_Removed(t *testing.T) {
+	records := iteratorTestRecords()
+	ti := getTestIndex(records)
+
+	tii := ti.Iterate(IterateForward)
+	if tii.Next() != true || tii.Next() != true {
+		t.Fatalf("Expected records.")
+	}
+
+	cursor := tii.Cursor()
+
+	// The cursor still works even if the record that it points to is gone.
+
+	err := ti.Remove(records[1])
+	log.PanicIf(err)
+
+	tii, err = ti.IterateFromCursor(cursor, IterateForward)
+	log.PanicIf(err)
+
+	remaining := collectIterator(tii)
+	if len(remaining) != 3 || remaining[0] != records[2] {
+		t.Fatalf("Remaining records not correct: %v", remaining)
+	}
+}
+
+func TestTimeIndex_IterateFromCursor_Invalid(t *testing.T) {
+	ti := NewTimeIndex()
+
+	_, err := ti.IterateFromCursor("not a cursor", IterateForward)
+	if err != ErrInvalidCursor {
+		t.Fatalf("Expected invalid cursor: %v", err)
+	}
+}
@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"fmt"
 	"path"
+	"reflect"
 	"testing"
 	"time"
 
+	"encoding/gob"
+
 	"github.com/dsoprea/go-logging"
 )
 
@@ -29,20 +32,23 @@ func TestIndex_ExportGpx(t *testing.T) {
 	log.PanicIf(err)
 
 	expected := `<?xml version="1.0" encoding="UTF-8"?>
-<gpx xmlns="http://www.topografix.com/GPX/1/1" xmlns:gpxx="http://www.garmin.com/xmlschemas/GpxExtensions/v3" gpxtpx="http://www.garmin.com/xmlschemas/TrackPointExtension/v1" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://www.topografix.com/GPX/1/1/gpx.xsd http://www.garmin.com/xmlschemas/GpxExtensions/v3 http://www.garmin.com/xmlschemas/GpxExtensionsv3.xsd http://www.garmin.com/xmlschemas/TrackPointExtension/v1 http://www.garmin.com/xmlschemas/TrackPointExtensionv1.xsd">
+<gpx xmlns="http://www.topografix.com/GPX/1/1" xmlns:gpxx="http://www.garmin.com/xmlschemas/GpxExtensions/v3" xmlns:gpxtpx="http://www.garmin.com/xmlschemas/TrackPointExtension/v1" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://www.topografix.com/GPX/1/1/gpx.xsd http://www.garmin.com/xmlschemas/GpxExtensions/v3 http://www.garmin.com/xmlschemas/GpxExtensionsv3.xsd http://www.garmin.com/xmlschemas/TrackPointExtension/v1 http://www.garmin.com/xmlschemas/TrackPointExtensionv1.xsd">
   <trk>
     <trkseg>
       <trkpt lat="47.644548" lon="-122.326897">
-        <time>2009-10-17T18:37:26+0000</time>
+        <ele>4.46</ele>
+        <time>2009-10-17T18:37:26Z</time>
       </trkpt>
       <trkpt lat="47.644548" lon="-122.326897">
-        <time>2009-10-17T18:37:31+0000</time>
+        <ele>4.94</ele>
+        <time>2009-10-17T18:37:31Z</time>
       </trkpt>
       <trkpt lat="47.644548" lon="-122.326897">
-        <time>2009-10-17T18:37:34+0000</time>
+        <ele>6.87</ele>
+        <time>2009-10-17T18:37:34Z</time>
       </trkpt>
       <trkpt lat="26.586666666666666" lon="-80.05361111111111">
-        <time>2018-04-28T21:23:12+0000</time>
+        <time>2018-04-28T21:23:12Z</time>
       </trkpt>
     </trkseg>
   </trk>
@@ -56,8 +62,8 @@ func TestIndex_ExportGpx(t *testing.T) {
 }
 
 func ExampleIndex_ExportGpx() {
-	index := NewTimeIndex()
-	gc := NewGeographicCollector(index, nil)
+	index := NewIndex()
+	gc := NewGeographicCollectorWithIndex(index)
 
 	err := RegisterImageFileProcessors(gc, 0, nil)
 	log.PanicIf(err)
@@ -70,7 +76,7 @@ func ExampleIndex_ExportGpx() {
 
 	buffer := new(bytes.Buffer)
 
-	err = gc.ti.ExportGpx(buffer)
+	err = index.ExportGpx(buffer)
 	log.PanicIf(err)
 
 	output := buffer.String()
@@ -78,20 +84,23 @@ func ExampleIndex_ExportGpx() {
 
 	// Output:
 	// <?xml version="1.0" encoding="UTF-8"?>
-	// <gpx xmlns="http://www.topografix.com/GPX/1/1" xmlns:gpxx="http://www.garmin.com/xmlschemas/GpxExtensions/v3" gpxtpx="http://www.garmin.com/xmlschemas/TrackPointExtension/v1" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://www.topografix.com/GPX/1/1/gpx.xsd http://www.garmin.com/xmlschemas/GpxExtensions/v3 http://www.garmin.com/xmlschemas/GpxExtensionsv3.xsd http://www.garmin.com/xmlschemas/TrackPointExtension/v1 http://www.garmin.com/xmlschemas/TrackPointExtensionv1.xsd">
+	// <gpx xmlns="http://www.topografix.com/GPX/1/1" xmlns:gpxx="http://www.garmin.com/xmlschemas/GpxExtensions/v3" xmlns:gpxtpx="http://www.garmin.com/xmlschemas/TrackPointExtension/v1" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://www.topografix.com/GPX/1/1/gpx.xsd http://www.garmin.com/xmlschemas/GpxExtensions/v3 http://www.garmin.com/xmlschemas/GpxExtensionsv3.xsd http://www.garmin.com/xmlschemas/TrackPointExtension/v1 http://www.garmin.com/xmlschemas/TrackPointExtensionv1.xsd">
 	//   <trk>
 	//     <trkseg>
 	//       <trkpt lat="47.644548" lon="-122.326897">
-	//         <time>2009-10-17T18:37:26+0000</time>
+	//         <ele>4.46</ele>
+	//         <time>2009-10-17T18:37:26Z</time>
 	//       </trkpt>
 	//       <trkpt lat="47.644548" lon="-122.326897">
-	//         <time>2009-10-17T18:37:31+0000</time>
+	//         <ele>4.94</ele>
+	//         <time>2009-10-17T18:37:31Z</time>
 	//       </trkpt>
 	//       <trkpt lat="47.644548" lon="-122.326897">
-	//         <time>2009-10-17T18:37:34+0000</time>
+	//         <ele>6.87</ele>
+	//         <time>2009-10-17T18:37:34Z</time>
 	//       </trkpt>
 	//       <trkpt lat="26.586666666666666" lon="-80.05361111111111">
-	//         <time>2018-04-28T21:23:12+0000</time>
+	//         <time>2018-04-28T21:23:12Z</time>
 	//       </trkpt>
 	//     </trkseg>
 	//   </trk>
@@ -99,12 +108,12 @@ func ExampleIndex_ExportGpx() {
 }
 
 func ExampleIndex_Add() {
-	index := NewTimeIndex()
+	index := NewIndex()
 
 	epochUtc := (time.Time{}).UTC()
 	hasGeographic := true
-	latitude := float64(123.456)
-	longitude := float64(789.012)
+	latitude := float64(12.345)
+	longitude := float64(67.89)
 	var metadata interface{}
 
 	index.Add(SourceGeographicGpx, "data.gpx", epochUtc, hasGeographic, latitude, longitude, metadata)
@@ -120,5 +129,434 @@ func ExampleIndex_Add() {
 	}
 
 	// Output:
-	// [0001-01-01T00:00:00Z] [data.gpx] [true] (123.4560000000) (789.0120000000)
+	// [0001-01-01T00:00:00Z] [data.gpx] [true] (12.3450000000) (67.8900000000)
+}
+
+func TestTimeIndex_Save_Load(t *testing.T) {
+	index := NewTimeIndex()
+
+	now := time.Now().UTC()
+
+	gr1 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.34, 34.56, nil)
+	gr1.AddComment("comment1")
+
+	err := index.AddWithRecord(gr1)
+	log.PanicIf(err)
+
+	gr2 := NewGeographicRecord(SourceImageJpeg, "image.jpg", now, false, 0, 0, ImageMetadata{CameraModel: "some camera"})
+	gr2.AddRelated(gr1, "nearest")
+
+	err = index.AddWithRecord(gr2)
+	log.PanicIf(err)
+
+	gr3 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now.Add(time.Hour), true, 12.35, 34.57, nil)
+
+	err = index.AddWithRecord(gr3)
+	log.PanicIf(err)
+
+	b := new(bytes.Buffer)
+
+	err = index.Save(b)
+	log.PanicIf(err)
+
+	recovered := NewTimeIndex()
+
+	err = recovered.Load(b)
+	log.PanicIf(err)
+
+	if recovered.RecordCount() != 3 {
+		t.Fatalf("Record count not correct: (%d)", recovered.RecordCount())
+	}
+
+	ts := recovered.Series()
+	if len(ts) != 2 {
+		t.Fatalf("Series not correct: %v", ts)
+	} else if len(ts[0].Items) != 2 {
+		t.Fatalf("First time not correct: %v", ts[0].Items)
+	}
+
+	original := index.records()
+	for i, gr := range recovered.records() {
+		if gr.Equal(original[i]) != true {
+			t.Fatalf("Record (%d) not correct: %s", i, gr)
+		}
+	}
+
+	recoveredGr1 := ts[0].Items[0].(*GeographicRecord)
+	recoveredGr2 := ts[0].Items[1].(*GeographicRecord)
+
+	related := recoveredGr2.Relationships()["nearest"]
+	if len(related) != 1 || related[0] != recoveredGr1 {
+		t.Fatalf("Relationship not restored to the same record: %v", related)
+	}
+}
+
+func TestTimeIndex_Load_WrongKind(t *testing.T) {
+	b := new(bytes.Buffer)
+
+	e := gob.NewEncoder(b)
+
+	err := writePersistenceHeader(e, "something-else")
+	log.PanicIf(err)
+
+	index := NewTimeIndex()
+
+	err = index.Load(b)
+	if err == nil {
+		t.Fatalf("Expected failure for the wrong kind of data.")
+	}
+}
+
+func TestTimeIndex_Load_OldVersion(t *testing.T) {
+	b := new(bytes.Buffer)
+
+	e := gob.NewEncoder(b)
+
+	ph := persistenceHeader{
+		Magic:   persistenceMagic,
+		Version: 1,
+		Kind:    persistedKindTimeIndex,
+	}
+
+	err := e.Encode(ph)
+	log.PanicIf(err)
+
+	index := NewTimeIndex()
+
+	err = index.Load(b)
+	if err == nil {
+		t.Fatalf("Expected failure for data without record positions.")
+	}
+}
+
+func TestTimeIndex_Remove(t *testing.T) {
+	index := NewTimeIndex()
+
+	now := time.Now()
+
+	gr1 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.34, 34.56, nil)
+	gr2 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.35, 34.57, nil)
+
+	for _, gr := range []*GeographicRecord{gr1, gr2} {
+		err := index.AddWithRecord(gr)
+		log.PanicIf(err)
+	}
+
+	err := index.Remove(gr1)
+	log.PanicIf(err)
+
+	if index.RecordCount() != 1 {
+		t.Fatalf("Record count not correct: (%d)", index.RecordCount())
+	} else if len(index.ts) != 1 || index.ts[0].Items[0] != gr2 {
+		t.Fatalf("Wrong record removed: %v", index.ts)
+	}
+
+	err = index.Remove(gr1)
+	if err != ErrRecordNotFound {
+		t.Fatalf("Expected not-found error: %v", err)
+	}
+
+	err = index.Remove(gr2)
+	log.PanicIf(err)
+
+	if len(index.ts) != 0 {
+		t.Fatalf("Empty time was not removed: %v", index.ts)
+	}
+}
+
+func TestTimeIndex_Range(t *testing.T) {
+	index := NewTimeIndex()
+
+	base := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 5; i++ {
+		err := index.Add(SourceGeographicGpx, "data.gpx", base.Add(time.Duration(i)*time.Hour), true, 12.34, 34.56, nil)
+		log.PanicIf(err)
+	}
+
+	ts := index.Range(base.Add(time.Hour), base.Add(3*time.Hour))
+	if len(ts) != 2 {
+		t.Fatalf("Range not correct: %v", ts)
+	} else if ts[0].Time.Equal(base.Add(time.Hour)) == false || ts[1].Time.Equal(base.Add(2*time.Hour)) == false {
+		t.Fatalf("Range has the wrong times: %v", ts)
+	}
+
+	ts = index.Range(base.Add(10*time.Hour), base.Add(11*time.Hour))
+	if len(ts) != 0 {
+		t.Fatalf("Range should be empty: %v", ts)
+	}
+}
+
+func TestTimeIndex_AddRecords(t *testing.T) {
+	index := NewTimeIndex()
+
+	base := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	existing1 := NewGeographicRecord(SourceImageJpeg, "image1.jpg", base.Add(time.Hour), false, 0, 0, nil)
+	existing2 := NewGeographicRecord(SourceImageJpeg, "image2.jpg", base.Add(3*time.Hour), false, 0, 0, nil)
+
+	for _, gr := range []*GeographicRecord{existing1, existing2} {
+		err := index.AddWithRecord(gr)
+		log.PanicIf(err)
+	}
+
+	// Given out of order, and with one sharing a timestamp with an existing
+	// record.
+	added := []*GeographicRecord{
+		NewGeographicRecord(SourceGeographicGpx, "data.gpx", base.Add(4*time.Hour), true, 12.34, 34.56, nil),
+		NewGeographicRecord(SourceGeographicGpx, "data.gpx", base, true, 12.34, 34.56, nil),
+		NewGeographicRecord(SourceGeographicGpx, "data.gpx", base.Add(time.Hour), true, 12.34, 34.56, nil),
+		NewGeographicRecord(SourceGeographicGpx, "data.gpx", base.Add(2*time.Hour), true, 12.34, 34.56, nil),
+	}
+
+	err := index.AddRecords(added)
+	log.PanicIf(err)
+
+	if index.RecordCount() != 6 {
+		t.Fatalf("Record count not correct: (%d)", index.RecordCount())
+	}
+
+	expected := []*GeographicRecord{
+		added[1],
+		existing1,
+		added[2],
+		added[3],
+		existing2,
+		added[0],
+	}
+
+	actual := index.records()
+
+	if reflect.DeepEqual(actual, expected) != true {
+		t.Fatalf("Records not correct: %v", actual)
+	}
+
+	if len(index.ts) != 5 {
+		t.Fatalf("Shared timestamp was not merged: (%d)", len(index.ts))
+	}
+}
+
+// benchmarkTrack returns a one-per-second track of the given length starting
+// at the given time.
+func benchmarkTrack(start time.Time, count int) []*GeographicRecord {
+	records := make([]*GeographicRecord, count)
+	for i := 0; i < count; i++ {
+		records[i] = NewGeographicRecord(SourceGeographicGpx, "data.gpx", start.Add(time.Duration(i)*time.Second), true, 12.34, 34.56, nil)
+	}
+
+	return records
+}
+
+// benchmarkTimeIndexImport measures importing a track into an index that
+// already has a track of the same size that comes later, which is the worst
+// case for inserting one at a time.
+func benchmarkTimeIndexImport(b *testing.B, count int, bulk bool) {
+	earlier := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	later := earlier.AddDate(1, 0, 0)
+
+	existing := benchmarkTrack(later, count)
+	imported := benchmarkTrack(earlier, count)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+
+		index := NewTimeIndex()
+
+		err := index.AddRecords(existing)
+		log.PanicIf(err)
+
+		b.StartTimer()
+
+		if bulk == true {
+			err := index.AddRecords(imported)
+			log.PanicIf(err)
+		} else {
+			for _, gr := range imported {
+				err := index.AddWithRecord(gr)
+				log.PanicIf(err)
+			}
+		}
+	}
+}
+
+func BenchmarkTimeIndex_AddWithRecord_10000(b *testing.B) {
+	benchmarkTimeIndexImport(b, 10000, false)
+}
+
+func BenchmarkTimeIndex_AddWithRecord_100000(b *testing.B) {
+	benchmarkTimeIndexImport(b, 100000, false)
+}
+
+// BenchmarkTimeIndex_AddWithRecord_1000000 is the baseline for
+// `BenchmarkTimeIndex_AddRecords_1000000`. Since it grows quadratically, it
+// takes a very long time. Run it with "-benchtime=1x -timeout=0".
+func BenchmarkTimeIndex_AddWithRecord_1000000(b *testing.B) {
+	benchmarkTimeIndexImport(b, 1000000, false)
+}
+
+func BenchmarkTimeIndex_AddRecords_10000(b *testing.B) {
+	benchmarkTimeIndexImport(b, 10000, true)
+}
+
+func BenchmarkTimeIndex_AddRecords_100000(b *testing.B) {
+	benchmarkTimeIndexImport(b, 100000, true)
+}
+
+func BenchmarkTimeIndex_AddRecords_1000000(b *testing.B) {
+	benchmarkTimeIndexImport(b, 1000000, true)
+}
+
+func TestTimeIndex_Merge(t *testing.T) {
+	now := time.Now()
+
+	gr1 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.34, 34.56, nil)
+	gr2 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now.Add(time.Second), true, 12.34, 34.56, nil)
+
+	// Equal to `gr1` but a different object.
+	gr1Copy := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.34, 34.56, nil)
+
+	policies := map[MergePolicy]int{
+		MergeKeepAll:        3,
+		MergeSkipDuplicates: 2,
+	}
+
+	for policy, expectedCount := range policies {
+		index := NewTimeIndex()
+
+		err := index.AddWithRecord(gr1)
+		log.PanicIf(err)
+
+		other := NewTimeIndex()
+
+		err = other.AddRecords([]*GeographicRecord{gr1Copy, gr2})
+		log.PanicIf(err)
+
+		err = index.Merge(other, policy)
+		log.PanicIf(err)
+
+		if index.RecordCount() != expectedCount {
+			t.Fatalf("Record count for policy (%d) not correct: (%d)", policy, index.RecordCount())
+		}
+	}
+
+	index := NewTimeIndex()
+
+	err := index.AddWithRecord(gr1)
+	log.PanicIf(err)
+
+	other := NewTimeIndex()
+
+	err = other.AddRecords([]*GeographicRecord{gr1Copy, gr2})
+	log.PanicIf(err)
+
+	err = index.Merge(other, MergeFailOnDuplicate)
+	if err != ErrDuplicateRecord {
+		t.Fatalf("Expected duplicate error: %v", err)
+	} else if index.RecordCount() != 1 {
+		t.Fatalf("Records were added despite the failure: (%d)", index.RecordCount())
+	}
+}
+
+func TestTimeIndex_Diff(t *testing.T) {
+	now := time.Now()
+
+	gr1 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.34, 34.56, nil)
+	gr2 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now.Add(time.Second), true, 12.34, 34.56, nil)
+
+	index := NewTimeIndex()
+
+	err := index.AddWithRecord(gr1)
+	log.PanicIf(err)
+
+	other := NewTimeIndex()
+
+	err = other.AddRecords([]*GeographicRecord{gr1, gr2})
+	log.PanicIf(err)
+
+	id := index.Diff(other)
+
+	if len(id.Added) != 1 || id.Added[0] != gr2 {
+		t.Fatalf("Diff not correct: %s", id)
+	} else if len(id.Removed) != 0 || len(id.Changed) != 0 {
+		t.Fatalf("Diff not correct: %s", id)
+	}
+}
+
+func TestTimeIndex_Subset(t *testing.T) {
+	now := time.Now()
+
+	gr1 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.34, 34.56, nil)
+	gr2 := NewGeographicRecord(SourceImageJpeg, "image.jpg", now, false, 0, 0, nil)
+	gr3 := NewGeographicRecord(SourceImageJpeg, "image2.jpg", now.Add(time.Second), false, 0, 0, nil)
+
+	index := NewTimeIndex()
+
+	err := index.AddRecords([]*GeographicRecord{gr1, gr2, gr3})
+	log.PanicIf(err)
+
+	subset, err := index.Subset(func(gr *GeographicRecord) bool {
+		return gr.SourceName == SourceImageJpeg
+	})
+
+	log.PanicIf(err)
+
+	if subset.RecordCount() != 2 {
+		t.Fatalf("Subset record count not correct: (%d)", subset.RecordCount())
+	}
+
+	actual := subset.records()
+	if actual[0] != gr2 || actual[1] != gr3 {
+		t.Fatalf("Subset records not correct: %v", actual)
+	} else if index.RecordCount() != 3 {
+		t.Fatalf("Original index was modified: (%d)", index.RecordCount())
+	}
+}
+
+func TestTimeIndex_GetByFilepath(t *testing.T) {
+	ti := NewTimeIndex()
+
+	now := time.Now()
+
+	gr1 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now.Add(time.Second), true, 12.34, 34.56, nil)
+	gr2 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now, true, 12.35, 34.57, nil)
+	gr3 := NewGeographicRecord(SourceImageJpeg, "image.jpg", now, false, 0, 0, nil)
+
+	err := ti.AddRecords([]*GeographicRecord{gr1, gr2, gr3})
+	log.PanicIf(err)
+
+	records := ti.GetByFilepath("data.gpx")
+	if len(records) != 2 || records[0] != gr2 || records[1] != gr1 {
+		t.Fatalf("Records not correct: %v", records)
+	}
+
+	filepaths := ti.ListFilepaths()
+	if reflect.DeepEqual(filepaths, []string{"data.gpx", "image.jpg"}) != true {
+		t.Fatalf("File-paths not correct: %v", filepaths)
+	}
+
+	err = ti.Remove(gr3)
+	log.PanicIf(err)
+
+	if records := ti.GetByFilepath("image.jpg"); len(records) != 0 {
+		t.Fatalf("Expected no records for removed file: %v", records)
+	}
+
+	filepaths = ti.ListFilepaths()
+	if reflect.DeepEqual(filepaths, []string{"data.gpx"}) != true {
+		t.Fatalf("File-paths not correct after remove: %v", filepaths)
+	}
+
+	// Records added after the first lookup are kept in time order.
+
+	gr4 := NewGeographicRecord(SourceGeographicGpx, "data.gpx", now.Add(-time.Second), true, 12.36, 34.58, nil)
+
+	err = ti.AddWithRecord(gr4)
+	log.PanicIf(err)
+
+	records = ti.GetByFilepath("data.gpx")
+	if len(records) != 3 || records[0] != gr4 || records[1] != gr2 || records[2] != gr1 {
+		t.Fatalf("Records not correct after add: %v", records)
+	}
 }
@@ -7,6 +7,26 @@ const (
 	SourceGeographicGpx = "data-geographic-gpx"
 )
 
+const (
+	// sourceImagePrefix is the prefix of the names of every image source.
+	sourceImagePrefix = "image-"
+)
+
 type ImageMetadata struct {
 	CameraModel string
 }
+
+// recordCameraModel returns the camera model of an image record or an empty
+// string if not known.
+func recordCameraModel(gr *GeographicRecord) string {
+	switch im := gr.Metadata.(type) {
+	case ImageMetadata:
+		return im.CameraModel
+	case *ImageMetadata:
+		if im != nil {
+			return im.CameraModel
+		}
+	}
+
+	return ""
+}